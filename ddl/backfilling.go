@@ -740,12 +740,7 @@ func iterateSnapshotRows(ctx *JobContext, store kv.Storage, priority int, t tabl
 
 	ver := kv.Version{Ver: version}
 	snap := store.GetSnapshot(ver)
-	snap.SetOption(kv.Priority, priority)
-	snap.SetOption(kv.RequestSourceInternal, true)
-	snap.SetOption(kv.RequestSourceType, ctx.ddlJobSourceType())
-	if tagger := ctx.getResourceGroupTaggerForTopSQL(); tagger != nil {
-		snap.SetOption(kv.ResourceGroupTagger, tagger)
-	}
+	setReorgSnapshotOptions(ctx, snap, priority)
 
 	it, err := snap.Iter(firstKey, upperBound)
 	if err != nil {
@@ -781,6 +776,20 @@ func iterateSnapshotRows(ctx *JobContext, store kv.Storage, priority int, t tabl
 	return nil
 }
 
+// setReorgSnapshotOptions sets the options of the snapshot to read the rows to reorganize.
+// The snapshot is read at the snapshot version of the reorg, so it's safe to read it from the followers.
+func setReorgSnapshotOptions(ctx *JobContext, snap kv.Snapshot, priority int) {
+	snap.SetOption(kv.Priority, priority)
+	snap.SetOption(kv.RequestSourceInternal, true)
+	snap.SetOption(kv.RequestSourceType, ctx.ddlJobSourceType())
+	if tagger := ctx.getResourceGroupTaggerForTopSQL(); tagger != nil {
+		snap.SetOption(kv.ResourceGroupTagger, tagger)
+	}
+	if variable.DDLReorgFollowerRead.Load() {
+		snap.SetOption(kv.ReplicaRead, kv.ReplicaReadFollower)
+	}
+}
+
 // getRegionEndKey gets the actual end key for the range of [startKey, endKey].
 func getRangeEndKey(ctx *JobContext, store kv.Storage, priority int, t table.Table, startKey, endKey kv.Key) (kv.Key, error) {
 	snap := store.GetSnapshot(kv.MaxVersion)
@@ -62,8 +62,10 @@ import (
 	"github.com/pingcap/tidb/util/logutil"
 	"github.com/pingcap/tidb/util/mathutil"
 	"github.com/pingcap/tidb/util/sqlexec"
+	"github.com/pingcap/tidb/util/timeutil"
 	"github.com/tikv/client-go/v2/tikvrpc"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
 	atomicutil "go.uber.org/atomic"
 	"go.uber.org/zap"
 	"golang.org/x/exp/slices"
@@ -198,8 +200,9 @@ type DDL interface {
 	GetInfoSchemaWithInterceptor(ctx sessionctx.Context) infoschema.InfoSchema
 	// DoDDLJob does the DDL job, it's exported for test.
 	DoDDLJob(ctx sessionctx.Context, job *model.Job) error
-	// MoveJobFromQueue2Table move existing DDLs from queue to table.
-	MoveJobFromQueue2Table(bool) error
+	// MoveJobFromQueue2Table move existing DDLs from queue to table, the transform is called on each job before
+	// inserting it into the table if it's not nil.
+	MoveJobFromQueue2Table(inBootstrap bool, transform func(*model.Job) error) error
 	// MoveJobFromTable2Queue move existing DDLs from table to queue.
 	MoveJobFromTable2Queue() error
 }
@@ -225,6 +228,8 @@ type ddl struct {
 	generalDDLWorkerPool *workerPool
 	// get notification if any DDL coming.
 	ddlJobCh chan struct{}
+	// reorgJobCh gets the notification of the coming reorg jobs, only the reorg pool is dispatched for it.
+	reorgJobCh chan struct{}
 }
 
 // waitSchemaSyncedController is to control whether to waitSchemaSynced or not.
@@ -285,6 +290,30 @@ type ddlCtx struct {
 	runningJobs struct {
 		sync.RWMutex
 		ids map[int64]struct{}
+		// taken is the IDs of the unfinished jobs the node has run, it tells the reclaimed processing jobs.
+		taken map[int64]struct{}
+	}
+	// runningJobsCh is notified when a job starts or finishes a step, to publish the running jobs to the registry.
+	runningJobsCh chan struct{}
+	// runningJobsRegistry is the state of the node in the running jobs registry, see publishRunningJobs.
+	runningJobsRegistry struct {
+		sync.Mutex
+		session *concurrency.Session
+		// leases is the lease each running job is published under first. A job published under a lost lease is
+		// published again under the new one, but its running step must stop, see checkRunningJobsRegistry.
+		leases map[int64]clientv3.LeaseID
+		// published is the running job IDs published under the lease of session.
+		published   string
+		needPublish bool
+	}
+	// processingJobs caches the IDs of the processing jobs in the job table for the conflict detection of
+	// the reorg jobs. It's invalidated on every dispatch tick and when a job starts or finishes.
+	processingJobs struct {
+		sync.Mutex
+		valid bool
+		// schemaIDs is the schema IDs of the processing drop schema jobs.
+		schemaIDs map[string]struct{}
+		tableIDs  map[string]struct{}
 	}
 	// It holds the running DDL jobs ID.
 	runningJobIDs []string
@@ -306,6 +335,18 @@ type ddlCtx struct {
 		sync.RWMutex
 		hook        Callback
 		interceptor Interceptor
+		// jobArgsRewriter is nil unless it's set by SetJobArgsRewriter.
+		jobArgsRewriter JobArgsRewriter
+		// jobValidator is nil unless it's set by SetJobValidator.
+		jobValidator JobValidator
+		// conflictChecker is nil unless it's set by SetConflictChecker, the default checker is used if it's nil.
+		conflictChecker ConflictChecker
+		// clock is the source of the current time, it's timeutil.RealClock unless it's set by SetClock.
+		clock timeutil.Clock
+		// reorgElementTypeFilter is nil unless it's set by SetReorgElementTypeFilter.
+		reorgElementTypeFilter []byte
+		// resultCh receives the results of the job runs if it's set by SetJobResultCh.
+		resultCh chan<- JobResult
 	}
 
 	ddlSeqNumMu struct {
@@ -314,6 +355,57 @@ type ddlCtx struct {
 	}
 
 	waiting *atomicutil.Bool
+	// draining is set by DrainWorkers, the dispatch loop doesn't dispatch jobs after it's set.
+	draining *atomicutil.Bool
+	// maintenanceMode is synced from etcd, the dispatch loop doesn't dispatch jobs when it's set.
+	maintenanceMode *atomicutil.Bool
+	// dispatchDecisions is the ring buffer of the recent decisions of the dispatch loop, buf[start] is the oldest one
+	// once the buffer is full.
+	dispatchDecisions struct {
+		sync.Mutex
+		buf   []DispatchDecision
+		start int
+	}
+	// lastDispatchTime is the last time the dispatch loop delivered a job to a worker.
+	lastDispatchTime *atomicutil.Time
+	// reorgHandleCompactThreshold is the row count of the reorg handle table to trigger the compaction.
+	reorgHandleCompactThreshold *atomicutil.Int64
+	// reorgCheckpointInterval and reorgCheckpointRowCount throttle persisting the start handle of the reorg jobs,
+	// the start handle is persisted only if either one is reached since the last persistence.
+	reorgCheckpointInterval *atomicutil.Duration
+	reorgCheckpointRowCount *atomicutil.Int64
+	// pendingJobAgeInterval is the interval to report the age of the oldest pending job,
+	// pendingJobAgeIntervalCh is notified when it's changed.
+	pendingJobAgeInterval   *atomicutil.Duration
+	pendingJobAgeIntervalCh chan struct{}
+	// getJobScanLimit is the max count of the candidate jobs decoded by a call of getJob, 0 means unlimited.
+	getJobScanLimit *atomicutil.Int64
+	// tempReorgWorkerLimit is the max count of the temporary reorg workers created when the reorg worker pool is
+	// saturated and a reorg job has been running longer than tempReorgWorkerThreshold, 0 means disabled.
+	tempReorgWorkerLimit     *atomicutil.Int32
+	tempReorgWorkerThreshold *atomicutil.Duration
+	// jobTableHint is the optimizer hint of the query to get the jobs to dispatch, it's empty by default.
+	jobTableHint *atomicutil.String
+	// jobPriorities caches the non-zero dispatch priorities of the jobs in the job table, so the owner orders the jobs
+	// by the priority without extracting it from the job meta of every job. It's loaded again after it's invalidated.
+	jobPriorities struct {
+		sync.Mutex
+		m      map[int64]int
+		loaded bool
+	}
+	// schemaSyncWait is the max time to wait for all TiDB servers to sync the schema change, 0 means 2 * lease.
+	schemaSyncWait *atomicutil.Duration
+	// skipOwnerChangeSyncWait is set by SetSkipOwnerChangeSyncWait, it's only used for the tests.
+	skipOwnerChangeSyncWait *atomicutil.Bool
+	// jobQuarantineThreshold is the count of the consecutive failures to quarantine a job for jobQuarantineBackoff,
+	// 0 means disabled.
+	jobQuarantineThreshold *atomicutil.Int32
+	jobQuarantineBackoff   *atomicutil.Duration
+	// jobFailures is the consecutive failures of the jobs run by the workers of the node, keyed by the job ID.
+	jobFailures struct {
+		sync.Mutex
+		m map[int64]*jobFailure
+	}
 }
 
 // schemaVersionManager is used to manage the schema version. To prevent the conflicts on this key between different DDL job,
@@ -428,6 +520,8 @@ func (dc *ddlCtx) newReorgCtx(r *reorgInfo) *reorgCtx {
 	rc.setRowCount(r.Job.GetRowCount())
 	rc.setNextKey(r.StartKey)
 	rc.setCurrentElement(r.currElement)
+	rc.lastPersistTime = dc.now()
+	rc.lastPersistRowCount = r.Job.GetRowCount()
 	rc.mu.warnings = make(map[errors.ErrorID]*terror.Error)
 	rc.mu.warningsCount = make(map[errors.ErrorID]int64)
 	dc.reorgCtx.Lock()
@@ -558,13 +652,33 @@ func newDDL(ctx context.Context, options ...Option) *ddl {
 	ctx = kv.WithInternalSourceType(ctx, kv.InternalTxnDDL)
 	ddlCtx.ctx, ddlCtx.cancel = context.WithCancel(ctx)
 	ddlCtx.runningJobs.ids = make(map[int64]struct{})
+	ddlCtx.runningJobs.taken = make(map[int64]struct{})
+	ddlCtx.runningJobsCh = make(chan struct{}, 1)
 	ddlCtx.waiting = atomicutil.NewBool(false)
+	ddlCtx.draining = atomicutil.NewBool(false)
+	ddlCtx.maintenanceMode = atomicutil.NewBool(false)
+	ddlCtx.reorgHandleCompactThreshold = atomicutil.NewInt64(defaultReorgHandleCompactThreshold)
+	ddlCtx.reorgCheckpointInterval = atomicutil.NewDuration(0)
+	ddlCtx.reorgCheckpointRowCount = atomicutil.NewInt64(0)
+	ddlCtx.pendingJobAgeInterval = atomicutil.NewDuration(defaultPendingJobAgeInterval)
+	ddlCtx.pendingJobAgeIntervalCh = make(chan struct{}, 1)
+	ddlCtx.getJobScanLimit = atomicutil.NewInt64(defaultGetJobScanLimit)
+	ddlCtx.tempReorgWorkerLimit = atomicutil.NewInt32(0)
+	ddlCtx.tempReorgWorkerThreshold = atomicutil.NewDuration(defaultTempReorgWorkerThreshold)
+	ddlCtx.jobTableHint = atomicutil.NewString("")
+	ddlCtx.schemaSyncWait = atomicutil.NewDuration(0)
+	ddlCtx.skipOwnerChangeSyncWait = atomicutil.NewBool(false)
+	ddlCtx.jobQuarantineThreshold = atomicutil.NewInt32(0)
+	ddlCtx.jobQuarantineBackoff = atomicutil.NewDuration(defaultJobQuarantineBackoff)
+	ddlCtx.jobFailures.m = make(map[int64]*jobFailure)
+	ddlCtx.lastDispatchTime = atomicutil.NewTime(time.Now())
 
 	d := &ddl{
 		ddlCtx:            ddlCtx,
 		limitJobCh:        make(chan *limitJobTask, batchAddingJobs),
 		enableTiFlashPoll: atomicutil.NewBool(true),
 		ddlJobCh:          make(chan struct{}, 100),
+		reorgJobCh:        make(chan struct{}, 100),
 	}
 
 	// Register functions for enable/disable ddl when changing system variable `tidb_enable_ddl`.
@@ -615,6 +729,7 @@ func (d *ddl) prepareWorkers4ConcurrencyDDL() {
 	// reorg worker count at least 1 at most 10.
 	reorgCnt := mathutil.Min(mathutil.Max(runtime.GOMAXPROCS(0)/4, 1), reorgWorkerCnt)
 	d.reorgWorkerPool = newDDLWorkerPool(pools.NewResourcePool(workerFactory(addIdxWorker), reorgCnt, reorgCnt, 0), reorg)
+	d.reorgWorkerPool.tempFactory = workerFactory(addIdxWorker)
 	d.generalDDLWorkerPool = newDDLWorkerPool(pools.NewResourcePool(workerFactory(generalWorker), generalWorkerCnt, generalWorkerCnt, 0), general)
 	failpoint.Inject("NoDDLDispatchLoop", func(val failpoint.Value) {
 		if val.(bool) {
@@ -622,6 +737,9 @@ func (d *ddl) prepareWorkers4ConcurrencyDDL() {
 		}
 	})
 	d.wg.Run(d.startDispatchLoop)
+	if d.etcdCli != nil {
+		d.wg.Run(d.startPublishRunningJobs)
+	}
 }
 
 func (d *ddl) prepareWorkers4legacyDDL() {
@@ -679,6 +797,7 @@ func (d *ddl) Start(ctxPool *pools.ResourcePool) error {
 
 	// Start some background routine to manage TiFlash replica.
 	d.wg.Run(d.PollTiFlashRoutine)
+	d.wg.Run(d.startReportPendingJobAge)
 
 	return nil
 }
@@ -794,6 +913,24 @@ func (d *ddl) genGlobalIDs(count int) ([]int64, error) {
 	return ret, err
 }
 
+// PeekNextJobID returns the ID the next DDL job is going to be allocated, without consuming it.
+// The job IDs are allocated from the global ID allocator, which is shared by the jobs, schemas, tables
+// and so on, and other submitters may allocate IDs concurrently. So the result is only a hint, it's
+// reliable only if there is a single submitter and no other ID is allocated before the job is submitted.
+func (*ddl) PeekNextJobID(sctx sessionctx.Context) (int64, error) {
+	var id int64
+	ctx := kv.WithInternalSourceType(context.Background(), kv.InternalTxnDDL)
+	err := kv.RunInNewTxn(ctx, sctx.GetStore(), false, func(ctx context.Context, txn kv.Transaction) error {
+		var err error
+		id, err = meta.NewMeta(txn).GetGlobalID()
+		return err
+	})
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return id + 1, nil
+}
+
 func (d *ddl) genPlacementPolicyID() (int64, error) {
 	var ret int64
 	ctx := kv.WithInternalSourceType(context.Background(), kv.InternalTxnDDL)
@@ -866,8 +1003,16 @@ func (d *ddl) asyncNotifyWorker(job *model.Job) {
 	}
 	if variable.EnableConcurrentDDL.Load() {
 		if d.isOwner() {
-			asyncNotify(d.ddlJobCh)
+			if job.MayNeedReorg() {
+				asyncNotify(d.reorgJobCh)
+			} else {
+				asyncNotify(d.ddlJobCh)
+			}
 		} else {
+			if job.MayNeedReorg() {
+				d.asyncNotifyByEtcd(addingDDLJobReorgConcurrent, job)
+			}
+			// The owner of the previous versions only watches addingDDLJobConcurrent, so it's always notified.
 			d.asyncNotifyByEtcd(addingDDLJobConcurrent, job)
 		}
 	} else {
@@ -912,6 +1057,22 @@ func setDDLJobQuery(ctx sessionctx.Context, job *model.Job) {
 	}
 }
 
+// DoPreValidatedDDLJob is like DoDDLJob, but the job has been validated by a trusted caller.
+// The checksum should be calculated by JobValidationChecksum, the worker skips the validation
+// of the job only if the checksum matches.
+func (d *ddl) DoPreValidatedDDLJob(ctx sessionctx.Context, job *model.Job, checksum uint32) error {
+	job.ValidationChecksum = checksum
+	return d.DoDDLJob(ctx, job)
+}
+
+// DoDDLJobWithTimeout is like DoDDLJob, but the job is cancelled if it's not finished in the timeout.
+// The timeout is persisted with the job and counted from the submit time, so it's still enforced
+// after the DDL owner changes.
+func (d *ddl) DoDDLJobWithTimeout(ctx sessionctx.Context, job *model.Job, timeout time.Duration) error {
+	job.Timeout = timeout
+	return d.DoDDLJob(ctx, job)
+}
+
 // DoDDLJob will return
 // - nil: found in history DDL job and no job error
 // - context.Cancel: job has been sent to worker, but not found in history DDL job before cancel
@@ -1146,7 +1307,7 @@ func (d *ddl) SwitchConcurrentDDL(toConcurrentDDL bool) error {
 
 	var err error
 	if toConcurrentDDL {
-		err = d.MoveJobFromQueue2Table(false)
+		err = d.MoveJobFromQueue2Table(false, nil)
 	} else {
 		err = d.MoveJobFromTable2Queue()
 	}
@@ -1157,6 +1318,57 @@ func (d *ddl) SwitchConcurrentDDL(toConcurrentDDL bool) error {
 	return err
 }
 
+// ConcurrentDDLEnabled reports whether concurrent DDL is enabled at runtime, i.e. both the variable and the flag
+// in the meta are set. An error is returned if they disagree, which may happen while switching the DDL mode.
+func (d *ddl) ConcurrentDDLEnabled() (bool, error) {
+	enabled := variable.EnableConcurrentDDL.Load()
+	var isConcurrentDDL bool
+	err := kv.RunInNewTxn(kv.WithInternalSourceType(context.Background(), kv.InternalTxnDDL), d.store, false, func(ctx context.Context, txn kv.Transaction) error {
+		var err error
+		isConcurrentDDL, err = meta.NewMeta(txn).IsConcurrentDDL()
+		return err
+	})
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	if enabled != isConcurrentDDL {
+		return false, errors.Errorf("the concurrent ddl variable is %t, but the flag in the meta is %t", enabled, isConcurrentDDL)
+	}
+	return enabled, nil
+}
+
+// WarmSessionPool creates n sessions in the session pool in advance, so the first jobs after a restart don't
+// pay for creating the sessions. The sessions beyond the capacity of the pool or in use are not waited for.
+func (d *ddl) WarmSessionPool(n int) error {
+	if d.sessPool == nil {
+		return errors.New("ddl is not started")
+	}
+	warmed, err := d.sessPool.warm(n)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	logutil.BgLogger().Info("[ddl] warm session pool", zap.Int("expected", n), zap.Int("warmed", warmed))
+	return nil
+}
+
+// DrainWorkers stops dispatching new DDL jobs and waits for the running jobs to finish their current step,
+// it returns an error if the running jobs are not finished in the timeout. It's used before shutting down
+// the DDL, so that a job isn't interrupted in the middle of a step. The DDL doesn't dispatch jobs anymore
+// after it's called.
+func (d *ddl) DrainWorkers(timeout time.Duration) error {
+	d.draining.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := d.wait4Switch(ctx); err != nil {
+		d.runningJobs.RLock()
+		cnt := len(d.runningJobs.ids)
+		d.runningJobs.RUnlock()
+		return errors.Annotatef(err, "drain ddl workers timeout, %d jobs are still running", cnt)
+	}
+	logutil.BgLogger().Info("[ddl] ddl workers are drained")
+	return nil
+}
+
 func (d *ddl) wait4Switch(ctx context.Context) error {
 	for {
 		select {
@@ -1484,7 +1696,10 @@ func cancelConcurrencyJobs(se sessionctx.Context, ids []int64) ([]error, error)
 			errs[i] = errors.Trace(err)
 			continue
 		}
-		err = updateDDLJob2Table(sess, job, true)
+		b, err := reencodeJobRawArgs(job)
+		if err == nil {
+			err = updateJobMeta2Table(sess, job.ID, b)
+		}
 		if err != nil {
 			errs[i] = errors.Trace(err)
 		}
@@ -1617,7 +1832,9 @@ func (s *session) begin() error {
 }
 
 func (s *session) commit() error {
-	s.StmtCommit()
+	if err := s.StmtCommit(); err != nil {
+		return err
+	}
 	return s.CommitTxn(context.Background())
 }
 
@@ -1640,7 +1857,9 @@ func (s *session) execute(ctx context.Context, query string, label string) ([]ch
 	defer func() {
 		metrics.DDLJobTableDuration.WithLabelValues(label + "-" + metrics.RetLabel(err)).Observe(time.Since(startTime).Seconds())
 	}()
-	rs, err := s.Context.(sqlexec.SQLExecutor).ExecuteInternal(kv.WithInternalSourceType(ctx, kv.InternalTxnDDL), query)
+	// Both executing and draining the result should be tagged as the internal DDL requests.
+	ctx = kv.WithInternalSourceType(ctx, kv.InternalTxnDDL)
+	rs, err := s.Context.(sqlexec.SQLExecutor).ExecuteInternal(ctx, query)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -16,13 +16,19 @@ package ddl
 
 import (
 	"context"
+	"fmt"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ngaut/pools"
+	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
 	"github.com/pingcap/tidb/infoschema"
 	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/meta"
+	"github.com/pingcap/tidb/owner"
 	"github.com/pingcap/tidb/parser"
 	"github.com/pingcap/tidb/parser/ast"
 	"github.com/pingcap/tidb/parser/charset"
@@ -30,12 +36,20 @@ import (
 	"github.com/pingcap/tidb/parser/mysql"
 	"github.com/pingcap/tidb/parser/terror"
 	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/sessionctx/variable"
 	"github.com/pingcap/tidb/store/mockstore"
 	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/chunk"
 	"github.com/pingcap/tidb/util/dbterror"
 	"github.com/pingcap/tidb/util/mock"
+	"github.com/pingcap/tidb/util/sqlexec"
+	"github.com/pingcap/tidb/util/timeutil"
 	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/tests/v3/integration"
+	atomicutil "go.uber.org/atomic"
+	"go.uber.org/zap"
 )
 
 const testLease = 5 * time.Millisecond
@@ -293,6 +307,10 @@ func TestNotifyDDLJob(t *testing.T) {
 		case <-d.ddlJobCh:
 		default:
 		}
+		select {
+		case <-d.reorgJobCh:
+		default:
+		}
 	}
 
 	d, err := testNewDDLAndStart(
@@ -333,7 +351,7 @@ func TestNotifyDDLJob(t *testing.T) {
 	d.asyncNotifyWorker(job)
 	select {
 	case <-d.workers[addIdxWorker].ddlJobCh:
-	case <-d.ddlJobCh:
+	case <-d.reorgJobCh:
 	default:
 		require.FailNow(t, "do not get the add index job notification")
 	}
@@ -367,6 +385,8 @@ func TestNotifyDDLJob(t *testing.T) {
 		require.FailNow(t, "should not get the general job notification")
 	case <-d1.ddlJobCh:
 		require.FailNow(t, "should not get the job notification")
+	case <-d1.reorgJobCh:
+		require.FailNow(t, "should not get the reorg job notification")
 	default:
 	}
 }
@@ -383,3 +403,535 @@ func TestError(t *testing.T) {
 		require.Equal(t, uint16(err.Code()), code)
 	}
 }
+
+type sourceRecordingRecordSet struct {
+	sqlexec.RecordSet
+	sources *[]interface{}
+}
+
+func (rs sourceRecordingRecordSet) Next(ctx context.Context, _ *chunk.Chunk) error {
+	*rs.sources = append(*rs.sources, ctx.Value(kv.RequestSourceKey))
+	return nil
+}
+
+func (sourceRecordingRecordSet) NewChunk(chunk.Allocator) *chunk.Chunk {
+	return chunk.NewChunkWithCapacity([]*types.FieldType{types.NewFieldType(mysql.TypeLonglong)}, 1)
+}
+
+func (sourceRecordingRecordSet) Close() error {
+	return nil
+}
+
+type sourceRecordingContext struct {
+	*mock.Context
+	sources []interface{}
+}
+
+func (c *sourceRecordingContext) ExecuteInternal(ctx context.Context, _ string, _ ...interface{}) (sqlexec.RecordSet, error) {
+	c.sources = append(c.sources, ctx.Value(kv.RequestSourceKey))
+	return sourceRecordingRecordSet{sources: &c.sources}, nil
+}
+
+type sqlRecordingContext struct {
+	*mock.Context
+	sqls []string
+}
+
+func (c *sqlRecordingContext) ExecuteInternal(_ context.Context, sql string, _ ...interface{}) (sqlexec.RecordSet, error) {
+	c.sqls = append(c.sqls, sql)
+	return nil, nil
+}
+
+func TestSkipUnchangedJobMetaUpdate(t *testing.T) {
+	sctx := &sqlRecordingContext{Context: mock.NewContext()}
+	w := &worker{sess: newSession(sctx)}
+	job := &model.Job{ID: 1, Type: model.ActionAddIndex, BinlogInfo: &model.HistoryInfo{}}
+	require.NoError(t, w.updateDDLJob2TableIfChanged(job, true))
+	require.NoError(t, w.updateDDLJob2TableIfChanged(job, true))
+	require.Len(t, sctx.sqls, 1)
+
+	job.RowCount = 100
+	require.NoError(t, w.updateDDLJob2TableIfChanged(job, true))
+	require.Len(t, sctx.sqls, 2)
+
+	// The job meta is rewritten if the last write may not be committed.
+	w.resetLastJobMeta()
+	require.NoError(t, w.updateDDLJob2TableIfChanged(job, true))
+	require.Len(t, sctx.sqls, 3)
+}
+
+func TestSessionExecuteInternalSource(t *testing.T) {
+	sctx := &sourceRecordingContext{Context: mock.NewContext()}
+	_, err := newSession(sctx).execute(context.Background(), "select 1", "test")
+	require.NoError(t, err)
+	expected := kv.RequestSource{RequestSourceInternal: true, RequestSourceType: kv.InternalTxnDDL}
+	// Both executing the query and draining the result carry the internal source type.
+	require.Equal(t, []interface{}{expected, expected}, sctx.sources)
+}
+
+func TestNeedPersistStartHandle(t *testing.T) {
+	now := time.Now()
+	rc := &reorgCtx{lastPersistTime: now, lastPersistRowCount: 100}
+	// A zero interval persists the start handle every time.
+	require.True(t, rc.needPersistStartHandle(now, 0, 0, 100))
+	require.False(t, rc.needPersistStartHandle(now, time.Hour, 0, 1000))
+	require.False(t, rc.needPersistStartHandle(now, time.Hour, 1000, 1099))
+	require.True(t, rc.needPersistStartHandle(now, time.Hour, 1000, 1100))
+
+	require.True(t, rc.needPersistStartHandle(now.Add(2*time.Hour), time.Hour, 1000, 100))
+}
+
+func TestNewReorgCtxClock(t *testing.T) {
+	dc := &ddlCtx{}
+	dc.reorgCtx.reorgCtxMap = make(map[int64]*reorgCtx)
+	clock := timeutil.NewFakeClock(time.Now().Add(-time.Hour))
+	dc.mu.clock = clock
+	// The checkpoint interval is measured by the same clock as the persistence of the start handle.
+	rc := dc.newReorgCtx(&reorgInfo{Job: &model.Job{ID: 1}})
+	require.Equal(t, clock.Now(), rc.lastPersistTime)
+	require.False(t, rc.needPersistStartHandle(dc.now(), time.Minute, 0, 0))
+	clock.Advance(time.Minute)
+	require.True(t, rc.needPersistStartHandle(dc.now(), time.Minute, 0, 0))
+}
+
+type optionRecordingSnapshot struct {
+	kv.Snapshot
+	options map[int]interface{}
+}
+
+func (s *optionRecordingSnapshot) SetOption(opt int, val interface{}) {
+	s.options[opt] = val
+}
+
+func TestReorgSnapshotFollowerRead(t *testing.T) {
+	snap := &optionRecordingSnapshot{options: make(map[int]interface{})}
+	setReorgSnapshotOptions(NewJobContext(), snap, kv.PriorityLow)
+	require.Equal(t, kv.PriorityLow, snap.options[kv.Priority])
+	require.NotContains(t, snap.options, kv.ReplicaRead)
+
+	variable.DDLReorgFollowerRead.Store(true)
+	defer variable.DDLReorgFollowerRead.Store(variable.DefTiDBDDLReorgFollowerRead)
+	snap = &optionRecordingSnapshot{options: make(map[int]interface{})}
+	setReorgSnapshotOptions(NewJobContext(), snap, kv.PriorityLow)
+	require.Equal(t, kv.ReplicaReadFollower, snap.options[kv.ReplicaRead])
+}
+
+func TestJobZapFields(t *testing.T) {
+	job := &model.Job{ID: 1, Type: model.ActionAddIndex, SchemaID: 2, TableID: 3}
+	require.Equal(t, []zap.Field{
+		zap.Int64("jobID", 1), zap.String("type", "add index"), zap.String("schemaIDs", "2"), zap.String("tableIDs", "3"),
+	}, jobZapFields(job))
+
+	// The job loaded from the job table doesn't have CtxVars.
+	job = &model.Job{ID: 1, Type: model.ActionRenameTable, SchemaID: 2, TableID: 3}
+	require.Equal(t, []zap.Field{
+		zap.Int64("jobID", 1), zap.String("type", "rename table"), zap.Int64("schemaID", 2), zap.Int64("tableID", 3),
+	}, jobZapFields(job))
+	job.CtxVars = []interface{}{[]int64{2, 4}, []int64{3}}
+	require.Equal(t, []zap.Field{
+		zap.Int64("jobID", 1), zap.String("type", "rename table"), zap.String("schemaIDs", "2,4"), zap.String("tableIDs", "3"),
+	}, jobZapFields(job))
+}
+
+type closeRecorder struct {
+	closed bool
+}
+
+func (r *closeRecorder) Close() {
+	r.closed = true
+}
+
+func TestWarmSessionPool(t *testing.T) {
+	created := 0
+	resPool := pools.NewResourcePool(func() (pools.Resource, error) {
+		created++
+		return &closeRecorder{}, nil
+	}, 2, 2, 0)
+	sp := newSessionPool(resPool, nil)
+
+	// The sessions beyond the capacity are not waited for.
+	warmed, err := sp.warm(3)
+	require.NoError(t, err)
+	require.Equal(t, 2, warmed)
+	require.Equal(t, 2, created)
+	require.Equal(t, int64(2), resPool.Available())
+
+	// The warmed sessions are reused, and the sessions in use are not waited for.
+	res, err := resPool.Get()
+	require.NoError(t, err)
+	warmed, err = sp.warm(2)
+	require.NoError(t, err)
+	require.Equal(t, 1, warmed)
+	require.Equal(t, 2, created)
+	resPool.Put(res)
+
+	sp.close()
+	_, err = sp.warm(1)
+	require.EqualError(t, err, "sessionPool is closed")
+}
+
+func TestReencodeJobRawArgs(t *testing.T) {
+	job := &model.Job{ID: 1, Type: model.ActionModifyTableComment, Args: []interface{}{"old"}, BinlogInfo: &model.HistoryInfo{}}
+	b, err := job.Encode(true)
+	require.NoError(t, err)
+	require.NoError(t, job.Decode(b))
+	job.Args = []interface{}{"new"}
+
+	// The stale RawArgs is kept without re-serializing.
+	b, err = job.Encode(false)
+	require.NoError(t, err)
+	decoded := &model.Job{}
+	require.NoError(t, decoded.Decode(b))
+	require.Equal(t, `["old"]`, string(decoded.RawArgs))
+
+	b, err = reencodeJobRawArgs(job)
+	require.NoError(t, err)
+	require.NoError(t, decoded.Decode(b))
+	require.Equal(t, `["new"]`, string(decoded.RawArgs))
+}
+
+func TestTempWorker(t *testing.T) {
+	created := 0
+	wp := newDDLWorkerPool(pools.NewResourcePool(func() (pools.Resource, error) {
+		return &worker{logCtx: context.Background()}, nil
+	}, 1, 1, 0), reorg)
+	defer wp.close()
+	// The pool without the factory doesn't create temporary workers.
+	wk, err := wp.getTemp(1)
+	require.NoError(t, err)
+	require.Nil(t, wk)
+
+	wp.tempFactory = func() (pools.Resource, error) {
+		created++
+		return &worker{logCtx: context.Background()}, nil
+	}
+	wk1, err := wp.getTemp(2)
+	require.NoError(t, err)
+	require.True(t, wk1.temp)
+	wk2, err := wp.getTemp(2)
+	require.NoError(t, err)
+	require.NotNil(t, wk2)
+	wk, err = wp.getTemp(2)
+	require.NoError(t, err)
+	require.Nil(t, wk)
+	require.Equal(t, 2, created)
+
+	// The temporary worker is closed instead of being put back.
+	wp.put(wk1)
+	require.Equal(t, int32(1), wp.tempCnt.Load())
+	require.Equal(t, int64(1), wp.resPool.Available())
+	wk, err = wp.getTemp(2)
+	require.NoError(t, err)
+	require.NotNil(t, wk)
+	require.Equal(t, 3, created)
+	wp.put(wk)
+	wp.put(wk2)
+	require.Equal(t, int32(0), wp.tempCnt.Load())
+}
+
+func TestRunningJobsRegistry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("integration.NewClusterV3 will create file contains a colon which is not allowed on Windows")
+	}
+	integration.BeforeTestExternal(t)
+	cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer cluster.Terminate(t)
+	cli := cluster.RandClient()
+
+	newTestDDL := func(id string) (*ddl, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(context.Background())
+		dc := &ddlCtx{uuid: id, etcdCli: cli, ctx: ctx, runningJobsCh: make(chan struct{}, 1)}
+		dc.runningJobs.ids = make(map[int64]struct{})
+		return &ddl{ddlCtx: dc}, cancel
+	}
+	d1, cancel1 := newTestDDL("node1")
+	defer cancel1()
+	d2, cancel2 := newTestDDL("node2")
+	d1.insertRunningDDLJobMap(1)
+	d2.insertRunningDDLJobMap(2)
+	d2.insertRunningDDLJobMap(3)
+	d2.wg.Run(d2.startPublishRunningJobs)
+
+	// The jobs of the node itself are excluded.
+	require.Eventually(t, func() bool {
+		jobs, err := d1.getJobsRunByOthers()
+		require.NoError(t, err)
+		return len(jobs) == 2 && jobs[2] == "node2" && jobs[3] == "node2"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	d2.deleteRunningDDLJobMap(3)
+	require.Eventually(t, func() bool {
+		jobs, err := d1.getJobsRunByOthers()
+		require.NoError(t, err)
+		return len(jobs) == 1 && jobs[2] == "node2"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The jobs become orphaned once the node is closed.
+	cancel2()
+	d2.wg.Wait()
+	jobs, err := d1.getJobsRunByOthers()
+	require.NoError(t, err)
+	require.Len(t, jobs, 0)
+}
+
+func TestRunningJobsRegistryLeaseLost(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("integration.NewClusterV3 will create file contains a colon which is not allowed on Windows")
+	}
+	integration.BeforeTestExternal(t)
+	cluster := integration.NewClusterV3(t, &integration.ClusterConfig{Size: 1})
+	defer cluster.Terminate(t)
+	cli := cluster.RandClient()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d1 := &ddl{ddlCtx: &ddlCtx{uuid: "node1", etcdCli: cli, ctx: ctx, runningJobsCh: make(chan struct{}, 1)}}
+	d1.runningJobs.ids = make(map[int64]struct{})
+	d2 := &ddl{ddlCtx: &ddlCtx{uuid: "node2", etcdCli: cli, ctx: ctx}}
+
+	// The job not delivered by the dispatch loop isn't checked.
+	require.NoError(t, d1.checkRunningJobsRegistry(1))
+	d1.insertRunningDDLJobMap(1)
+	require.Error(t, d1.checkRunningJobsRegistry(1))
+	// The job is visible to the owner once it's published.
+	require.NoError(t, d1.publishRunningJobs())
+	require.NoError(t, d1.checkRunningJobsRegistry(1))
+	jobs, err := d2.getJobsRunByOthers()
+	require.NoError(t, err)
+	require.Equal(t, "node1", jobs[1])
+
+	// The running step must stop once the lease is lost, even if the job is published again under a new lease.
+	_, err = cli.Revoke(context.Background(), d1.runningJobsRegistry.session.Lease())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return d1.checkRunningJobsRegistry(1) != nil
+	}, 10*time.Second, 10*time.Millisecond)
+	require.NoError(t, d1.publishRunningJobs())
+	require.Error(t, d1.checkRunningJobsRegistry(1))
+	jobs, err = d2.getJobsRunByOthers()
+	require.NoError(t, err)
+	require.Equal(t, "node1", jobs[1])
+
+	// The next step of the job runs under the new lease.
+	d1.deleteRunningDDLJobMap(1)
+	d1.insertRunningDDLJobMap(1)
+	require.NoError(t, d1.publishRunningJobs())
+	require.NoError(t, d1.checkRunningJobsRegistry(1))
+}
+
+func TestBuildGetJobSQL(t *testing.T) {
+	dc := &ddlCtx{
+		getJobScanLimit: atomicutil.NewInt64(0),
+		jobTableHint:    atomicutil.NewString(""),
+	}
+	dc.runningJobs.ids = map[int64]struct{}{1: {}}
+	require.Equal(t, fmt.Sprintf(getJobSQL, "not", "and job_id not in (1)", ""), dc.buildGetJobSQL(general))
+
+	// The jobs are ordered by the cached priorities without extracting them from the job meta.
+	dc.jobPriorities.m = map[int64]int{3: -1, 2: 5}
+	sql := dc.buildGetJobSQL(general)
+	require.True(t, strings.HasSuffix(sql, " order by processing desc, case job_id when 2 then 5 when 3 then -1 else 0 end desc, job_id"), sql)
+	require.NotContains(t, sql, "json_extract")
+	dc.jobPriorities.m = nil
+
+	dc.jobTableHint.Store("use_index(tidb_ddl_job, primary)")
+	dc.getJobScanLimit.Store(10)
+	sql = dc.buildGetJobSQL(reorg)
+	require.True(t, strings.HasPrefix(sql, "select /*+ use_index(tidb_ddl_job, primary) */ job_meta, processing, job_id from mysql.tidb_ddl_job "), sql)
+	require.True(t, strings.HasSuffix(sql, " limit 10"), sql)
+	// The hint only applies to the outer query.
+	require.Equal(t, 1, strings.Count(sql, "/*+"))
+}
+
+func TestSchemaSyncWait(t *testing.T) {
+	d := &ddl{ddlCtx: &ddlCtx{lease: time.Second, schemaSyncWait: atomicutil.NewDuration(0)}}
+	require.Equal(t, 2*time.Second, d.getSchemaSyncWait())
+	require.NoError(t, d.SetSchemaSyncWait(5*time.Second))
+	require.Equal(t, 5*time.Second, d.getSchemaSyncWait())
+	// The wait can't be shorter than the lease.
+	require.ErrorContains(t, d.SetSchemaSyncWait(500*time.Millisecond), "shorter than the lease")
+	require.Equal(t, 5*time.Second, d.getSchemaSyncWait())
+	require.NoError(t, d.SetSchemaSyncWait(time.Second))
+	require.Equal(t, time.Second, d.getSchemaSyncWait())
+	require.NoError(t, d.SetSchemaSyncWait(0))
+	require.Equal(t, 2*time.Second, d.getSchemaSyncWait())
+}
+
+func TestSkipOwnerChangeSyncWait(t *testing.T) {
+	d := &ddl{ddlCtx: &ddlCtx{
+		waitSchemaSyncedController: newWaitSchemaSyncedController(),
+		skipOwnerChangeSyncWait:    atomicutil.NewBool(false),
+	}}
+	job := &model.Job{ID: 1}
+	// The first job after the node becomes the owner waits for the schema sync by default.
+	require.True(t, d.needWaitSchemaSynced(job))
+	d.SetSkipOwnerChangeSyncWait(true)
+	require.False(t, d.needWaitSchemaSynced(job))
+	// The jobs which aren't synced yet always wait.
+	d.registerSync(job)
+	require.True(t, d.needWaitSchemaSynced(job))
+	d.synced(job)
+	require.False(t, d.needWaitSchemaSynced(job))
+	d.SetSkipOwnerChangeSyncWait(false)
+	require.True(t, d.needWaitSchemaSynced(job))
+	d.once.Store(false)
+	require.False(t, d.needWaitSchemaSynced(job))
+}
+
+func TestJobQuarantine(t *testing.T) {
+	d := &ddl{ddlCtx: &ddlCtx{
+		jobQuarantineThreshold: atomicutil.NewInt32(0),
+		jobQuarantineBackoff:   atomicutil.NewDuration(defaultJobQuarantineBackoff),
+	}}
+	d.jobFailures.m = make(map[int64]*jobFailure)
+	clock := timeutil.NewFakeClock(time.Now())
+	d.SetClock(clock)
+	job := &model.Job{ID: 1, Type: model.ActionCreateTable}
+	mockErr := errors.New("mock error")
+
+	// It's disabled by default.
+	for i := 0; i < 10; i++ {
+		d.recordJobFailure(job, mockErr)
+	}
+	require.False(t, d.isJobQuarantined(job.ID))
+	require.Empty(t, d.jobFailures.m)
+
+	d.SetJobQuarantine(3, time.Minute)
+	d.recordJobFailure(job, nil)
+	d.recordJobFailure(job, mockErr)
+	d.recordJobFailure(job, mockErr)
+	require.False(t, d.isJobQuarantined(job.ID))
+	// A success resets the count.
+	d.recordJobFailure(job, nil)
+	d.recordJobFailure(job, mockErr)
+	d.recordJobFailure(job, mockErr)
+	require.False(t, d.isJobQuarantined(job.ID))
+	d.recordJobFailure(job, mockErr)
+	require.True(t, d.isJobQuarantined(job.ID))
+	require.False(t, d.isJobQuarantined(2))
+
+	// The job is dispatched again after the backoff.
+	clock.Advance(59 * time.Second)
+	require.True(t, d.isJobQuarantined(job.ID))
+	clock.Advance(time.Second)
+	require.False(t, d.isJobQuarantined(job.ID))
+	d.recordJobFailure(job, nil)
+	require.Empty(t, d.jobFailures.m)
+}
+
+func TestRecentDispatchDecisions(t *testing.T) {
+	d := &ddl{ddlCtx: &ddlCtx{}}
+	require.Len(t, d.RecentDispatchDecisions(), 0)
+
+	// The consecutive same decisions are merged.
+	d.recordDispatchDecision(general, 0, DispatchReasonNoRunnableJob, nil)
+	d.recordDispatchDecision(general, 0, DispatchReasonNoRunnableJob, nil)
+	d.recordDispatchDecision(reorg, 1, DispatchReasonBlocked, nil)
+	d.recordDispatchDecision(general, 0, DispatchReasonGetJobFailed, errors.New("mock error"))
+	decisions := d.RecentDispatchDecisions()
+	require.Len(t, decisions, 3)
+	require.Equal(t, DispatchReasonNoRunnableJob, decisions[0].Reason)
+	require.Equal(t, 2, decisions[0].Count)
+	require.Equal(t, "reorg", decisions[1].Pool)
+	require.Equal(t, int64(1), decisions[1].JobID)
+	require.Equal(t, DispatchReasonBlocked, decisions[1].Reason)
+	require.EqualError(t, decisions[2].Err, "mock error")
+
+	// The oldest decisions are overwritten once the buffer is full.
+	for i := 0; i < maxDispatchDecisions+10; i++ {
+		d.recordDispatchDecision(general, int64(i+100), DispatchReasonDispatched, nil)
+	}
+	decisions = d.RecentDispatchDecisions()
+	require.Len(t, decisions, maxDispatchDecisions)
+	for i, decision := range decisions {
+		require.Equal(t, int64(i+110), decision.JobID)
+		require.Equal(t, 1, decision.Count)
+	}
+}
+
+func TestAsyncNotifyReorgJob(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	ownerManager := owner.NewMockManager(context.Background(), "1")
+	require.NoError(t, ownerManager.CampaignOwner())
+	d := &ddl{
+		ddlCtx:     &ddlCtx{ownerManager: ownerManager},
+		ddlJobCh:   make(chan struct{}, 1),
+		reorgJobCh: make(chan struct{}, 1),
+	}
+
+	// The reorg jobs are notified by reorgJobCh, so they aren't masked by the general jobs.
+	d.asyncNotifyWorker(&model.Job{Type: model.ActionCreateTable})
+	d.asyncNotifyWorker(&model.Job{Type: model.ActionCreateTable})
+	d.asyncNotifyWorker(&model.Job{Type: model.ActionAddIndex})
+	require.Len(t, d.ddlJobCh, 1)
+	require.Len(t, d.reorgJobCh, 1)
+	<-d.ddlJobCh
+	d.asyncNotifyWorker(&model.Job{Type: model.ActionAddPrimaryKey})
+	require.Len(t, d.ddlJobCh, 0)
+	require.Len(t, d.reorgJobCh, 1)
+}
+
+func TestExportedJob2IDs(t *testing.T) {
+	for _, job := range []*model.Job{
+		{ID: 1, Type: model.ActionCreateTable, SchemaID: 1, TableID: 10},
+		{ID: 2, Type: model.ActionAddIndex, SchemaID: 2, TableID: 20},
+		{ID: 3, Type: model.ActionRenameTable, SchemaID: 1, TableID: 10, CtxVars: []interface{}{[]int64{2, 1}, []int64{10}}},
+		{ID: 4, Type: model.ActionRenameTables, CtxVars: []interface{}{[]int64{3, 1, 3}, []int64{30, 10, 20}}},
+		{ID: 5, Type: model.ActionExchangeTablePartition, CtxVars: []interface{}{[]int64{1, 2}, []int64{10, 20}}},
+		// The malformed CtxVars.
+		{ID: 6, Type: model.ActionRenameTable, CtxVars: []interface{}{[]int64{1}}},
+	} {
+		schemaIDs, schemaErr := job2SchemaIDs(job)
+		exportedSchemaIDs, exportedSchemaErr := Job2SchemaIDs(job)
+		require.Equal(t, schemaIDs, exportedSchemaIDs)
+		require.Equal(t, fmt.Sprint(schemaErr), fmt.Sprint(exportedSchemaErr))
+		tableIDs, tableErr := job2TableIDs(job)
+		exportedTableIDs, exportedTableErr := Job2TableIDs(job)
+		require.Equal(t, tableIDs, exportedTableIDs)
+		require.Equal(t, fmt.Sprint(tableErr), fmt.Sprint(exportedTableErr))
+	}
+
+	// The IDs of the multi-table jobs are sorted and deduplicated.
+	schemaIDs, err := Job2SchemaIDs(&model.Job{Type: model.ActionRenameTables, CtxVars: []interface{}{[]int64{3, 1, 3}, []int64{30, 10, 20}}})
+	require.NoError(t, err)
+	require.Equal(t, "1,3", schemaIDs)
+	tableIDs, err := Job2TableIDs(&model.Job{Type: model.ActionRenameTables, CtxVars: []interface{}{[]int64{3, 1, 3}, []int64{30, 10, 20}}})
+	require.NoError(t, err)
+	require.Equal(t, "10,20,30", tableIDs)
+	tableIDs, err = Job2TableIDs(&model.Job{Type: model.ActionRenameTables, CtxVars: []interface{}{[]int64{1}, []int64{10, 9}}})
+	require.NoError(t, err)
+	require.Equal(t, "9,10", tableIDs)
+	_, err = Job2SchemaIDs(&model.Job{ID: 6, Type: model.ActionRenameTable})
+	require.ErrorContains(t, err, "malformed CtxVars of the rename table job 6")
+}
+
+type compactingStore struct {
+	kv.Storage
+	ranges []kv.KeyRange
+}
+
+func (s *compactingStore) CompactKeyRange(_ context.Context, startKey, endKey kv.Key) error {
+	s.ranges = append(s.ranges, kv.KeyRange{StartKey: startKey, EndKey: endKey})
+	return nil
+}
+
+func TestCompactJobTable(t *testing.T) {
+	store, err := mockstore.NewMockStore()
+	require.NoError(t, err)
+	defer func() { require.NoError(t, store.Close()) }()
+
+	// It's a no-op if the store doesn't support compaction.
+	d := &ddl{ddlCtx: &ddlCtx{store: store}}
+	require.NoError(t, d.CompactJobTable())
+
+	cs := &compactingStore{Storage: store}
+	d = &ddl{ddlCtx: &ddlCtx{store: cs}}
+	require.NoError(t, d.CompactJobTable())
+	require.Len(t, cs.ranges, 2)
+	for i, id := range []int64{JobTableID, ReorgTableID} {
+		require.Equal(t, tablecodec.EncodeTablePrefix(id), cs.ranges[i].StartKey)
+		require.Equal(t, tablecodec.EncodeTablePrefix(id+1), cs.ranges[i].EndKey)
+	}
+}
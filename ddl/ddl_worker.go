@@ -15,6 +15,7 @@
 package ddl
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"math/rand"
@@ -98,6 +99,14 @@ type worker struct {
 	lockSeqNum      bool
 
 	concurrentDDL bool
+	// temp is true if the worker is a temporary one created beyond the capacity of its worker pool.
+	temp bool
+	// lastJobMeta is the job meta written by the worker last time, it's used to skip rewriting the unchanged job meta.
+	// It's reset if the transaction writing it isn't committed.
+	lastJobMeta struct {
+		jobID int64
+		meta  []byte
+	}
 
 	*ddlCtx
 }
@@ -391,14 +400,17 @@ func (d *ddl) addBatchDDLJobs2Table(tasks []*limitJobTask) error {
 		return nil
 	})
 	if err == nil {
-		jobTasks := make([]*model.Job, len(tasks))
+		jobTasks := make([]*JobWithIDs, len(tasks))
 		for i, task := range tasks {
 			job := task.job
 			job.Version = currentVersion
 			job.StartTS = startTS
 			job.ID = ids[i]
 			setJobStateToQueueing(job)
-			jobTasks[i] = job
+			if err = keepSubmittedJob(job); err != nil {
+				return errors.Trace(err)
+			}
+			jobTasks[i] = NewJobWithIDs(job)
 			injectModifyJobArgFailPoint(job)
 		}
 		sess, err1 := d.sessPool.get()
@@ -464,13 +476,35 @@ func (w *worker) updateDDLJob(t *meta.Meta, job *model.Job, meetErr bool) error
 	}
 	var err error
 	if w.concurrentDDL {
-		err = updateDDLJob2Table(w.sess, job, updateRawArgs)
+		err = w.updateDDLJob2TableIfChanged(job, updateRawArgs)
 	} else {
 		err = t.UpdateDDLJob(0, job, updateRawArgs)
 	}
 	return errors.Trace(err)
 }
 
+// updateDDLJob2TableIfChanged is like updateDDLJob2Table, but it skips the update if the job meta is the same as the
+// one written last time, e.g. only the reorg handle of the job, which is stored separately, is changed.
+func (w *worker) updateDDLJob2TableIfChanged(job *model.Job, updateRawArgs bool) error {
+	b, err := job.Encode(updateRawArgs)
+	if err != nil {
+		return err
+	}
+	if w.lastJobMeta.jobID == job.ID && bytes.Equal(w.lastJobMeta.meta, b) {
+		return nil
+	}
+	if err := updateJobMeta2Table(w.sess, job.ID, b); err != nil {
+		w.resetLastJobMeta()
+		return errors.Trace(err)
+	}
+	w.lastJobMeta.jobID, w.lastJobMeta.meta = job.ID, b
+	return nil
+}
+
+func (w *worker) resetLastJobMeta() {
+	w.lastJobMeta.jobID, w.lastJobMeta.meta = 0, nil
+}
+
 func needUpdateRawArgs(job *model.Job, meetErr bool) bool {
 	// If there is an error when running job and the RawArgs hasn't been decoded by DecodeArgs,
 	// we shouldn't replace RawArgs with the marshaling Args.
@@ -570,6 +604,8 @@ func (w *worker) finishDDLJob(t *meta.Meta, job *model.Job) (err error) {
 	}
 	w.writeDDLSeqNum(job)
 	w.removeJobCtx(job)
+	// The finished job can't be retried.
+	job.SubmittedJob = nil
 	err = AddHistoryDDLJob(w.sess, t, job, updateRawArgs, w.concurrentDDL)
 	return errors.Trace(err)
 }
@@ -686,7 +722,7 @@ func (w *worker) HandleDDLJobTable(d *ddlCtx, job *model.Job) error {
 		err       error
 		schemaVer int64
 		runJobErr error
-		waitTime  = 2 * d.lease
+		waitTime  = d.getSchemaSyncWait()
 	)
 	defer func() {
 		w.unlockSeqNum(err)
@@ -700,6 +736,10 @@ func (w *worker) HandleDDLJobTable(d *ddlCtx, job *model.Job) error {
 		w.sess.rollback()
 		return nil
 	}
+	if err = d.checkRunningJobsRegistry(job.ID); err != nil {
+		w.sess.rollback()
+		return err
+	}
 	failpoint.Inject("mockRunJobTime", func(val failpoint.Value) {
 		if val.(bool) {
 			time.Sleep(time.Duration(rand.Intn(500)) * time.Millisecond) // #nosec G404
@@ -763,15 +803,24 @@ func (w *worker) HandleDDLJobTable(d *ddlCtx, job *model.Job) error {
 	err = w.updateDDLJob(t, job, runJobErr != nil)
 	if err = w.handleUpdateJobError(t, job, err); err != nil {
 		w.sess.rollback()
+		w.resetLastJobMeta()
 		d.unlockSchemaVersion(job.ID)
 		return err
 	}
 	writeBinlog(d.binlogCli, txn, job)
 	// reset the SQL digest to make topsql work right.
 	w.sess.GetSessionVars().StmtCtx.ResetSQLDigest(job.Query)
+	// The step may take long, e.g. the reorg, the job may be taken as orphaned if the registry is lost meanwhile.
+	if err = d.checkRunningJobsRegistry(job.ID); err != nil {
+		w.sess.rollback()
+		w.resetLastJobMeta()
+		d.unlockSchemaVersion(job.ID)
+		return err
+	}
 	err = w.sess.commit()
 	d.unlockSchemaVersion(job.ID)
 	if err != nil {
+		w.resetLastJobMeta()
 		return err
 	}
 	w.registerSync(job)
@@ -846,7 +895,7 @@ func (w *worker) handleDDLJobQueue(d *ddlCtx) error {
 			schemaVer int64
 			runJobErr error
 		)
-		waitTime := 2 * d.lease
+		waitTime := d.getSchemaSyncWait()
 		ctx := kv.WithInternalSourceType(context.Background(), kv.InternalTxnDDL)
 		err := kv.RunInNewTxn(ctx, d.store, false, func(ctx context.Context, txn kv.Transaction) error {
 			d.runningJobs.Lock()
@@ -1111,6 +1160,13 @@ func (w *worker) runDDLJob(d *ddlCtx, t *meta.Meta, job *model.Job) (ver int64,
 		return convertJob2RollbackJob(w, d, t, job)
 	}
 
+	if job.State == model.JobStateQueueing {
+		if err = d.validateJob(job); err != nil {
+			job.State = model.JobStateCancelled
+			return ver, w.countForError(err, job)
+		}
+	}
+
 	if !job.IsRollingback() && !job.IsCancelling() {
 		job.State = model.JobStateRunning
 	}
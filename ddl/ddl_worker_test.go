@@ -57,6 +57,38 @@ func TestInvalidDDLJob(t *testing.T) {
 	require.Equal(t, err.Error(), "[ddl:8204]invalid ddl job type: none")
 }
 
+func TestPeekNextJobID(t *testing.T) {
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("create table test.t (a int)")
+	dbInfo, ok := dom.InfoSchema().SchemaByName(model.NewCIStr("test"))
+	require.True(t, ok)
+	tbl, err := dom.InfoSchema().TableByName(model.NewCIStr("test"), model.NewCIStr("t"))
+	require.NoError(t, err)
+	d := dom.DDL().(interface {
+		PeekNextJobID(sctx sessionctx.Context) (int64, error)
+	})
+
+	// Peeking doesn't consume the ID.
+	id, err := d.PeekNextJobID(tk.Session())
+	require.NoError(t, err)
+	id2, err := d.PeekNextJobID(tk.Session())
+	require.NoError(t, err)
+	require.Equal(t, id, id2)
+
+	job := &model.Job{
+		SchemaID:   dbInfo.ID,
+		TableID:    tbl.Meta().ID,
+		Type:       model.ActionModifyTableComment,
+		BinlogInfo: &model.HistoryInfo{},
+		Args:       []interface{}{"comment"},
+	}
+	ctx := testNewContext(store)
+	ctx.SetValue(sessionctx.QueryString, "skip")
+	require.NoError(t, dom.DDL().DoDDLJob(ctx, job))
+	require.Equal(t, id, job.ID)
+}
+
 func TestAddBatchJobError(t *testing.T) {
 	store, dom := testkit.CreateMockStoreAndDomainWithSchemaLease(t, testLease)
 	ctx := testNewContext(store)
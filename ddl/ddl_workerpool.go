@@ -26,6 +26,10 @@ type workerPool struct {
 	t       jobType
 	exit    atomic.Bool
 	resPool *pools.ResourcePool
+	// tempFactory creates the temporary workers beyond the capacity of resPool, they are closed
+	// instead of being put back to resPool. tempCnt is the count of the temporary workers in use.
+	tempFactory pools.Factory
+	tempCnt     atomic.Int32
 }
 
 func newDDLWorkerPool(resPool *pools.ResourcePool, tp jobType) *workerPool {
@@ -66,12 +70,37 @@ func (wp *workerPool) put(wk *worker) {
 	if wp.resPool == nil {
 		return
 	}
+	if wk.temp {
+		wk.Close()
+		wp.tempCnt.Dec()
+		return
+	}
 
 	// no need to protect wp.resPool, even the wp.resPool is closed, the ctx still need to
 	// put into resPool, because when resPool is closing, it will wait all the ctx returns, then resPool finish closing.
 	wp.resPool.Put(wk)
 }
 
+// getTemp creates a temporary worker if the count of the temporary workers in use is less than limit.
+// The temporary worker is closed when it's put back.
+func (wp *workerPool) getTemp(limit int32) (*worker, error) {
+	if wp.tempFactory == nil || wp.exit.Load() {
+		return nil, nil
+	}
+	if wp.tempCnt.Inc() > limit {
+		wp.tempCnt.Dec()
+		return nil, nil
+	}
+	resource, err := wp.tempFactory()
+	if err != nil {
+		wp.tempCnt.Dec()
+		return nil, errors.Trace(err)
+	}
+	wk := resource.(*worker)
+	wk.temp = true
+	return wk, nil
+}
+
 // close clean up the workerPool.
 func (wp *workerPool) close() {
 	// prevent closing resPool twice.
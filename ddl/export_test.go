@@ -14,6 +14,81 @@
 
 package ddl
 
+import (
+	"time"
+
+	"github.com/pingcap/tidb/meta"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/sessionctx"
+)
+
 func SetBatchInsertDeleteRangeSize(i int) {
 	batchInsertDeleteRangeSize = i
 }
+
+func SetReorgHandleCompactCheckInterval(interval time.Duration) {
+	reorgHandleCompactCheckInterval = interval
+}
+
+func SetOrphanedJobCheckInterval(interval time.Duration) {
+	orphanedJobCheckInterval = interval
+}
+
+func (d *ddl) IsReorgJobConflicted(sctx sessionctx.Context, job *model.Job) (bool, error) {
+	return d.isReorgJobConflicted(newSession(sctx), job)
+}
+
+func (d *ddl) HasProcessingJobs(sctx sessionctx.Context) (bool, error) {
+	return d.hasProcessingJobs(newSession(sctx))
+}
+
+func MigrateReorgHandle(sctx sessionctx.Context, t *meta.Meta, job *model.Job) error {
+	return migrateReorgHandle(newSession(sctx), t, job)
+}
+
+func (d *ddl) GetGeneralJob(sctx sessionctx.Context) (*model.Job, error) {
+	return d.getGeneralJob(newSession(sctx))
+}
+
+func (d *ddl) InvalidateProcessingJobs() {
+	d.invalidateProcessingJobs()
+}
+
+func (d *ddl) HasLongRunningReorgJob(sctx sessionctx.Context, threshold time.Duration) (bool, error) {
+	return d.hasLongRunningReorgJob(newSession(sctx), threshold)
+}
+
+func InsertDDLJobs2Table(sctx sessionctx.Context, jobs ...*JobWithIDs) error {
+	return insertDDLJobs2Table(newSession(sctx), true, jobs...)
+}
+
+func (d *ddl) InsertRunningDDLJobMap(id int64) {
+	d.insertRunningDDLJobMap(id)
+}
+
+func (d *ddl) DeleteRunningDDLJobMap(id int64) {
+	d.deleteRunningDDLJobMap(id)
+}
+
+func (d *ddl) ResetOrphanedProcessingJobs(sctx sessionctx.Context) error {
+	return d.resetOrphanedProcessingJobs(newSession(sctx))
+}
+
+func SetGetJobsByIDsBatchSize(size int) {
+	getJobsByIDsBatchSize = size
+}
+
+func GetJobsByIDs(sctx sessionctx.Context, ids []int64) (map[int64]*model.Job, error) {
+	return getJobsByIDs(newSession(sctx), ids)
+}
+
+func RunInTxnWithRetry(sctx sessionctx.Context, maxRetries int, f func() error) error {
+	return runInTxnWithRetry(newSession(sctx), maxRetries, func(*session) error {
+		return f()
+	})
+}
+
+// DispatchOnce does a round of the dispatch loop synchronously, the loop should be stopped by DrainWorkers first.
+func (d *ddl) DispatchOnce(sctx sessionctx.Context) {
+	d.dispatchOnce(newSession(sctx))
+}
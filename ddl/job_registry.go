@@ -0,0 +1,273 @@
+// Copyright 2022 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ddl
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/failpoint"
+	"github.com/pingcap/tidb/ddl/util"
+	"github.com/pingcap/tidb/parser/model"
+	tidbutil "github.com/pingcap/tidb/util"
+	"github.com/pingcap/tidb/util/dbterror"
+	"github.com/pingcap/tidb/util/logutil"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
+)
+
+// The running jobs registry is used to reclaim the orphaned processing jobs. A job is processing in the job table
+// from its first step to the end, but it's only run by a worker during a step. After the owner changes, the previous
+// owner may still be running a step of a job, and re-dispatching the job by the new owner runs the job twice at the
+// same time. So every node publishes the IDs of the jobs run by its workers to etcd under a lease, and the owner
+// skips the processing jobs published by the other nodes. A job is published before its step runs, and a node stops
+// running the steps once its lease is lost. Once a node is down, its lease expires and its jobs become orphaned, then
+// they are reclaimed and re-dispatched by the owner.
+const (
+	// runningJobsRegistryPrefix is the etcd key prefix of the registry, the key of a node is suffixed by its ID.
+	runningJobsRegistryPrefix = "/tidb/ddl/running_jobs/"
+	// runningJobsRegistryTTL is the TTL in seconds of the lease of the registry keys.
+	runningJobsRegistryTTL = 10
+	// runningJobsPublishInterval is the interval to check the published running jobs, they are also published
+	// whenever a job finishes a step.
+	runningJobsPublishInterval = time.Second
+)
+
+// getRunningJobIDs returns the sorted IDs of the jobs run by the workers of the node.
+func (dc *ddlCtx) getRunningJobIDs() []int64 {
+	dc.runningJobs.RLock()
+	defer dc.runningJobs.RUnlock()
+	ids := make([]int64, 0, len(dc.runningJobs.ids))
+	for id := range dc.runningJobs.ids {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func encodeRunningJobIDs(ids []int64) string {
+	strs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		strs = append(strs, strconv.FormatInt(id, 10))
+	}
+	return strings.Join(strs, ",")
+}
+
+// startPublishRunningJobs keeps the running jobs of the node published in the registry until the ddl is closed.
+// The jobs starting a step are published by the worker synchronously, the loop publishes the finished jobs and
+// publishes the jobs again after the session of the registry is lost.
+func (d *ddl) startPublishRunningJobs() {
+	ticker := time.NewTicker(runningJobsPublishInterval)
+	defer ticker.Stop()
+	defer func() {
+		r := &d.runningJobsRegistry
+		r.Lock()
+		defer r.Unlock()
+		// Revoke the lease, so the key is removed at once. The context of the session is done, it can't be used.
+		if r.session != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), util.KeyOpDefaultTimeout)
+			_, err := d.etcdCli.Revoke(ctx, r.session.Lease())
+			cancel()
+			if err != nil {
+				logutil.BgLogger().Info("[ddl] revoke the lease of the running jobs registry failed", zap.Error(err))
+			}
+			r.session = nil
+		}
+	}()
+	for {
+		select {
+		case <-d.runningJobsCh:
+		case <-ticker.C:
+		case <-d.ctx.Done():
+			return
+		}
+		if err := d.publishRunningJobs(); err != nil {
+			logutil.BgLogger().Warn("[ddl] publish the running ddl jobs failed", zap.Error(err))
+		}
+	}
+}
+
+// publishRunningJobs publishes the running jobs of the node to the registry if they're changed since the last
+// publishing. The session of the registry is created again if it's lost. A worker calls it before running a step
+// of a job and skips the step if it fails, so the owner never takes a job run by a node as orphaned.
+func (dc *ddlCtx) publishRunningJobs() error {
+	if dc.etcdCli == nil {
+		return nil
+	}
+	r := &dc.runningJobsRegistry
+	r.Lock()
+	defer r.Unlock()
+	if r.session == nil || isChanClosed(r.session.Done()) {
+		s, err := tidbutil.NewSession(dc.ctx, "[ddl] running jobs registry", dc.etcdCli, tidbutil.NewSessionDefaultRetryCnt, runningJobsRegistryTTL)
+		if err != nil {
+			return errors.Annotate(err, "create the session of the running jobs registry")
+		}
+		// The key is removed with the previous lease, so it needs to be published again.
+		r.session, r.needPublish = s, true
+	}
+	ids := dc.getRunningJobIDs()
+	val := encodeRunningJobIDs(ids)
+	if val != r.published || r.needPublish {
+		err := util.PutKVToEtcd(dc.ctx, dc.etcdCli, 1, runningJobsRegistryPrefix+dc.uuid, val, clientv3.WithLease(r.session.Lease()))
+		if err != nil {
+			return errors.Annotatef(err, "publish the running ddl jobs %v", ids)
+		}
+		r.published, r.needPublish = val, false
+	}
+	if r.leases == nil {
+		r.leases = make(map[int64]clientv3.LeaseID, len(ids))
+	}
+	for _, id := range ids {
+		if _, ok := r.leases[id]; !ok {
+			r.leases[id] = r.session.Lease()
+		}
+	}
+	return nil
+}
+
+// forgetJobLease is called when the job finishes a step, so it's published under the alive lease for the next step.
+func (dc *ddlCtx) forgetJobLease(id int64) {
+	r := &dc.runningJobsRegistry
+	r.Lock()
+	defer r.Unlock()
+	delete(r.leases, id)
+}
+
+// checkRunningJobsRegistry returns an error if the job run by the worker of the node isn't published under the alive
+// lease of the running jobs registry. Once the lease is lost, the owner may take the job as orphaned and run it again,
+// so the running step must stop, even if the job is published again under a new lease.
+func (dc *ddlCtx) checkRunningJobsRegistry(jobID int64) error {
+	if dc.etcdCli == nil {
+		return nil
+	}
+	dc.runningJobs.RLock()
+	_, ok := dc.runningJobs.ids[jobID]
+	dc.runningJobs.RUnlock()
+	if !ok {
+		// The job isn't delivered by the dispatch loop, e.g. it's run by the legacy ddl worker.
+		return nil
+	}
+	r := &dc.runningJobsRegistry
+	r.Lock()
+	defer r.Unlock()
+	lease, ok := r.leases[jobID]
+	if !ok || r.session == nil || isChanClosed(r.session.Done()) || lease != r.session.Lease() {
+		return dbterror.ErrInvalidWorker.GenWithStack("the lease of the running jobs registry is lost")
+	}
+	return nil
+}
+
+// getJobsRunByOthers returns the IDs of the jobs published by the other nodes in the registry, mapped to the node IDs.
+func (d *ddl) getJobsRunByOthers() (map[int64]string, error) {
+	jobs := make(map[int64]string)
+	if d.etcdCli == nil {
+		return jobs, nil
+	}
+	ctx, cancel := context.WithTimeout(d.ctx, util.KeyOpDefaultTimeout)
+	defer cancel()
+	resp, err := d.etcdCli.Get(ctx, runningJobsRegistryPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, kv := range resp.Kvs {
+		node := strings.TrimPrefix(string(kv.Key), runningJobsRegistryPrefix)
+		if node == d.uuid || len(kv.Value) == 0 {
+			continue
+		}
+		for _, s := range strings.Split(string(kv.Value), ",") {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				logutil.BgLogger().Warn("[ddl] skip the invalid job ID in the running jobs registry", zap.String("node", node), zap.String("jobID", s))
+				continue
+			}
+			jobs[id] = node
+		}
+	}
+	return jobs, nil
+}
+
+// resetOrphanedProcessingJobs resets the processing flag of the orphaned processing jobs which haven't run any step,
+// e.g. the previous owner crashed after marking the job processing but before the first step was committed. Such a
+// job is dispatched again as a pending job, so it goes through the conflict check like it's never dispatched. The
+// orphaned jobs which have run any step are left processing, since the conflicted jobs can't run before they finish,
+// they're reclaimed by getJob instead. It's called when the node becomes the owner.
+//
+// A job is published before it's marked processing, so the registry, including the jobs of the node itself, is read
+// after the processing jobs are read, then any job read as processing is seen in the registry unless its node is
+// down. A job finishing its first step during the reset conflicts with the update of the job.
+func (d *ddl) resetOrphanedProcessingJobs(sess *session) error {
+	var ids []int64
+	err := runInTxn(sess, func(se *session) error {
+		jobs, err := getJobsBySQL(se, JobTable, "processing")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		// mockJobPublishedLate publishes the job of the ID after the processing jobs are read.
+		failpoint.Inject("mockJobPublishedLate", func(val failpoint.Value) {
+			d.insertRunningDDLJobMap(int64(val.(int)))
+		})
+		running, err := d.getJobsRunByOthers()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, id := range d.getRunningJobIDs() {
+			running[id] = d.uuid
+		}
+		ids = ids[:0]
+		for _, job := range jobs {
+			if _, ok := running[job.ID]; ok || job.RealStartTS != 0 {
+				continue
+			}
+			ids = append(ids, job.ID)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		sql := fmt.Sprintf("update mysql.tidb_ddl_job set processing = 0 where job_id in (%s)", encodeRunningJobIDs(ids))
+		_, err = se.execute(context.Background(), sql, "reset_orphaned_jobs")
+		return errors.Trace(err)
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(ids) > 0 {
+		d.invalidateProcessingJobs()
+		logutil.BgLogger().Info("[ddl] reset the orphaned processing ddl jobs which haven't run any step", zap.Int64s("jobIDs", ids))
+	}
+	return nil
+}
+
+// markJobTaken records the job is run by the node, the processing job not taken by the node before is reclaimed.
+func (dc *ddlCtx) markJobTaken(job *model.Job) {
+	dc.runningJobs.Lock()
+	_, ok := dc.runningJobs.taken[job.ID]
+	dc.runningJobs.taken[job.ID] = struct{}{}
+	dc.runningJobs.Unlock()
+	if !ok && job.RealStartTS != 0 {
+		logutil.BgLogger().Info("[ddl] reclaim the processing ddl job not run by any node", jobZapFields(job)...)
+	}
+}
+
+// forgetJobTaken is called when the job is finished.
+func (dc *ddlCtx) forgetJobTaken(id int64) {
+	dc.runningJobs.Lock()
+	defer dc.runningJobs.Unlock()
+	delete(dc.runningJobs.taken, id)
+}
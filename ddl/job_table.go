@@ -17,21 +17,34 @@ package ddl
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"math"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/ddl/util"
 	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/meta"
 	"github.com/pingcap/tidb/metrics"
 	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tidb/sessionctx"
 	"github.com/pingcap/tidb/sessionctx/variable"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/dbterror"
 	"github.com/pingcap/tidb/util/logutil"
+	"github.com/pingcap/tidb/util/mathutil"
+	"github.com/pingcap/tidb/util/timeutil"
+	"github.com/tikv/client-go/v2/oracle"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.uber.org/zap"
 	"golang.org/x/exp/slices"
@@ -39,18 +52,94 @@ import (
 
 var (
 	addingDDLJobConcurrent = "/tidb/ddl/add_ddl_job_general"
+	// addingDDLJobReorgConcurrent is notified for the reorg jobs besides addingDDLJobConcurrent, so the reorg jobs
+	// aren't masked by the flood of the general jobs.
+	addingDDLJobReorgConcurrent = "/tidb/ddl/add_ddl_job_reorg"
+	// ddlMaintenanceModeKey is the etcd key of the maintenance mode, the mode is on if the value is ddlMaintenanceModeOn.
+	ddlMaintenanceModeKey = "/tidb/ddl/maintenance_mode"
+	// jobPriorityChangedKey is notified when the dispatch priority of a job is changed, so the owner loads the
+	// priorities again.
+	jobPriorityChangedKey = "/tidb/ddl/job_priority_changed"
+)
+
+const (
+	ddlMaintenanceModeOn  = "1"
+	ddlMaintenanceModeOff = "0"
 )
 
 func (dc *ddlCtx) insertRunningDDLJobMap(id int64) {
+	dc.invalidateProcessingJobs()
 	dc.runningJobs.Lock()
 	defer dc.runningJobs.Unlock()
 	dc.runningJobs.ids[id] = struct{}{}
 }
 
 func (dc *ddlCtx) deleteRunningDDLJobMap(id int64) {
+	dc.invalidateProcessingJobs()
 	dc.runningJobs.Lock()
-	defer dc.runningJobs.Unlock()
 	delete(dc.runningJobs.ids, id)
+	dc.runningJobs.Unlock()
+	// publishRunningJobs locks the running jobs while holding the registry, so the registry is locked after releasing them.
+	dc.forgetJobLease(id)
+	asyncNotify(dc.runningJobsCh)
+}
+
+func (dc *ddlCtx) invalidateProcessingJobs() {
+	dc.processingJobs.Lock()
+	defer dc.processingJobs.Unlock()
+	dc.processingJobs.valid = false
+}
+
+// isReorgJobConflicted checks whether the reorg job conflicts with the processing jobs, it's the same as
+// defaultConflictChecker.ReorgJobConflictSQL, but the processing jobs are loaded once until the cache is invalidated.
+func (dc *ddlCtx) isReorgJobConflicted(sess *session, job *model.Job) (bool, error) {
+	dc.processingJobs.Lock()
+	defer dc.processingJobs.Unlock()
+	if err := dc.loadProcessingJobs(sess); err != nil {
+		return false, errors.Trace(err)
+	}
+	if _, ok := dc.processingJobs.schemaIDs[strconv.FormatInt(job.SchemaID, 10)]; ok {
+		return true, nil
+	}
+	_, ok := dc.processingJobs.tableIDs[strconv.FormatInt(job.TableID, 10)]
+	return ok, nil
+}
+
+// hasProcessingJobs checks whether there is any processing job by the cache of isReorgJobConflicted.
+// Note the running jobs in memory can't be used instead, since a job is still processing between its steps,
+// and the jobs processed by the previous owner aren't in memory.
+func (dc *ddlCtx) hasProcessingJobs(sess *session) (bool, error) {
+	dc.processingJobs.Lock()
+	defer dc.processingJobs.Unlock()
+	if err := dc.loadProcessingJobs(sess); err != nil {
+		return false, errors.Trace(err)
+	}
+	return len(dc.processingJobs.tableIDs) > 0, nil
+}
+
+// loadProcessingJobs loads the processing jobs if the cache is invalid, it should be called under the lock.
+func (dc *ddlCtx) loadProcessingJobs(sess *session) error {
+	if !dc.processingJobs.valid {
+		rows, err := sess.execute(context.Background(), "select type, schema_ids, table_ids from mysql.tidb_ddl_job where processing", "get_processing_jobs")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		schemaIDs := make(map[string]struct{})
+		tableIDs := make(map[string]struct{}, len(rows))
+		for _, row := range rows {
+			if model.ActionType(row.GetInt64(0)) == model.ActionDropSchema {
+				for _, id := range strings.Split(row.GetString(1), ",") {
+					schemaIDs[id] = struct{}{}
+				}
+			}
+			for _, id := range strings.Split(row.GetString(2), ",") {
+				tableIDs[id] = struct{}{}
+			}
+		}
+		dc.processingJobs.schemaIDs, dc.processingJobs.tableIDs = schemaIDs, tableIDs
+		dc.processingJobs.valid = true
+	}
+	return nil
 }
 
 func (dc *ddlCtx) excludeJobIDs() string {
@@ -66,9 +155,79 @@ func (dc *ddlCtx) excludeJobIDs() string {
 	return fmt.Sprintf("and job_id not in (%s)", strings.Join(dc.runningJobIDs, ","))
 }
 
-const (
-	getJobSQL = "select job_meta, processing from mysql.tidb_ddl_job where job_id in (select min(job_id) from mysql.tidb_ddl_job group by schema_ids, table_ids) and %s reorg %s order by processing desc, job_id"
-)
+// defaultGetJobScanLimit is the default max count of the candidate jobs decoded by a call of getJob. The candidates
+// are the first jobs of the distinct schema and table groups, it bounds the cost of decoding with a huge backlog.
+const defaultGetJobScanLimit = 1024
+
+// SetGetJobScanLimit sets the max count of the candidate jobs decoded by a call of getJob, 0 means unlimited.
+// The candidates are ordered by the priority before the limit is applied, so the job with the highest priority
+// is always decoded.
+func (d *ddl) SetGetJobScanLimit(limit int64) {
+	d.getJobScanLimit.Store(limit)
+}
+
+// The jobs are ordered by the dispatch priorities cached on the owner before the limit, see jobPriorityOrder.
+const getJobSQL = "select job_meta, processing, job_id from mysql.tidb_ddl_job where job_id in (select min(job_id) from mysql.tidb_ddl_job group by schema_ids, table_ids) and %s reorg %s order by processing desc, %sjob_id"
+
+// loadJobPrioritiesSQL gets the jobs with a dispatch priority, the priority is omitted from the job meta if it's 0.
+const loadJobPrioritiesSQL = `select job_id, job_meta from mysql.tidb_ddl_job where job_meta like '%"dispatch_priority"%'`
+
+// invalidateJobPriorities makes the owner load the dispatch priorities of the jobs again before getting the next job.
+func (dc *ddlCtx) invalidateJobPriorities() {
+	dc.jobPriorities.Lock()
+	defer dc.jobPriorities.Unlock()
+	dc.jobPriorities.loaded = false
+}
+
+// loadJobPriorities loads the dispatch priorities of the jobs if they're invalidated. The priorities are only changed
+// by SetJobPriority, so they're loaded once the priority of a job is changed or the node becomes the owner, and the
+// priorities of the finished jobs are dropped then.
+func (d *ddl) loadJobPriorities(sess *session) error {
+	d.jobPriorities.Lock()
+	defer d.jobPriorities.Unlock()
+	if d.jobPriorities.loaded {
+		return nil
+	}
+	rows, err := sess.execute(context.Background(), loadJobPrioritiesSQL, "load_job_priorities")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	m := make(map[int64]int)
+	for _, row := range rows {
+		job := model.Job{}
+		if err := job.Decode(row.GetBytes(1)); err != nil {
+			// The corrupt job is skipped by getJob too.
+			continue
+		}
+		if job.DispatchPriority != 0 {
+			m[job.ID] = job.DispatchPriority
+		}
+	}
+	d.jobPriorities.m, d.jobPriorities.loaded = m, true
+	return nil
+}
+
+// jobPriorityOrder returns the ORDER BY item of getJobSQL to order the jobs by the cached dispatch priorities, it's
+// empty if no job has a priority.
+func (dc *ddlCtx) jobPriorityOrder() string {
+	dc.jobPriorities.Lock()
+	defer dc.jobPriorities.Unlock()
+	if len(dc.jobPriorities.m) == 0 {
+		return ""
+	}
+	ids := make([]int64, 0, len(dc.jobPriorities.m))
+	for id := range dc.jobPriorities.m {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	var sb strings.Builder
+	sb.WriteString("case job_id")
+	for _, id := range ids {
+		fmt.Fprintf(&sb, " when %d then %d", id, dc.jobPriorities.m[id])
+	}
+	sb.WriteString(" else 0 end desc, ")
+	return sb.String()
+}
 
 type jobType int
 
@@ -87,53 +246,465 @@ const (
 	reorg
 )
 
-func (d *ddl) getJob(sess *session, tp jobType, filter func(*model.Job) (bool, error)) (*model.Job, error) {
+// SetJobTableHint sets the optimizer hint of the query to get the jobs to dispatch, e.g.
+// "use_index(tidb_ddl_job, primary)", it helps when the optimizer picks a bad plan on a bloated job table.
+// The hint is the content of the hint comment without "/*+" and "*/", an empty hint disables it, which is the default.
+func (d *ddl) SetJobTableHint(hint string) {
+	d.jobTableHint.Store(hint)
+}
+
+// withOptimizerHint adds the hint to the select statement.
+func withOptimizerHint(sql, hint string) string {
+	if len(hint) == 0 || !strings.HasPrefix(sql, "select ") {
+		return sql
+	}
+	return fmt.Sprintf("select /*+ %s */ %s", hint, sql[len("select "):])
+}
+
+// buildGetJobSQL builds the query to get the candidate jobs of the type for getJob.
+func (dc *ddlCtx) buildGetJobSQL(tp jobType) string {
 	not := "not"
-	label := "get_job_general"
 	if tp == reorg {
 		not = ""
+	}
+	sql := withOptimizerHint(fmt.Sprintf(getJobSQL, not, dc.excludeJobIDs(), dc.jobPriorityOrder()), dc.jobTableHint.Load())
+	if limit := dc.getJobScanLimit.Load(); limit > 0 {
+		sql += fmt.Sprintf(" limit %d", limit)
+	}
+	return sql
+}
+
+func (d *ddl) getJob(sess *session, tp jobType, filter func(*model.Job) (bool, error)) (*model.Job, error) {
+	label := "get_job_general"
+	if tp == reorg {
 		label = "get_job_reorg"
 	}
-	sql := fmt.Sprintf(getJobSQL, not, d.excludeJobIDs())
-	rows, err := sess.execute(context.Background(), sql, label)
+	if err := d.loadJobPriorities(sess); err != nil {
+		return nil, errors.Trace(err)
+	}
+	rows, err := sess.execute(context.Background(), d.buildGetJobSQL(tp), label)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	jobs := make([]*model.Job, 0, len(rows))
+	var runByOthers map[int64]string
 	for _, row := range rows {
 		jobBinary := row.GetBytes(0)
 		runJob := model.Job{}
 		err := runJob.Decode(jobBinary)
 		if err != nil {
-			return nil, errors.Trace(err)
+			// Skip the corrupt job, so it doesn't block the other jobs.
+			err = dbterror.ErrCorruptJobMeta.GenWithStackByArgs(row.GetInt64(2), err)
+			logutil.BgLogger().Warn("[ddl] skip the ddl job with corrupt meta", zap.Int64("jobID", row.GetInt64(2)), zap.Error(err))
+			continue
+		}
+		if d.isJobQuarantined(runJob.ID) {
+			d.recordDispatchDecision(tp, runJob.ID, DispatchReasonQuarantined, nil)
+			continue
 		}
 		if row.GetInt64(1) == 1 {
+			// The processing job may be still run by the previous owner, it's reclaimed only if no node runs it.
+			if runByOthers == nil {
+				if runByOthers, err = d.getJobsRunByOthers(); err != nil {
+					return nil, errors.Trace(err)
+				}
+			}
+			if node, ok := runByOthers[runJob.ID]; ok {
+				logutil.BgLogger().Debug("[ddl] skip the processing ddl job run by another node", zap.Int64("jobID", runJob.ID), zap.String("node", node))
+				d.recordDispatchDecision(tp, runJob.ID, DispatchReasonRunByOthers, nil)
+				continue
+			}
 			return &runJob, nil
 		}
-		b, err := filter(&runJob)
+		jobs = append(jobs, &runJob)
+	}
+	for _, runJob := range jobs {
+		b, err := filter(runJob)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
 		if b {
-			if err := d.markJobProcessing(sess, &runJob); err != nil {
+			// The job is published before it becomes processing, so the processing job not published by any node is
+			// surely orphaned, see resetOrphanedProcessingJobs.
+			d.insertRunningDDLJobMap(runJob.ID)
+			if err := d.publishRunningJobs(); err != nil {
+				d.deleteRunningDDLJobMap(runJob.ID)
+				return nil, errors.Trace(err)
+			}
+			if err := d.markJobProcessing(sess, runJob); err != nil {
+				d.deleteRunningDDLJobMap(runJob.ID)
 				logutil.BgLogger().Warn("[ddl] handle ddl job failed: mark job is processing meet error", zap.Error(err), zap.String("job", runJob.String()))
 				return nil, errors.Trace(err)
 			}
-			return &runJob, nil
+			// The job becomes processing, so the following conflict checks in the same tick need to see it.
+			d.invalidateProcessingJobs()
+			return runJob, nil
 		}
+		d.recordDispatchDecision(tp, runJob.ID, DispatchReasonBlocked, nil)
 	}
 	return nil, nil
 }
 
+// SetJobPriority sets the dispatch priority of a job in the job table, the pending job with a higher priority
+// is dispatched before the ones with lower priorities, and the jobs with the same priority are dispatched
+// in the order of job ID. The priority of a job is 0 by default. The priority doesn't reorder the jobs on the same
+// schemas and tables, which are always dispatched in the order of job ID. The priority is persisted in the job meta,
+// and the owner is notified to load the priorities again.
+func (d *ddl) SetJobPriority(id int64, p int) error {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	err = runInTxn(newSession(se), func(sess *session) error {
+		jobs, err := getJobsBySQL(sess, JobTable, fmt.Sprintf("job_id = %d for update", id))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(jobs) == 0 {
+			return dbterror.ErrDDLJobNotFound.GenWithStackByArgs(id)
+		}
+		jobs[0].DispatchPriority = p
+		return errors.Trace(updateDDLJob2Table(sess, jobs[0], false))
+	})
+	if err != nil {
+		return err
+	}
+	d.invalidateJobPriorities()
+	if d.etcdCli != nil {
+		err = util.PutKVToEtcd(d.ctx, d.etcdCli, 1, jobPriorityChangedKey, strconv.FormatInt(id, 10))
+		if err != nil {
+			return errors.Annotate(err, "notify the owner of the changed priority")
+		}
+	}
+	return nil
+}
+
+// ReclassifyJob changes whether the job is dispatched to the reorg worker pool or the general one.
+// The processing job can't be reclassified, since it's being run by a worker of the current type.
+func (d *ddl) ReclassifyJob(id int64, needsReorg bool) error {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	return runInTxn(newSession(se), func(sess *session) error {
+		rows, err := sess.execute(context.Background(), fmt.Sprintf("select processing from mysql.tidb_ddl_job where job_id = %d for update", id), "get_processing")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(rows) == 0 {
+			return dbterror.ErrDDLJobNotFound.GenWithStackByArgs(id)
+		}
+		if rows[0].GetInt64(0) != 0 {
+			return errors.Errorf("ddl job %d is processing, it can't be reclassified", id)
+		}
+		_, err = sess.execute(context.Background(), fmt.Sprintf("update mysql.tidb_ddl_job set reorg = %t where job_id = %d", needsReorg, id), "reclassify_job")
+		return errors.Trace(err)
+	})
+}
+
+// ForceClearReorgHandle deletes the reorg handle of the job, so the next pickup reinitializes the reorg
+// from scratch. It's an emergency tool for the handle that can't make progress anymore, e.g. its physical ID
+// points at a dropped partition. The processing job can't be cleared, since a worker may be using the handle.
+func (d *ddl) ForceClearReorgHandle(jobID int64) error {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	return runInTxn(newSession(se), func(sess *session) error {
+		rows, err := sess.execute(context.Background(), fmt.Sprintf("select processing from mysql.tidb_ddl_job where job_id = %d for update", jobID), "get_processing")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(rows) == 0 {
+			return dbterror.ErrDDLJobNotFound.GenWithStackByArgs(jobID)
+		}
+		if rows[0].GetInt64(0) != 0 {
+			return errors.Errorf("ddl job %d is processing, its reorg handle can't be cleared", jobID)
+		}
+		logutil.BgLogger().Warn("[ddl] force clear the reorg handle, the reorg progress of the job is discarded", zap.Int64("jobID", jobID))
+		_, err = sess.execute(context.Background(), fmt.Sprintf("delete from mysql.tidb_ddl_reorg where job_id = %d", jobID), "remove_handle")
+		return errors.Trace(err)
+	})
+}
+
+// isArgsRewrittenByRollback returns whether the args of the job are rewritten by the rollback to clean up the
+// changed schema and the reorganized data.
+func isArgsRewrittenByRollback(job *model.Job) bool {
+	switch job.Type {
+	case model.ActionAddIndex, model.ActionAddPrimaryKey, model.ActionModifyColumn, model.ActionMultiSchemaChange,
+		model.ActionAddColumn, model.ActionAddTablePartition:
+		return true
+	}
+	return false
+}
+
+// keepSubmittedJob keeps the encoded job in SubmittedJob if its args are rewritten by the rollback, so RetryJob can
+// run the job again as it's submitted.
+func keepSubmittedJob(job *model.Job) error {
+	if !isArgsRewrittenByRollback(job) {
+		return nil
+	}
+	b, err := job.Encode(true)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	job.SubmittedJob = b
+	return nil
+}
+
+// RetryJob re-runs the job which has been rolled back but is still in the job table, without resubmitting the DDL.
+// The job is reset to queueing and not processing, so the dispatch loop picks it up again. The job completed
+// successfully can't be retried.
+//
+// The args of the reorg jobs, e.g. adding an index or modifying a column, are rewritten by the rollback, so they're
+// restored from the job kept when it's submitted, see keepSubmittedJob. The reorg handle of the job is removed if
+// resetReorgHandle is true, so the job reorganizes the data from scratch. Otherwise the handle is kept, but the data
+// reorganized before is removed by the rollback, so the rolled back job with a reorg handle can only be retried with
+// the handle reset, an error is returned instead of resuming the reorg from the handle and missing the removed data.
+//
+// The row of the job is locked before the running jobs are checked, so a worker finishing the job, e.g. moving the
+// rolled back job to the history, conflicts with the retry.
+func (d *ddl) RetryJob(jobID int64, resetReorgHandle bool) error {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	err = runInTxn(newSession(se), func(sess *session) error {
+		jobs, err := getJobsBySQL(sess, JobTable, fmt.Sprintf("job_id = %d for update", jobID))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(jobs) == 0 {
+			return dbterror.ErrDDLJobNotFound.GenWithStackByArgs(jobID)
+		}
+		job := jobs[0]
+		switch job.State {
+		case model.JobStateRollbackDone, model.JobStateCancelled:
+		case model.JobStateDone, model.JobStateSynced:
+			return errors.Errorf("ddl job %d is completed successfully, it can't be retried", jobID)
+		default:
+			return errors.Errorf("ddl job %d is %s, only the rolled back job can be retried", jobID, job.State)
+		}
+		if isArgsRewrittenByRollback(job) && len(job.SubmittedJob) == 0 {
+			// E.g. the job is moved from the queue or submitted by an older version.
+			return errors.Errorf("the submitted %s job %d isn't kept, please resubmit the ddl instead", job.Type, jobID)
+		}
+		runByOthers, err := d.getJobsRunByOthers()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if node, ok := d.getJobRunner(jobID, runByOthers); ok {
+			return errors.Errorf("ddl job %d is being run by the worker on node %s, it can't be retried", jobID, node)
+		}
+		if !resetReorgHandle {
+			rows, err := sess.execute(context.Background(), fmt.Sprintf("select 1 from mysql.tidb_ddl_reorg where job_id = %d limit 1", jobID), "get_handle")
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if len(rows) > 0 {
+				return errors.Errorf("the data reorganized by ddl job %d is removed by the rollback, its reorg handle must be reset to retry it", jobID)
+			}
+		}
+
+		logutil.BgLogger().Info("[ddl] retry the rolled back ddl job", zap.String("job", job.String()), zap.Bool("resetReorgHandle", resetReorgHandle))
+		if len(job.SubmittedJob) > 0 {
+			submitted := &model.Job{}
+			if err := submitted.Decode(job.SubmittedJob); err != nil {
+				return errors.Trace(err)
+			}
+			submitted.SubmittedJob = job.SubmittedJob
+			submitted.DispatchPriority = job.DispatchPriority
+			job = submitted
+		}
+		job.State = model.JobStateQueueing
+		job.SchemaState = model.StateNone
+		job.Error = nil
+		job.ErrorCount = 0
+		job.SnapshotVer = 0
+		job.SetRowCount(0)
+		if resetReorgHandle {
+			_, err = sess.execute(context.Background(), fmt.Sprintf("delete from mysql.tidb_ddl_reorg where job_id = %d", jobID), "remove_handle")
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+		if err := updateDDLJob2Table(sess, job, false); err != nil {
+			return errors.Trace(err)
+		}
+		_, err = sess.execute(context.Background(), fmt.Sprintf("update mysql.tidb_ddl_job set processing = 0 where job_id = %d", jobID), "retry_job")
+		return errors.Trace(err)
+	})
+	if err == nil {
+		asyncNotify(d.ddlJobCh)
+	}
+	return err
+}
+
+const (
+	waitJobDoneInitInterval = 50 * time.Millisecond
+	waitJobDoneMaxInterval  = time.Second
+)
+
+// WaitJobDone blocks until the job is removed from the job table, i.e. it's finished, or ctx is done. It returns nil
+// if the job is synced, otherwise the error of the job, which is kept in the history job, e.g. the job is cancelled.
+// The table is polled with an exponential backoff from waitJobDoneInitInterval to waitJobDoneMaxInterval.
+func (d *ddl) WaitJobDone(ctx context.Context, jobID int64) error {
+	interval := waitJobDoneInitInterval
+	for {
+		done, err := d.isJobRemovedFromTable(jobID)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if done {
+			break
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Trace(ctx.Err())
+		case <-d.ctx.Done():
+			timer.Stop()
+			return context.Canceled
+		}
+		interval = mathutil.Min(2*interval, waitJobDoneMaxInterval)
+	}
+	se, err := d.sessPool.get()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	// The job is added to the history in the same transaction it's removed from the job table.
+	historyJob, err := GetHistoryJobByID(se, jobID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if historyJob == nil {
+		return dbterror.ErrDDLJobNotFound.GenWithStackByArgs(jobID)
+	}
+	if historyJob.IsSynced() {
+		return nil
+	}
+	if historyJob.Error != nil {
+		return errors.Trace(historyJob.Error)
+	}
+	return errors.Errorf("ddl job %d is finished in state %s", jobID, historyJob.State)
+}
+
+func (d *ddl) isJobRemovedFromTable(jobID int64) (bool, error) {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	rows, err := newSession(se).execute(context.Background(), fmt.Sprintf("select 1 from mysql.tidb_ddl_job where job_id = %d", jobID), "check_job_done")
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return len(rows) == 0, nil
+}
+
+// JobTableStats returns the number of the jobs in the job table, keyed by labels like "general/pending" and
+// "reorg/processing". The jobs are classified by the reorg column like the dispatch, so a reclassified job is
+// counted as the type of the worker pool it's dispatched to, see ReclassifyJob.
+func (d *ddl) JobTableStats() (map[string]int, error) {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	sess := newSession(se)
+	rows, err := sess.execute(context.Background(), "select reorg, processing, count(*) from mysql.tidb_ddl_job group by reorg, processing", "job_table_stats")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	stats := make(map[string]int, 4)
+	for _, row := range rows {
+		label := "general"
+		if row.GetInt64(0) != 0 {
+			label = "reorg"
+		}
+		if row.GetInt64(1) != 0 {
+			label += "/processing"
+		} else {
+			label += "/pending"
+		}
+		stats[label] += int(row.GetInt64(2))
+	}
+	return stats, nil
+}
+
+// ConflictChecker builds the SQLs to detect the conflicts between a pending job and the processing jobs.
+// A pending job is runnable only if the SQL returns no rows.
+type ConflictChecker interface {
+	// GeneralJobConflictSQL builds the conflict detection SQL for a general job.
+	GeneralJobConflictSQL(job *model.Job) string
+	// ReorgJobConflictSQL builds the conflict detection SQL for a reorg job.
+	ReorgJobConflictSQL(job *model.Job) string
+}
+
+type defaultConflictChecker struct{}
+
+// GeneralJobConflictSQL implements ConflictChecker.GeneralJobConflictSQL interface.
+func (defaultConflictChecker) GeneralJobConflictSQL(job *model.Job) string {
+	if job.Type == model.ActionDropSchema {
+		return fmt.Sprintf("select job_id from mysql.tidb_ddl_job where find_in_set(%s, schema_ids) != 0 and processing limit 1", strconv.Quote(strconv.FormatInt(job.SchemaID, 10)))
+	}
+	// For general job, there is only 1 general worker to handle it, so at this moment the processing job must be reorg job and the reorg job must only contain one table id.
+	// So it's not possible the find_in_set("1,2", "1,2,3") occurs.
+	return fmt.Sprintf("select job_id from mysql.tidb_ddl_job t1, (select table_ids from mysql.tidb_ddl_job where job_id = %d) t2 where processing and find_in_set(t1.table_ids, t2.table_ids) != 0", job.ID)
+}
+
+// ReorgJobConflictSQL implements ConflictChecker.ReorgJobConflictSQL interface.
+func (defaultConflictChecker) ReorgJobConflictSQL(job *model.Job) string {
+	return fmt.Sprintf("select job_id from mysql.tidb_ddl_job where (find_in_set(%s, schema_ids) != 0 and type = %d and processing) or (find_in_set(%s, table_ids) != 0 and processing) limit 1",
+		strconv.Quote(strconv.FormatInt(job.SchemaID, 10)), model.ActionDropSchema, strconv.Quote(strconv.FormatInt(job.TableID, 10)))
+}
+
+// SetConflictChecker sets the ConflictChecker, a nil checker restores the default one.
+func (d *ddl) SetConflictChecker(c ConflictChecker) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.conflictChecker = c
+}
+
+// SetClock sets the source of the current time used by the dispatch loop, e.g. to check the job deadlines.
+// A nil clock restores timeutil.RealClock.
+func (d *ddl) SetClock(c timeutil.Clock) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.clock = c
+}
+
+// now returns the current time of the clock set by SetClock.
+func (dc *ddlCtx) now() time.Time {
+	dc.mu.RLock()
+	c := dc.mu.clock
+	dc.mu.RUnlock()
+	if c == nil {
+		return timeutil.RealClock.Now()
+	}
+	return c.Now()
+}
+
+func (d *ddl) getConflictChecker() ConflictChecker {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.mu.conflictChecker == nil {
+		return defaultConflictChecker{}
+	}
+	return d.mu.conflictChecker
+}
+
 func (d *ddl) getGeneralJob(sess *session) (*model.Job, error) {
+	checker := d.getConflictChecker()
 	return d.getJob(sess, general, func(job *model.Job) (bool, error) {
-		if job.Type == model.ActionDropSchema {
-			sql := fmt.Sprintf("select job_id from mysql.tidb_ddl_job where find_in_set(%s, schema_ids) != 0 and processing limit 1", strconv.Quote(strconv.FormatInt(job.SchemaID, 10)))
-			return d.checkJobIsRunnable(sess, sql)
-		}
-		// For general job, there is only 1 general worker to handle it, so at this moment the processing job must be reorg job and the reorg job must only contain one table id.
-		// So it's not possible the find_in_set("1,2", "1,2,3") occurs.
-		sql := fmt.Sprintf("select job_id from mysql.tidb_ddl_job t1, (select table_ids from mysql.tidb_ddl_job where job_id = %d) t2 where processing and find_in_set(t1.table_ids, t2.table_ids) != 0", job.ID)
-		return d.checkJobIsRunnable(sess, sql)
+		return d.checkJobIsRunnable(sess, checker.GeneralJobConflictSQL(job))
 	})
 }
 
@@ -143,13 +714,72 @@ func (d *ddl) checkJobIsRunnable(sess *session, sql string) (bool, error) {
 }
 
 func (d *ddl) getReorgJob(sess *session) (*model.Job, error) {
+	checker := d.getConflictChecker()
+	var isRunnable func(job *model.Job) (bool, error)
+	if _, ok := checker.(defaultConflictChecker); ok {
+		isRunnable = func(job *model.Job) (bool, error) {
+			// Fast path: all the jobs are runnable if no job is processing, which is common after startup.
+			processing, err := d.hasProcessingJobs(sess)
+			if err != nil || !processing {
+				return err == nil, err
+			}
+			conflicted, err := d.isReorgJobConflicted(sess, job)
+			return !conflicted, err
+		}
+	} else {
+		isRunnable = func(job *model.Job) (bool, error) {
+			return d.checkJobIsRunnable(sess, checker.ReorgJobConflictSQL(job))
+		}
+	}
+	eleTp := d.getReorgElementTypeFilter()
+	if eleTp == nil {
+		return d.getJob(sess, reorg, isRunnable)
+	}
 	return d.getJob(sess, reorg, func(job *model.Job) (bool, error) {
-		sql := fmt.Sprintf("select job_id from mysql.tidb_ddl_job where (find_in_set(%s, schema_ids) != 0 and type = %d and processing) or (find_in_set(%s, table_ids) != 0 and processing) limit 1",
-			strconv.Quote(strconv.FormatInt(job.SchemaID, 10)), model.ActionDropSchema, strconv.Quote(strconv.FormatInt(job.TableID, 10)))
-		return d.checkJobIsRunnable(sess, sql)
+		matched, err := matchReorgElementType(sess, job, eleTp)
+		if err != nil || !matched {
+			return false, err
+		}
+		return isRunnable(job)
 	})
 }
 
+// SetReorgElementTypeFilter makes the dispatch loop only pick up the reorg jobs of the element type, such as
+// meta.IndexElementKey, it's used for testing and diagnosis. A nil type disables the filter, which is the default.
+// The filter only applies to the reorg jobs that are not processing, the general jobs and the processing jobs
+// are always picked up.
+func (d *ddl) SetReorgElementTypeFilter(tp []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.reorgElementTypeFilter = tp
+}
+
+func (d *ddl) getReorgElementTypeFilter() []byte {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.mu.reorgElementTypeFilter
+}
+
+// matchReorgElementType checks whether the element type of the reorg job is tp. The element type is read from
+// mysql.tidb_ddl_reorg, or inferred from the job type if the job doesn't have a reorg handle yet.
+func matchReorgElementType(sess *session, job *model.Job, tp []byte) (bool, error) {
+	sql := fmt.Sprintf("select ele_type from mysql.tidb_ddl_reorg where job_id = %d", job.ID)
+	rows, err := sess.execute(context.Background(), sql, "get_reorg_element_type")
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	if len(rows) > 0 {
+		return bytes.Equal(rows[0].GetBytes(0), tp), nil
+	}
+	switch job.Type {
+	case model.ActionAddIndex, model.ActionAddPrimaryKey:
+		return bytes.Equal(meta.IndexElementKey, tp), nil
+	case model.ActionModifyColumn:
+		return bytes.Equal(meta.ColumnElementKey, tp), nil
+	}
+	return false, nil
+}
+
 func (d *ddl) startDispatchLoop() {
 	se, err := d.sessPool.get()
 	if err != nil {
@@ -157,23 +787,51 @@ func (d *ddl) startDispatchLoop() {
 	}
 	defer d.sessPool.put(se)
 	sess := newSession(se)
-	var notifyDDLJobByEtcdCh clientv3.WatchChan
+	var notifyDDLJobByEtcdCh, notifyReorgJobByEtcdCh, maintenanceModeCh, jobPriorityChangedCh clientv3.WatchChan
 	if d.etcdCli != nil {
 		notifyDDLJobByEtcdCh = d.etcdCli.Watch(d.ctx, addingDDLJobConcurrent)
+		notifyReorgJobByEtcdCh = d.etcdCli.Watch(d.ctx, addingDDLJobReorgConcurrent)
+		jobPriorityChangedCh = d.etcdCli.Watch(d.ctx, jobPriorityChangedKey)
+		maintenanceModeCh = d.etcdCli.Watch(d.ctx, ddlMaintenanceModeKey)
+		d.loadMaintenanceMode()
 	}
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
+	var lastCompactCheckTime, lastOrphanedCheckTime time.Time
+	// The orphaned processing jobs are reset once the node becomes the owner, see resetOrphanedProcessingJobs.
+	needResetOrphanedJobs := true
 	for {
 		if isChanClosed(d.ctx.Done()) {
 			return
 		}
-		if !variable.EnableConcurrentDDL.Load() || !d.isOwner() || d.waiting.Load() {
+		maintenanceModeCh = d.syncMaintenanceMode(maintenanceModeCh)
+		if !variable.EnableConcurrentDDL.Load() || !d.isOwner() || d.waiting.Load() || d.draining.Load() || d.maintenanceMode.Load() {
 			d.once.Store(true)
-			time.Sleep(time.Second)
+			// The priorities may be changed without notifying the node, e.g. it's not the owner.
+			d.invalidateJobPriorities()
+			if !d.isOwner() {
+				needResetOrphanedJobs = true
+			}
+			select {
+			case <-time.After(time.Second):
+			case <-d.ctx.Done():
+				return
+			}
 			continue
 		}
-		select {
-		case <-d.ddlJobCh:
+		if needResetOrphanedJobs {
+			if err := d.resetOrphanedProcessingJobs(sess); err != nil {
+				logutil.BgLogger().Warn("[ddl] reset the orphaned processing ddl jobs failed", zap.Error(err))
+			} else {
+				needResetOrphanedJobs = false
+			}
+		}
+		// Only the reorg pool is dispatched if only the reorg jobs are notified.
+		reorgOnly := false
+		select {
+		case <-d.ddlJobCh:
+		case <-d.reorgJobCh:
+			reorgOnly = true
 		case <-ticker.C:
 		case _, ok := <-notifyDDLJobByEtcdCh:
 			if !ok {
@@ -182,19 +840,420 @@ func (d *ddl) startDispatchLoop() {
 				time.Sleep(time.Second)
 				continue
 			}
+		case _, ok := <-notifyReorgJobByEtcdCh:
+			if !ok {
+				logutil.BgLogger().Warn("[ddl] start worker watch channel closed", zap.String("watch key", addingDDLJobReorgConcurrent))
+				notifyReorgJobByEtcdCh = d.etcdCli.Watch(d.ctx, addingDDLJobReorgConcurrent)
+				time.Sleep(time.Second)
+				continue
+			}
+			reorgOnly = true
+		case _, ok := <-jobPriorityChangedCh:
+			d.invalidateJobPriorities()
+			if !ok {
+				logutil.BgLogger().Warn("[ddl] job priority watch channel closed", zap.String("watch key", jobPriorityChangedKey))
+				jobPriorityChangedCh = d.etcdCli.Watch(d.ctx, jobPriorityChangedKey)
+				time.Sleep(time.Second)
+				continue
+			}
 		case <-d.ctx.Done():
 			return
 		}
-		d.loadDDLJobAndRun(sess, d.generalDDLWorkerPool, d.getGeneralJob)
-		d.loadDDLJobAndRun(sess, d.reorgWorkerPool, d.getReorgJob)
+		// The maintenance mode may be turned on while waiting for the jobs.
+		if d.maintenanceMode.Load() {
+			continue
+		}
+		if variable.DDLOrphanedJobPolicy.Load() == variable.OrphanedJobPolicyCancel {
+			if now := d.now(); now.Sub(lastOrphanedCheckTime) >= orphanedJobCheckInterval {
+				lastOrphanedCheckTime = now
+				d.cancelOrphanedJobs(sess)
+			}
+		}
+		if now := d.now(); now.Sub(lastCompactCheckTime) >= reorgHandleCompactCheckInterval {
+			lastCompactCheckTime = now
+			d.compactReorgHandles(sess)
+		}
+		if reorgOnly {
+			d.dispatchReorgOnce(sess)
+			continue
+		}
+		d.dispatchOnce(sess)
+	}
+}
+
+// dispatchOnce does a round of the dispatch loop, it delivers the runnable jobs to the idle workers of both pools.
+func (d *ddl) dispatchOnce(sess *session) {
+	d.invalidateProcessingJobs()
+	d.loadDDLJobsAndRun(sess, d.generalDDLWorkerPool, d.getGeneralJob)
+	d.loadDDLJobsAndRun(sess, d.reorgWorkerPool, d.getReorgJob)
+}
+
+// dispatchReorgOnce is like dispatchOnce, but it only delivers the runnable jobs to the reorg pool.
+func (d *ddl) dispatchReorgOnce(sess *session) {
+	d.invalidateProcessingJobs()
+	d.loadDDLJobsAndRun(sess, d.reorgWorkerPool, d.getReorgJob)
+}
+
+// SetMaintenanceMode turns on or off the maintenance mode of the cluster. The owner doesn't dispatch the DDL jobs
+// in the maintenance mode, but the running jobs still finish their current steps. The mode is persisted in etcd,
+// so it's honored by all the nodes and the new owner as well. Without etcd, e.g. with the mock store, it only applies
+// to the node itself. The mode can't be turned off while the DDL is paused by PauseAllDDL, see ResumeAllDDL.
+func (d *ddl) SetMaintenanceMode(ctx context.Context, on bool) error {
+	if !on {
+		se, err := d.sessPool.get()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		token, err := getDDLPauseToken(newSession(se), false)
+		d.sessPool.put(se)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(token) != 0 {
+			return errors.New("ddl is paused, please resume it by the token of the pause")
+		}
 	}
+	return d.setMaintenanceMode(ctx, on)
 }
 
-func (d *ddl) loadDDLJobAndRun(sess *session, pool *workerPool, getJob func(*session) (*model.Job, error)) {
+func (d *ddl) setMaintenanceMode(ctx context.Context, on bool) error {
+	if d.etcdCli != nil {
+		val := ddlMaintenanceModeOff
+		if on {
+			val = ddlMaintenanceModeOn
+		}
+		if err := util.PutKVToEtcd(ctx, d.etcdCli, 1, ddlMaintenanceModeKey, val); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// Apply the mode at once, the change from the watch is the same.
+	d.maintenanceMode.Store(on)
+	logutil.BgLogger().Info("[ddl] set ddl maintenance mode", zap.Bool("on", on))
+	return nil
+}
+
+// loadMaintenanceMode reads the maintenance mode from etcd, it's used when the key starts to be watched.
+func (d *ddl) loadMaintenanceMode() {
+	ctx, cancel := context.WithTimeout(d.ctx, util.KeyOpDefaultTimeout)
+	defer cancel()
+	resp, err := d.etcdCli.Get(ctx, ddlMaintenanceModeKey)
+	if err != nil {
+		logutil.BgLogger().Warn("[ddl] load ddl maintenance mode failed", zap.Error(err))
+		return
+	}
+	on := len(resp.Kvs) != 0 && string(resp.Kvs[0].Value) == ddlMaintenanceModeOn
+	d.maintenanceMode.Store(on)
+}
+
+// syncMaintenanceMode applies the pending changes of the maintenance mode from the watch channel without blocking,
+// it returns the channel to watch in the next round.
+func (d *ddl) syncMaintenanceMode(ch clientv3.WatchChan) clientv3.WatchChan {
+	if ch == nil {
+		return nil
+	}
+	for {
+		select {
+		case resp, ok := <-ch:
+			if !ok {
+				logutil.BgLogger().Warn("[ddl] maintenance mode watch channel closed", zap.String("watch key", ddlMaintenanceModeKey))
+				ch = d.etcdCli.Watch(d.ctx, ddlMaintenanceModeKey)
+				// The changes may be missed before watching again.
+				d.loadMaintenanceMode()
+				return ch
+			}
+			for _, ev := range resp.Events {
+				on := ev.Type == mvccpb.PUT && string(ev.Kv.Value) == ddlMaintenanceModeOn
+				d.maintenanceMode.Store(on)
+			}
+		default:
+			return ch
+		}
+	}
+}
+
+// ddlPauseTokenName is the variable name in mysql.tidb to persist the token of PauseAllDDL.
+const ddlPauseTokenName = "tidb_ddl_pause_token"
+
+// PauseAllDDL stops dispatching the DDL jobs in the cluster until ResumeAllDDL is called with the returned token,
+// the running jobs still continue. The pause is the maintenance mode guarded by the token, see SetMaintenanceMode.
+// The token is persisted before the mode is turned on, and it's removed if the mode can't be turned on.
+func (d *ddl) PauseAllDDL() (token string, err error) {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	sess := newSession(se)
+	token = uuid.New().String()
+	err = runInTxn(sess, func(sess *session) error {
+		current, err := getDDLPauseToken(sess, true)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(current) != 0 {
+			return errors.New("ddl is already paused")
+		}
+		return errors.Trace(putDDLPauseToken(sess, token))
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := d.setMaintenanceMode(d.ctx, true); err != nil {
+		if err1 := removeDDLPauseToken(sess, token); err1 != nil {
+			logutil.BgLogger().Warn("[ddl] remove the token of the failed pause failed", zap.String("token", token), zap.Error(err1))
+		}
+		return "", errors.Trace(err)
+	}
+	logutil.BgLogger().Info("[ddl] pause all ddl", zap.String("token", token))
+	return token, nil
+}
+
+// ResumeAllDDL resumes dispatching the DDL jobs paused by PauseAllDDL, the token must be the one returned by PauseAllDDL.
+// The maintenance mode is turned off only if the token matches, and the token is removed after that, so the pause is
+// kept if the mode can't be turned off.
+func (d *ddl) ResumeAllDDL(token string) error {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	sess := newSession(se)
+	current, err := getDDLPauseToken(sess, false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(current) == 0 {
+		return errors.New("ddl is not paused")
+	}
+	if current != token {
+		return errors.New("the token doesn't match the one of the pause")
+	}
+	if err := d.setMaintenanceMode(d.ctx, false); err != nil {
+		return errors.Trace(err)
+	}
+	if err := removeDDLPauseToken(sess, token); err != nil {
+		// Keep the pause consistent with the token, so it can be resumed again.
+		if err1 := d.setMaintenanceMode(d.ctx, true); err1 != nil {
+			logutil.BgLogger().Warn("[ddl] turn on the maintenance mode of the failed resume failed", zap.Error(err1))
+		}
+		return errors.Trace(err)
+	}
+	logutil.BgLogger().Info("[ddl] resume all ddl", zap.String("token", token))
+	asyncNotify(d.ddlJobCh)
+	return nil
+}
+
+func getDDLPauseToken(sess *session, forUpdate bool) (string, error) {
+	sql := fmt.Sprintf("select variable_value from mysql.%s where variable_name = '%s'", mysql.TiDBTable, ddlPauseTokenName)
+	if forUpdate {
+		sql += " for update"
+	}
+	rows, err := sess.execute(context.Background(), sql, "get_pause_token")
+	if err != nil || len(rows) == 0 {
+		return "", errors.Trace(err)
+	}
+	return rows[0].GetString(0), nil
+}
+
+func putDDLPauseToken(sess *session, token string) error {
+	sql := fmt.Sprintf("replace into mysql.%s values ('%s', '%s', 'The token to resume the paused DDL')", mysql.TiDBTable, ddlPauseTokenName, token)
+	_, err := sess.execute(context.Background(), sql, "pause_ddl")
+	return errors.Trace(err)
+}
+
+// removeDDLPauseToken removes the token of the pause if it's still the given one.
+func removeDDLPauseToken(sess *session, token string) error {
+	sql := fmt.Sprintf("delete from mysql.%s where variable_name = '%s' and variable_value = '%s'", mysql.TiDBTable, ddlPauseTokenName, token)
+	_, err := sess.execute(context.Background(), sql, "resume_ddl")
+	return errors.Trace(err)
+}
+
+// OrphanedBySchemaDrop returns the IDs of the pending DDL jobs whose schemas have been dropped.
+// Such jobs can't be executed successfully anymore, they are cancelled automatically if
+// tidb_ddl_orphaned_job_policy is CANCEL, otherwise the operators can decide how to handle them.
+func (d *ddl) OrphanedBySchemaDrop(sctx sessionctx.Context) ([]int64, error) {
+	ids, err := d.getOrphanedJobIDs(newSession(sctx))
+	return ids, errors.Trace(err)
+}
+
+// orphanedJobCheckInterval is the interval to check the orphaned jobs if tidb_ddl_orphaned_job_policy is CANCEL.
+var orphanedJobCheckInterval = 10 * time.Second
+
+// getOrphanedJobIDs cross-references the schema IDs of the pending jobs against the latest info schema, only the
+// schema_ids column is read, so the jobs aren't decoded.
+func (d *ddl) getOrphanedJobIDs(sess *session) ([]int64, error) {
+	sql := fmt.Sprintf("select job_id, schema_ids from mysql.tidb_ddl_job where not processing and type != %d order by job_id", model.ActionCreateSchema)
+	rows, err := sess.execute(context.Background(), sql, "get_orphaned_jobs")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	is := d.infoCache.GetLatest()
+	var ids []int64
+	for _, row := range rows {
+		// The schema of the create schema job doesn't exist until the job is done, it's excluded by the query.
+		for _, str := range strings.Split(row.GetString(1), ",") {
+			schemaID, err := strconv.ParseInt(str, 10, 64)
+			if err != nil || schemaID == 0 {
+				continue
+			}
+			if _, ok := is.SchemaByID(schemaID); !ok {
+				ids = append(ids, row.GetInt64(0))
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+func (d *ddl) cancelOrphanedJobs(sess *session) {
+	orphaned, err := d.getOrphanedJobIDs(sess)
+	if err != nil {
+		logutil.BgLogger().Warn("[ddl] get orphaned ddl jobs failed", zap.Error(err))
+		return
+	}
+	if len(orphaned) == 0 {
+		return
+	}
+	// The orphaned jobs are rare, so only they are decoded to skip the ones already being cancelled.
+	jobs, err := getJobsByIDs(sess, orphaned)
+	if err != nil {
+		logutil.BgLogger().Warn("[ddl] get orphaned ddl jobs failed", zap.Error(err))
+		return
+	}
+	ids := make([]int64, 0, len(orphaned))
+	for _, id := range orphaned {
+		if job, ok := jobs[id]; ok && !job.IsCancelling() {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	errs, err := CancelJobs(sess.session(), d.store, ids)
+	if err != nil {
+		logutil.BgLogger().Warn("[ddl] cancel orphaned ddl jobs failed", zap.Int64s("jobIDs", ids), zap.Error(err))
+		return
+	}
+	for i, id := range ids {
+		if errs[i] != nil {
+			logutil.BgLogger().Warn("[ddl] cancel orphaned ddl job failed", zap.Int64("jobID", id), zap.Error(errs[i]))
+			continue
+		}
+		logutil.BgLogger().Info("[ddl] cancel ddl job whose schema has been dropped", zap.Int64("jobID", id))
+	}
+}
+
+// CancelJobsForSchema cancels the pending DDL jobs of the schema, e.g. the jobs left after the schema is dropped.
+// The processing jobs are left untouched and only logged. It returns the count of the cancelled jobs.
+func (d *ddl) CancelJobsForSchema(schemaID int64) (int, error) {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	sql := fmt.Sprintf("select job_id, processing from mysql.tidb_ddl_job where find_in_set(%s, schema_ids) != 0",
+		strconv.Quote(strconv.FormatInt(schemaID, 10)))
+	rows, err := newSession(se).execute(context.Background(), sql, "get_schema_jobs")
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	var pendingIDs, processingIDs []int64
+	for _, row := range rows {
+		if row.GetInt64(1) != 0 {
+			processingIDs = append(processingIDs, row.GetInt64(0))
+		} else {
+			pendingIDs = append(pendingIDs, row.GetInt64(0))
+		}
+	}
+	if len(processingIDs) > 0 {
+		logutil.BgLogger().Info("[ddl] skip cancelling the processing ddl jobs of the schema",
+			zap.Int64("schemaID", schemaID), zap.Int64s("jobIDs", processingIDs))
+	}
+	if len(pendingIDs) == 0 {
+		return 0, nil
+	}
+	errs, err := CancelJobs(se, d.store, pendingIDs)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	cancelled := 0
+	for i, id := range pendingIDs {
+		if errs[i] != nil {
+			logutil.BgLogger().Warn("[ddl] cancel ddl job of the schema failed", zap.Int64("schemaID", schemaID),
+				zap.Int64("jobID", id), zap.Error(errs[i]))
+			continue
+		}
+		cancelled++
+	}
+	return cancelled, nil
+}
+
+// defaultTempReorgWorkerThreshold is the default duration a reorg job runs before the temporary reorg workers
+// can be created for the other reorg jobs.
+const defaultTempReorgWorkerThreshold = 10 * time.Minute
+
+// SetTempReorgWorkerLimit sets the max count of the temporary reorg workers and the duration a reorg job runs
+// before they can be created. When all the reorg workers are busy and a reorg job has been running longer than
+// threshold, the dispatch loop creates a temporary reorg worker for the other runnable reorg jobs, e.g. the jobs
+// of the other tables, so they aren't blocked by a huge add index job. A limit of 0 disables it, which is the default.
+func (d *ddl) SetTempReorgWorkerLimit(limit int32, threshold time.Duration) {
+	d.tempReorgWorkerLimit.Store(limit)
+	d.tempReorgWorkerThreshold.Store(threshold)
+}
+
+// getTempReorgWorker returns a temporary reorg worker if any reorg job has been running longer than the threshold.
+// The job to run is still chosen by getReorgJob, so the running jobs and the conflicted jobs are never picked up.
+func (d *ddl) getTempReorgWorker(sess *session, pool *workerPool) (*worker, error) {
+	limit := d.tempReorgWorkerLimit.Load()
+	if limit <= 0 {
+		return nil, nil
+	}
+	longRunning, err := d.hasLongRunningReorgJob(sess, d.tempReorgWorkerThreshold.Load())
+	if err != nil || !longRunning {
+		return nil, errors.Trace(err)
+	}
+	wk, err := pool.getTemp(limit)
+	if wk != nil {
+		logutil.BgLogger().Info("[ddl] create temporary reorg worker since the reorg workers are busy", zap.String("worker", wk.String()))
+	}
+	return wk, errors.Trace(err)
+}
+
+// hasLongRunningReorgJob checks whether any processing reorg job has been running longer than threshold.
+func (d *ddl) hasLongRunningReorgJob(sess *session, threshold time.Duration) (bool, error) {
+	jobs, err := getJobsBySQL(sess, JobTable, "processing and reorg")
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	now := d.now()
+	for _, job := range jobs {
+		if job.RealStartTS != 0 && now.Sub(oracle.GetTimeFromTS(job.RealStartTS)) > threshold {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// loadDDLJobsAndRun keeps delivering the jobs to the workers of the pool until no worker is available or
+// no runnable job is found, so the backlog is drained faster than one job per tick.
+func (d *ddl) loadDDLJobsAndRun(sess *session, pool *workerPool, getJob func(*session) (*model.Job, error)) {
+	dispatched := make(map[int64]struct{})
+	for d.loadDDLJobAndRun(sess, pool, getJob, dispatched) {
+	}
+}
+
+// loadDDLJobAndRun delivers a runnable job to a worker of the pool, and returns whether a job is delivered.
+// A job which finishes a step fast may be returned again in the same tick, it's left to the next tick
+// by dispatched, which records the jobs delivered in this tick, to avoid looping on it.
+func (d *ddl) loadDDLJobAndRun(sess *session, pool *workerPool, getJob func(*session) (*model.Job, error), dispatched map[int64]struct{}) bool {
 	wk, err := pool.get()
+	if err == nil && wk == nil && pool.tp() == reorg {
+		wk, err = d.getTempReorgWorker(sess, pool)
+	}
 	if err != nil || wk == nil {
 		logutil.BgLogger().Debug(fmt.Sprintf("[ddl] no %v worker available now", pool.tp()), zap.Error(err))
-		return
+		d.recordDispatchDecision(pool.tp(), 0, DispatchReasonNoIdleWorker, err)
+		return false
 	}
 
 	d.mu.RLock()
@@ -205,48 +1264,471 @@ func (d *ddl) loadDDLJobAndRun(sess *session, pool *workerPool, getJob func(*ses
 	if job == nil || err != nil {
 		if err != nil {
 			logutil.BgLogger().Warn("[ddl] get job met error", zap.Error(err))
+			d.recordDispatchDecision(pool.tp(), 0, DispatchReasonGetJobFailed, err)
+		} else {
+			d.recordDispatchDecision(pool.tp(), 0, DispatchReasonNoRunnableJob, nil)
 		}
 		pool.put(wk)
-		return
+		return false
+	}
+	// mockDispatchLatency delays delivering the job to simulate the slow scheduling in the chaos tests,
+	// the value is the delay in milliseconds, e.g. `return(500)`.
+	failpoint.Inject("mockDispatchLatency", func(val failpoint.Value) {
+		time.Sleep(time.Duration(val.(int)) * time.Millisecond)
+	})
+	if _, ok := dispatched[job.ID]; ok {
+		logutil.BgLogger().Debug("[ddl] skip the ddl job dispatched in this tick", jobZapFields(job)...)
+		d.recordDispatchDecision(pool.tp(), job.ID, DispatchReasonDispatchedInTick, nil)
+		pool.put(wk)
+		return false
 	}
 	d.mu.RLock()
 	d.mu.hook.OnGetJobAfter(pool.tp().String(), job)
 	d.mu.RUnlock()
 
+	dispatched[job.ID] = struct{}{}
+	d.recordDispatchDecision(pool.tp(), job.ID, DispatchReasonDispatched, nil)
 	d.delivery2worker(wk, pool, job)
+	return true
+}
+
+// DispatchLoopIdleDuration returns the time since the dispatch loop delivered the last job to a worker.
+// It keeps growing when there is no job to run, so it should be used together with the count of
+// pending jobs, e.g. only alert when there are pending jobs but the duration keeps growing.
+func (d *ddl) DispatchLoopIdleDuration() time.Duration {
+	return d.now().Sub(d.lastDispatchTime.Load())
+}
+
+// maxDispatchDecisions is the number of the recent dispatch decisions kept by the DDL.
+const maxDispatchDecisions = 64
+
+// DispatchReason is the reason of a dispatch decision.
+type DispatchReason string
+
+// The reasons of the dispatch decisions.
+const (
+	// DispatchReasonDispatched means the job is delivered to a worker.
+	DispatchReasonDispatched DispatchReason = "dispatched"
+	// DispatchReasonNoIdleWorker means all the workers of the pool are busy.
+	DispatchReasonNoIdleWorker DispatchReason = "no idle worker"
+	// DispatchReasonNoRunnableJob means no job of the pool can be run now.
+	DispatchReasonNoRunnableJob DispatchReason = "no runnable job"
+	// DispatchReasonGetJobFailed means reading the jobs from the job table failed.
+	DispatchReasonGetJobFailed DispatchReason = "get job failed"
+	// DispatchReasonBlocked means the job conflicts with the running jobs, or it's filtered out.
+	DispatchReasonBlocked DispatchReason = "blocked"
+	// DispatchReasonRunByOthers means the processing job is still run by another node.
+	DispatchReasonRunByOthers DispatchReason = "run by another node"
+	// DispatchReasonDispatchedInTick means the job is already delivered in this tick, it's left to the next tick.
+	DispatchReasonDispatchedInTick DispatchReason = "dispatched in this tick"
+	// DispatchReasonQuarantined means the job is quarantined since it fails repeatedly, see SetJobQuarantine.
+	DispatchReasonQuarantined DispatchReason = "quarantined"
+)
+
+// DispatchDecision is a decision made by the dispatch loop for a pool. The consecutive same decisions are merged,
+// Count is the number of them and Time is the time of the last one.
+type DispatchDecision struct {
+	Time   time.Time
+	Pool   string
+	JobID  int64
+	Reason DispatchReason
+	Err    error
+	Count  int
+}
+
+// recordDispatchDecision records a decision of the dispatch loop, jobID is 0 if the decision isn't about a job.
+func (dc *ddlCtx) recordDispatchDecision(tp jobType, jobID int64, reason DispatchReason, err error) {
+	dc.dispatchDecisions.Lock()
+	defer dc.dispatchDecisions.Unlock()
+	decisions := dc.dispatchDecisions.buf
+	now := dc.now()
+	if n := len(decisions); n > 0 {
+		last := &decisions[(dc.dispatchDecisions.start+n-1)%n]
+		if last.Pool == tp.String() && last.JobID == jobID && last.Reason == reason && errors.ErrorEqual(last.Err, err) {
+			last.Time = now
+			last.Count++
+			return
+		}
+	}
+	decision := DispatchDecision{Time: now, Pool: tp.String(), JobID: jobID, Reason: reason, Err: err, Count: 1}
+	if len(decisions) < maxDispatchDecisions {
+		dc.dispatchDecisions.buf = append(decisions, decision)
+		return
+	}
+	// The buffer is full, overwrite the oldest one.
+	decisions[dc.dispatchDecisions.start] = decision
+	dc.dispatchDecisions.start = (dc.dispatchDecisions.start + 1) % maxDispatchDecisions
+}
+
+// RecentDispatchDecisions returns the recent decisions of the dispatch loop from the oldest to the latest, it's used
+// to diagnose why a pending job isn't run without enabling the debug logs.
+func (d *ddl) RecentDispatchDecisions() []DispatchDecision {
+	d.dispatchDecisions.Lock()
+	defer d.dispatchDecisions.Unlock()
+	decisions := d.dispatchDecisions.buf
+	start := d.dispatchDecisions.start
+	res := make([]DispatchDecision, 0, len(decisions))
+	res = append(res, decisions[start:]...)
+	return append(res, decisions[:start]...)
+}
+
+// DecodeJobMeta decodes the job_meta column of mysql.tidb_ddl_job or mysql.tidb_ddl_history, it's used by the
+// diagnostic tools which read the job tables directly.
+func DecodeJobMeta(b []byte) (*model.Job, error) {
+	job := &model.Job{}
+	if err := job.Decode(b); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return job, nil
+}
+
+// HumanReadableJob renders the job in a readable form with its args, the args are rendered from the raw args
+// of a decoded job, or from the args if the raw args are absent.
+func HumanReadableJob(job *model.Job) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "ID:%d, Type:%s, State:%s, SchemaState:%s, SchemaID:%d, TableID:%d, SchemaName:%s, TableName:%s, Args:%s",
+		job.ID, job.Type, job.State, job.SchemaState, job.SchemaID, job.TableID, job.SchemaName, job.TableName, readableJobArgs(job.RawArgs, job.Args))
+	if job.MultiSchemaInfo != nil {
+		for i, sub := range job.MultiSchemaInfo.SubJobs {
+			fmt.Fprintf(&sb, ", SubJob%d:{Type:%s, State:%s, SchemaState:%s, Args:%s}",
+				i, sub.Type, sub.State, sub.SchemaState, readableJobArgs(sub.RawArgs, sub.Args))
+		}
+	}
+	return sb.String()
+}
+
+func readableJobArgs(rawArgs json.RawMessage, args []interface{}) string {
+	// A job encoded without the raw args has the null raw args after decoding.
+	if len(rawArgs) != 0 && string(rawArgs) != "null" {
+		return string(rawArgs)
+	}
+	if len(args) == 0 {
+		return "[]"
+	}
+	b, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Sprintf("%v", args)
+	}
+	return string(b)
+}
+
+// jobZapFields returns the fields to identify the job in the logs, so the lifecycle of a job can be grepped.
+func jobZapFields(job *model.Job) []zap.Field {
+	fields := []zap.Field{zap.Int64("jobID", job.ID), zap.String("type", job.Type.String())}
+	// job2UniqueIDs needs CtxVars for some jobs, which aren't stored in the job meta.
+	schemaIDs, err := job2SchemaIDs(job)
+	if err != nil {
+		return append(fields, zap.Int64("schemaID", job.SchemaID), zap.Int64("tableID", job.TableID))
+	}
+	tableIDs, err := job2TableIDs(job)
+	if err != nil {
+		return append(fields, zap.Int64("schemaID", job.SchemaID), zap.Int64("tableID", job.TableID))
+	}
+	return append(fields, zap.String("schemaIDs", schemaIDs), zap.String("tableIDs", tableIDs))
+}
+
+// SetSchemaSyncWait sets the max time to wait for all TiDB servers to sync the schema change, it's useful for
+// the clusters with many TiDB servers or slow networks. The wait can't be shorter than the lease, 0 restores
+// the default 2 * lease.
+func (d *ddl) SetSchemaSyncWait(wait time.Duration) error {
+	if wait != 0 && wait < d.lease {
+		return errors.Errorf("the schema sync wait %v is shorter than the lease %v", wait, d.lease)
+	}
+	d.schemaSyncWait.Store(wait)
+	return nil
+}
+
+// SetSkipOwnerChangeSyncWait sets whether to skip waiting for the schema sync before the first job is run after
+// the node becomes the owner. It's only used to speed up the tests with a mock or single-node store. It's UNSAFE
+// for the multi-node clusters, where the previous owner may still be running a job which isn't synced yet.
+func (d *ddl) SetSkipOwnerChangeSyncWait(skip bool) {
+	d.skipOwnerChangeSyncWait.Store(skip)
+}
+
+// needWaitSchemaSynced returns whether to wait for the schema sync before running the job.
+func (dc *ddlCtx) needWaitSchemaSynced(job *model.Job) bool {
+	return !dc.isSynced(job) || (dc.once.Load() && !dc.skipOwnerChangeSyncWait.Load())
+}
+
+// getSchemaSyncWait returns the max time to wait for all TiDB servers to sync the schema change.
+func (dc *ddlCtx) getSchemaSyncWait() time.Duration {
+	if wait := dc.schemaSyncWait.Load(); wait != 0 {
+		return wait
+	}
+	return 2 * dc.lease
 }
 
 func (d *ddl) delivery2worker(wk *worker, pool *workerPool, job *model.Job) {
 	injectFailPointForGetJob(job)
+	d.lastDispatchTime.Store(d.now())
 	d.insertRunningDDLJobMap(job.ID)
+	d.markJobTaken(job)
+	logger := logutil.BgLogger().With(jobZapFields(job)...)
+	logger.Debug("[ddl] deliver ddl job to worker", zap.String("worker", wk.String()))
 	d.wg.Run(func() {
 		metrics.DDLRunningJobCount.WithLabelValues(pool.tp().String()).Inc()
+		var runErr error
 		defer func() {
 			pool.put(wk)
 			d.deleteRunningDDLJobMap(job.ID)
 			asyncNotify(d.ddlJobCh)
 			metrics.DDLRunningJobCount.WithLabelValues(pool.tp().String()).Dec()
+			d.sendJobResult(JobResult{JobID: job.ID, Err: runErr})
 		}()
-		// we should wait 2 * d.lease time to guarantee all TiDB server have finished the schema change.
-		// see waitSchemaSynced for more details.
-		if !d.isSynced(job) || d.once.Load() {
-			err := wk.waitSchemaSynced(d.ddlCtx, job, 2*d.lease)
+		// The job must be published before its step runs, otherwise the owner may take it as orphaned and run it again.
+		if err := d.publishRunningJobs(); err != nil {
+			logger.Warn("[ddl] skip the step of the ddl job which isn't published to the running jobs registry", zap.Error(err))
+			runErr = err
+			time.Sleep(time.Second)
+			return
+		}
+		// we should wait 2 * d.lease time or the configured wait to guarantee all TiDB server have finished
+		// the schema change. see waitSchemaSynced for more details.
+		if d.needWaitSchemaSynced(job) {
+			err := wk.waitSchemaSynced(d.ddlCtx, job, d.getSchemaSyncWait())
 			if err == nil {
 				d.once.Store(false)
 			} else {
-				logutil.BgLogger().Warn("[ddl] wait ddl job sync failed", zap.Error(err), zap.String("job", job.String()))
+				logger.Warn("[ddl] wait ddl job sync failed", zap.Error(err), zap.String("job", job.String()))
+				runErr = err
 				time.Sleep(time.Second)
 				return
 			}
 		}
-		if err := wk.HandleDDLJobTable(d.ddlCtx, job); err != nil {
-			logutil.BgLogger().Info("[ddl] handle ddl job failed", zap.Error(err), zap.String("job", job.String()))
+		if err := d.rewriteJobArgs(wk.sess, job); err != nil {
+			logger.Warn("[ddl] rewrite ddl job args failed", zap.Error(err), zap.String("job", job.String()))
+			runErr = err
+			return
+		}
+		cancelJobIfDeadlineExceeded(job, d.now())
+		err := wk.HandleDDLJobTable(d.ddlCtx, job)
+		if err != nil {
+			logger.Info("[ddl] handle ddl job failed", zap.Error(err), zap.String("job", job.String()))
+			runErr = err
+		}
+		d.recordJobFailure(job, err)
+		if job.IsFinished() || job.IsSynced() {
+			d.forgetJobTaken(job.ID)
 		}
 	})
 }
 
+// defaultJobQuarantineBackoff is the default duration a job is quarantined after it fails repeatedly.
+const defaultJobQuarantineBackoff = time.Minute
+
+// jobFailure is the consecutive failures of a job, the job isn't dispatched before quarantinedUntil.
+type jobFailure struct {
+	count            int32
+	quarantinedUntil time.Time
+}
+
+// SetJobQuarantine sets the count of the consecutive failures to quarantine a job and the duration of the quarantine.
+// A job fails if the worker meets an error handling it, e.g. the job table can't be updated. Such a job is
+// re-dispatched at once, so a job failing deterministically wastes a worker each time. After the threshold is
+// reached, the job isn't dispatched until the backoff passes. A threshold of 0 disables it, which is the default.
+func (d *ddl) SetJobQuarantine(threshold int32, backoff time.Duration) {
+	d.jobQuarantineThreshold.Store(threshold)
+	d.jobQuarantineBackoff.Store(backoff)
+}
+
+// recordJobFailure counts the consecutive failures of the job, err is nil if the job is handled successfully,
+// which resets the count. The failures aren't counted if the quarantine is disabled.
+func (dc *ddlCtx) recordJobFailure(job *model.Job, err error) {
+	dc.jobFailures.Lock()
+	defer dc.jobFailures.Unlock()
+	if err == nil {
+		delete(dc.jobFailures.m, job.ID)
+		return
+	}
+	threshold := dc.jobQuarantineThreshold.Load()
+	if threshold <= 0 {
+		return
+	}
+	f, ok := dc.jobFailures.m[job.ID]
+	if !ok {
+		f = &jobFailure{}
+		dc.jobFailures.m[job.ID] = f
+	}
+	f.count++
+	if f.count < threshold {
+		return
+	}
+	backoff := dc.jobQuarantineBackoff.Load()
+	f.count = 0
+	f.quarantinedUntil = dc.now().Add(backoff)
+	logutil.BgLogger().Warn("[ddl] quarantine the ddl job since it fails repeatedly",
+		append(jobZapFields(job), zap.Int32("failures", threshold), zap.Duration("backoff", backoff), zap.Error(err))...)
+}
+
+// isJobQuarantined checks whether the job is quarantined by recordJobFailure.
+func (dc *ddlCtx) isJobQuarantined(id int64) bool {
+	dc.jobFailures.Lock()
+	defer dc.jobFailures.Unlock()
+	f, ok := dc.jobFailures.m[id]
+	return ok && dc.now().Before(f.quarantinedUntil)
+}
+
+// JobResult is the outcome of a run of a job by a worker, Err is nil if the run succeeds. A job is run for each
+// step of it, so there are several results of a job.
+type JobResult struct {
+	JobID int64
+	Err   error
+}
+
+// SetJobResultCh sets the channel to receive the result after each run of a job by the workers of the node, nil
+// removes it. The result is dropped if the channel is full, so a slow receiver doesn't block the workers.
+func (d *ddl) SetJobResultCh(ch chan<- JobResult) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.resultCh = ch
+}
+
+func (dc *ddlCtx) sendJobResult(res JobResult) {
+	dc.mu.RLock()
+	ch := dc.mu.resultCh
+	dc.mu.RUnlock()
+	if ch == nil {
+		return
+	}
+	select {
+	case ch <- res:
+	default:
+		logutil.BgLogger().Debug("[ddl] drop the ddl job result since the channel is full", zap.Int64("jobID", res.JobID))
+	}
+}
+
+// cancelJobIfDeadlineExceeded marks the job as cancelling if its persisted deadline is exceeded at now.
+// The job keeps running if it can't be rolled back anymore.
+func cancelJobIfDeadlineExceeded(job *model.Job, now time.Time) {
+	deadline, ok := job.Deadline()
+	if !ok || now.Before(deadline) {
+		return
+	}
+	if job.IsDone() || job.IsSynced() || job.IsCancelling() || job.IsCancelled() || job.IsRollingback() || job.IsRollbackDone() {
+		return
+	}
+	if !job.IsRollbackable() {
+		logutil.BgLogger().Warn("[ddl] ddl job exceeds the deadline but can't be cancelled", zap.Time("deadline", deadline), zap.String("job", job.String()))
+		return
+	}
+	logutil.BgLogger().Info("[ddl] cancel ddl job since it exceeds the deadline", zap.Time("deadline", deadline), zap.String("job", job.String()))
+	job.State = model.JobStateCancelling
+}
+
+// JobArgsRewriter rewrites the args of a DDL job before it's executed by the worker.
+// It's an advanced and dangerous hook used by compatibility shims to patch in-flight jobs,
+// so it's off by default. It may be called multiple times for the same job, once for each
+// time the job is delivered to a worker, so the rewrite must be idempotent.
+type JobArgsRewriter interface {
+	// RewriteJobArgs can mutate job.Args, it returns true if the job is rewritten.
+	RewriteJobArgs(job *model.Job) (bool, error)
+}
+
+// SetJobArgsRewriter sets the JobArgsRewriter, a nil rewriter disables the rewrite.
+func (d *ddl) SetJobArgsRewriter(r JobArgsRewriter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.jobArgsRewriter = r
+}
+
+// JobValidator validates the DDL jobs before they are run by the worker.
+type JobValidator interface {
+	// ValidateJob returns an error if the job is invalid, the job will be cancelled.
+	ValidateJob(job *model.Job) error
+}
+
+// SetJobValidator sets the JobValidator, a nil validator disables the validation.
+func (d *ddl) SetJobValidator(v JobValidator) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.jobValidator = v
+}
+
+// JobValidationChecksum calculates the checksum of the validation inputs of the job,
+// i.e. the job type, the schema ID, the table ID and the arguments.
+func JobValidationChecksum(job *model.Job) (uint32, error) {
+	args := []byte(job.RawArgs)
+	if job.Args != nil {
+		var err error
+		args, err = json.Marshal(job.Args)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	h := crc32.NewIEEE()
+	_, _ = fmt.Fprintf(h, "%d,%d,%d,", job.Type, job.SchemaID, job.TableID)
+	_, _ = h.Write(args)
+	return h.Sum32(), nil
+}
+
+// validateJob validates the job by the JobValidator. The validation is skipped if the job
+// is pre-validated by the submitter and the validation inputs are not changed.
+func (d *ddlCtx) validateJob(job *model.Job) error {
+	d.mu.RLock()
+	v := d.mu.jobValidator
+	d.mu.RUnlock()
+	if v == nil {
+		return nil
+	}
+	if job.ValidationChecksum != 0 {
+		checksum, err := JobValidationChecksum(job)
+		if err == nil && checksum == job.ValidationChecksum {
+			return nil
+		}
+		logutil.BgLogger().Warn("[ddl] validation checksum mismatch, validate the job", zap.Int64("jobID", job.ID),
+			zap.Uint32("expected", job.ValidationChecksum), zap.Uint32("actual", checksum), zap.Error(err))
+	}
+	return v.ValidateJob(job)
+}
+
+// rewriteJobArgs calls the JobArgsRewriter on the job and persists the rewritten job.
+func (d *ddl) rewriteJobArgs(sess *session, job *model.Job) error {
+	d.mu.RLock()
+	rewriter := d.mu.jobArgsRewriter
+	d.mu.RUnlock()
+	if rewriter == nil {
+		return nil
+	}
+	rewritten, err := rewriter.RewriteJobArgs(job)
+	if err != nil || !rewritten {
+		return errors.Trace(err)
+	}
+	logutil.BgLogger().Info("[ddl] ddl job args are rewritten", zap.String("job", job.String()))
+	// The job is decoded by the worker from RawArgs, so RawArgs must be updated as well.
+	return updateDDLJob2Table(sess, job, job.Args != nil)
+}
+
+const (
+	markJobProcessingMaxRetryCnt = 3
+	markJobProcessingBackoff     = 10 * time.Millisecond
+)
+
+// markJobProcessing marks the job as processing. It retries on the retryable errors like write conflicts,
+// which is safe since setting processing to 1 is idempotent.
 func (d *ddl) markJobProcessing(sess *session, job *model.Job) error {
 	sess.SetDiskFullOpt(kvrpcpb.DiskFullOpt_AllowedOnAlmostFull)
+	var err error
+	backoff := markJobProcessingBackoff
+	for i := 0; i < markJobProcessingMaxRetryCnt; i++ {
+		if i > 0 {
+			logutil.BgLogger().Info("[ddl] retry to mark job processing", zap.Int64("jobID", job.ID), zap.Int("retryCnt", i), zap.Error(err))
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		err = markJobProcessingOnce(sess, job)
+		if !kv.IsTxnRetryableError(err) {
+			break
+		}
+	}
+	return errors.Trace(err)
+}
+
+func markJobProcessingOnce(sess *session, job *model.Job) error {
+	failpoint.Inject("mockMarkJobProcessingConflict", func(val failpoint.Value) {
+		if val.(bool) {
+			failpoint.Return(kv.ErrWriteConflict.FastGenByArgs(0, 0, 0, "mockMarkJobProcessingConflict"))
+		}
+	})
 	_, err := sess.execute(context.Background(), fmt.Sprintf("update mysql.tidb_ddl_job set processing = 1 where job_id = %d", job.ID), "mark_job_processing")
 	return errors.Trace(err)
 }
@@ -256,7 +1738,39 @@ const (
 	updateDDLJobSQL = "update mysql.tidb_ddl_job set job_meta = %s where job_id = %d"
 )
 
-func insertDDLJobs2Table(sess *session, updateRawArgs bool, jobs ...*model.Job) error {
+// JobWithIDs is a DDL job with the schema IDs and table IDs written to mysql.tidb_ddl_job precomputed.
+// It can be inserted repeatedly, e.g. when retrying, without recomputing the IDs.
+type JobWithIDs struct {
+	*model.Job
+	schemaIDs string
+	tableIDs  string
+	// err is the error to compute the IDs, it's returned when the job is inserted.
+	err error
+}
+
+// NewJobWithIDs creates a JobWithIDs, the IDs are computed by the current job, so it should be called after the
+// IDs of the job are settled. If the IDs can't be computed, e.g. the CtxVars of a rename table job is malformed,
+// the error is returned by insertDDLJobs2Table.
+func NewJobWithIDs(job *model.Job) *JobWithIDs {
+	j := &JobWithIDs{Job: job}
+	j.schemaIDs, j.err = job2SchemaIDs(job)
+	if j.err == nil {
+		j.tableIDs, j.err = job2TableIDs(job)
+	}
+	return j
+}
+
+// SchemaIDs returns the comma separated schema IDs of the job.
+func (j *JobWithIDs) SchemaIDs() string {
+	return j.schemaIDs
+}
+
+// TableIDs returns the comma separated table IDs of the job.
+func (j *JobWithIDs) TableIDs() string {
+	return j.tableIDs
+}
+
+func insertDDLJobs2Table(sess *session, updateRawArgs bool, jobs ...*JobWithIDs) error {
 	failpoint.Inject("mockAddBatchDDLJobsErr", func(val failpoint.Value) {
 		if val.(bool) {
 			failpoint.Return(errors.Errorf("mockAddBatchDDLJobsErr"))
@@ -268,6 +1782,10 @@ func insertDDLJobs2Table(sess *session, updateRawArgs bool, jobs ...*model.Job)
 	var sql bytes.Buffer
 	sql.WriteString(addDDLJobSQL)
 	for i, job := range jobs {
+		if job.err != nil {
+			logutil.BgLogger().Warn("[ddl] add job to mysql.tidb_ddl_job table failed", zap.String("job", job.String()), zap.Error(job.err))
+			return errors.Trace(job.err)
+		}
 		b, err := job.Encode(updateRawArgs)
 		if err != nil {
 			return err
@@ -275,7 +1793,7 @@ func insertDDLJobs2Table(sess *session, updateRawArgs bool, jobs ...*model.Job)
 		if i != 0 {
 			sql.WriteString(",")
 		}
-		sql.WriteString(fmt.Sprintf("(%d, %t, %s, %s, %s, %d, %t)", job.ID, job.MayNeedReorg(), strconv.Quote(job2SchemaIDs(job)), strconv.Quote(job2TableIDs(job)), wrapKey2String(b), job.Type, !job.NotStarted()))
+		sql.WriteString(fmt.Sprintf("(%d, %t, %s, %s, %s, %d, %t)", job.ID, job.MayNeedReorg(), strconv.Quote(job.schemaIDs), strconv.Quote(job.tableIDs), wrapKey2String(b), job.Type, !job.NotStarted()))
 	}
 	sess.SetDiskFullOpt(kvrpcpb.DiskFullOpt_AllowedOnAlmostFull)
 	ctx := kv.WithInternalSourceType(context.Background(), kv.InternalTxnDDL)
@@ -284,39 +1802,57 @@ func insertDDLJobs2Table(sess *session, updateRawArgs bool, jobs ...*model.Job)
 	return errors.Trace(err)
 }
 
-func job2SchemaIDs(job *model.Job) string {
+// Job2SchemaIDs returns the value of the schema_ids column of the job in mysql.tidb_ddl_job, which is encoded in the
+// same way as the job is inserted. It's used by the tools which write the job table, the IDs of the multi-table jobs
+// are read from CtxVars, so an error is returned if CtxVars is malformed.
+func Job2SchemaIDs(job *model.Job) (string, error) {
+	return job2SchemaIDs(job)
+}
+
+// Job2TableIDs is like Job2SchemaIDs, but it returns the value of the table_ids column.
+func Job2TableIDs(job *model.Job) (string, error) {
+	return job2TableIDs(job)
+}
+
+func job2SchemaIDs(job *model.Job) (string, error) {
 	return job2UniqueIDs(job, true)
 }
 
-func job2TableIDs(job *model.Job) string {
+func job2TableIDs(job *model.Job) (string, error) {
 	return job2UniqueIDs(job, false)
 }
 
-func job2UniqueIDs(job *model.Job, schema bool) string {
+func job2UniqueIDs(job *model.Job, schema bool) (string, error) {
 	switch job.Type {
 	case model.ActionExchangeTablePartition, model.ActionRenameTables, model.ActionRenameTable:
-		var ids []int64
+		// CtxVars is set by the caller to the schema IDs and the table IDs, check it in case of a malformed job.
+		if len(job.CtxVars) < 2 {
+			return "", errors.Errorf("malformed CtxVars of the %s job %d: want the schema IDs and the table IDs, got %d elements",
+				job.Type, job.ID, len(job.CtxVars))
+		}
+		idx := 1
 		if schema {
-			ids = job.CtxVars[0].([]int64)
-		} else {
-			ids = job.CtxVars[1].([]int64)
+			idx = 0
 		}
-		set := make(map[int64]struct{}, len(ids))
-		for _, id := range ids {
-			set[id] = struct{}{}
+		ids, ok := job.CtxVars[idx].([]int64)
+		if !ok {
+			return "", errors.Errorf("malformed CtxVars of the %s job %d: want []int64 at %d, got %T", job.Type, job.ID, idx, job.CtxVars[idx])
 		}
+		// Sort the IDs numerically, so the column value is readable, e.g. "9,10" instead of "10,9".
+		sorted := slices.Clone(ids)
+		slices.Sort(sorted)
+		sorted = slices.Compact(sorted)
 
-		s := make([]string, 0, len(set))
-		for id := range set {
+		s := make([]string, 0, len(sorted))
+		for _, id := range sorted {
 			s = append(s, strconv.FormatInt(id, 10))
 		}
-		slices.Sort(s)
-		return strings.Join(s, ",")
+		return strings.Join(s, ","), nil
 	}
 	if schema {
-		return strconv.FormatInt(job.SchemaID, 10)
+		return strconv.FormatInt(job.SchemaID, 10), nil
 	}
-	return strconv.FormatInt(job.TableID, 10)
+	return strconv.FormatInt(job.TableID, 10), nil
 }
 
 func (w *worker) deleteDDLJob(job *model.Job) error {
@@ -325,13 +1861,24 @@ func (w *worker) deleteDDLJob(job *model.Job) error {
 	return errors.Trace(err)
 }
 
+// reencodeJobRawArgs encodes the job with its RawArgs re-serialized from Args, it should be used after Args is
+// mutated, otherwise the stale RawArgs is persisted, which the downstream such as TiCDC relies on.
+func reencodeJobRawArgs(job *model.Job) ([]byte, error) {
+	b, err := job.Encode(true)
+	return b, errors.Trace(err)
+}
+
 func updateDDLJob2Table(sctx *session, job *model.Job, updateRawArgs bool) error {
 	b, err := job.Encode(updateRawArgs)
 	if err != nil {
 		return err
 	}
-	sql := fmt.Sprintf(updateDDLJobSQL, wrapKey2String(b), job.ID)
-	_, err = sctx.execute(context.Background(), sql, "update_job")
+	return updateJobMeta2Table(sctx, job.ID, b)
+}
+
+func updateJobMeta2Table(sctx *session, jobID int64, meta []byte) error {
+	sql := fmt.Sprintf(updateDDLJobSQL, wrapKey2String(meta), jobID)
+	_, err := sctx.execute(context.Background(), sql, "update_job")
 	return errors.Trace(err)
 }
 
@@ -371,6 +1918,374 @@ func getDDLReorgHandle(sess *session, job *model.Job) (element *meta.Element, st
 	return
 }
 
+// reorgProgress estimates the percentage of the reorganization progress of the job on the current physical table.
+// The progress is the position of the current start key of the handle in the range from the first row of the
+// physical table to the end key of the handle. The estimate is approximate for the clustered tables with common
+// handles, because the handles can't be measured by arithmetic.
+func reorgProgress(sess *session, job *model.Job) (float64, error) {
+	_, startKey, endKey, physicalTableID, err := getDDLReorgHandle(sess, job)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	prefix := tablecodec.GenTableRecordPrefix(physicalTableID)
+	it, err := sess.GetStore().GetSnapshot(kv.MaxVersion).Iter(prefix, prefix.PrefixNext())
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer it.Close()
+	if !it.Valid() || !it.Key().HasPrefix(prefix) {
+		// The physical table is empty.
+		return 100, nil
+	}
+	return estimateReorgProgress(it.Key(), startKey, endKey), nil
+}
+
+func estimateReorgProgress(originKey, startKey, endKey kv.Key) float64 {
+	if startKey.Cmp(originKey) <= 0 {
+		return 0
+	}
+	if startKey.Cmp(endKey) >= 0 {
+		return 100
+	}
+	origin, err1 := tablecodec.DecodeRowKey(originKey)
+	start, err2 := tablecodec.DecodeRowKey(startKey)
+	end, err3 := tablecodec.DecodeRowKey(endKey)
+	if err1 == nil && err2 == nil && err3 == nil && origin.IsInt() && start.IsInt() && end.IsInt() {
+		done := float64(start.IntValue()) - float64(origin.IntValue())
+		total := float64(end.IntValue()) - float64(origin.IntValue())
+		return done / total * 100
+	}
+	// For common handles, compare the first 8 bytes after the common prefix of the keys.
+	l := 0
+	for l < len(originKey) && l < len(endKey) && originKey[l] == endKey[l] {
+		l++
+	}
+	prefixUint64 := func(key kv.Key) float64 {
+		var buf [8]byte
+		if l < len(key) {
+			copy(buf[:], key[l:])
+		}
+		return float64(binary.BigEndian.Uint64(buf[:]))
+	}
+	total := prefixUint64(endKey) - prefixUint64(originKey)
+	if total <= 0 {
+		return 0
+	}
+	return (prefixUint64(startKey) - prefixUint64(originKey)) / total * 100
+}
+
+// ReorgProgress returns the estimated percentage of the reorganization progress of the job, see reorgProgress.
+func (d *ddl) ReorgProgress(job *model.Job) (float64, error) {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	return reorgProgress(newSession(se), job)
+}
+
+const defaultReorgHandleCompactThreshold = 1024
+
+// defaultPendingJobAgeInterval is the default interval to report the age of the oldest pending job.
+const defaultPendingJobAgeInterval = 30 * time.Second
+
+// SetPendingJobAgeInterval sets the interval to scan the job table and report the age of the oldest pending job
+// by metrics.DDLOldestPendingJobAge and the count of the job groups by metrics.DDLPendingJobGroups. 0 or a negative
+// interval disables the report, and the metrics are reset to 0.
+func (d *ddl) SetPendingJobAgeInterval(interval time.Duration) {
+	d.pendingJobAgeInterval.Store(interval)
+	asyncNotify(d.pendingJobAgeIntervalCh)
+}
+
+// startReportPendingJobAge reports the age of the oldest pending job and the count of the job groups periodically,
+// it's only done by the owner.
+func (d *ddl) startReportPendingJobAge() {
+	for {
+		// The nil channel is never ready, so only the change of the interval wakes up the disabled report.
+		var tickCh <-chan time.Time
+		if interval := d.pendingJobAgeInterval.Load(); interval > 0 {
+			tickCh = time.After(interval)
+		} else {
+			metrics.DDLOldestPendingJobAge.Set(0)
+			metrics.DDLPendingJobGroups.Set(0)
+		}
+		select {
+		case <-tickCh:
+		case <-d.pendingJobAgeIntervalCh:
+			continue
+		case <-d.ctx.Done():
+			return
+		}
+		if !variable.EnableConcurrentDDL.Load() || !d.isOwner() {
+			metrics.DDLOldestPendingJobAge.Set(0)
+			metrics.DDLPendingJobGroups.Set(0)
+			continue
+		}
+		age, err := d.oldestPendingJobAge()
+		if err != nil {
+			logutil.BgLogger().Warn("[ddl] get the age of the oldest pending ddl job failed", zap.Error(err))
+		} else {
+			metrics.DDLOldestPendingJobAge.Set(age.Seconds())
+		}
+		groups, err := d.pendingJobGroups()
+		if err != nil {
+			logutil.BgLogger().Warn("[ddl] get the count of the ddl job groups failed", zap.Error(err))
+		} else {
+			metrics.DDLPendingJobGroups.Set(float64(groups))
+		}
+	}
+}
+
+// pendingJobGroups returns the count of the distinct schema and table groups of the jobs, it's the same grouping
+// as getJobSQL, so it's the max count of the jobs can be dispatched in parallel.
+func (d *ddl) pendingJobGroups() (int64, error) {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	rows, err := newSession(se).execute(context.Background(),
+		"select count(*) from (select 1 from mysql.tidb_ddl_job group by schema_ids, table_ids) t", "get_job_groups")
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return rows[0].GetInt64(0), nil
+}
+
+// oldestPendingJobAge returns the age of the oldest pending job by its start TS, or 0 if there is no pending job.
+// The job IDs are allocated in the order the jobs are submitted, so only the pending job with the min ID is decoded.
+func (d *ddl) oldestPendingJobAge() (time.Duration, error) {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	jobs, err := getJobsBySQL(newSession(se), JobTable, "not processing order by job_id limit 1")
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if len(jobs) == 0 {
+		return 0, nil
+	}
+	if age := d.now().Sub(model.TSConvert2Time(jobs[0].StartTS)); age > 0 {
+		return age, nil
+	}
+	return 0, nil
+}
+
+// reorgHandleCompactCheckInterval is the interval to check the row count of the reorg handle table.
+var reorgHandleCompactCheckInterval = time.Minute
+
+// SetReorgHandleCompactThreshold sets the row count of the reorg handle table to trigger the compaction,
+// which removes the orphan reorg handles. A non-positive threshold disables the compaction.
+func (d *ddl) SetReorgHandleCompactThreshold(threshold int64) {
+	d.reorgHandleCompactThreshold.Store(threshold)
+}
+
+// compactReorgHandles removes the orphan reorg handles if the reorg handle table is too large,
+// the handles may be left in the table when TiDB crashes. It's only called by the owner.
+func (d *ddl) compactReorgHandles(sess *session) {
+	threshold := d.reorgHandleCompactThreshold.Load()
+	if threshold <= 0 {
+		return
+	}
+	rows, err := sess.execute(context.Background(), "select count(1) from mysql.tidb_ddl_reorg", "count_handles")
+	if err != nil {
+		logutil.BgLogger().Warn("[ddl] count reorg handles failed", zap.Error(err))
+		return
+	}
+	cnt := rows[0].GetInt64(0)
+	if cnt <= threshold {
+		return
+	}
+	removed, err := gcOrphanReorgHandles(sess)
+	logutil.BgLogger().Info("[ddl] compact the reorg handle table", zap.Int64("rowCount", cnt),
+		zap.Int64("threshold", threshold), zap.Int("removed", removed), zap.Error(err))
+}
+
+// gcOrphanReorgHandles removes the reorg handles whose jobs are not in the job table, it returns the count of
+// the removed handles.
+func gcOrphanReorgHandles(sess *session) (removed int, err error) {
+	const condition = "job_id not in (select job_id from mysql.tidb_ddl_job)"
+	err = runInTxn(sess, func(se *session) error {
+		rows, err := se.execute(context.Background(), "select count(1) from mysql.tidb_ddl_reorg where "+condition, "count_orphan_handles")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		removed = int(rows[0].GetInt64(0))
+		if removed == 0 {
+			return nil
+		}
+		_, err = se.execute(context.Background(), "delete from mysql.tidb_ddl_reorg where "+condition, "gc_orphan_handles")
+		return errors.Trace(err)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// KeyRangeCompactor is implemented by the stores which can compact a key range on demand, see CompactJobTable.
+type KeyRangeCompactor interface {
+	// CompactKeyRange compacts the data in [startKey, endKey) to reclaim the space of the deleted keys.
+	CompactKeyRange(ctx context.Context, startKey, endKey kv.Key) error
+}
+
+// CompactJobTable compacts the key ranges of the job table and the reorg handle table. Deleting a large amount of
+// rows from them, e.g. by MoveJobFromTable2Queue after a large backlog is migrated, leaves the tombstones until they
+// are compacted, which slow down the dispatch queries. It's a no-op if the store doesn't implement
+// KeyRangeCompactor, so it's safe to call on any store.
+func (d *ddl) CompactJobTable() error {
+	compactor, ok := d.store.(KeyRangeCompactor)
+	if !ok {
+		logutil.BgLogger().Info("[ddl] skip compacting the job table since the store doesn't support compaction")
+		return nil
+	}
+	for _, id := range []int64{JobTableID, ReorgTableID} {
+		prefix := tablecodec.EncodeTablePrefix(id)
+		if err := compactor.CompactKeyRange(context.Background(), prefix, prefix.PrefixNext()); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	logutil.BgLogger().Info("[ddl] compact the job table")
+	return nil
+}
+
+// CheckReorgConsistency returns the sorted IDs of the jobs which have reorg handles but are not in the job table,
+// i.e. the orphaned reorg handles, which waste the storage and may confuse the worker after a restart. The owner
+// removes them when the reorg handle table grows too large, see SetReorgHandleCompactThreshold.
+func (d *ddl) CheckReorgConsistency() ([]int64, error) {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	rows, err := newSession(se).execute(context.Background(), "select distinct r.job_id from mysql.tidb_ddl_reorg r left join mysql.tidb_ddl_job j on r.job_id = j.job_id where j.job_id is null order by r.job_id", "check_reorg_consistency")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.GetInt64(0))
+	}
+	return ids, nil
+}
+
+// ReorgHandleInfo is a row of the mysql.tidb_ddl_reorg table.
+type ReorgHandleInfo struct {
+	JobID   int64
+	Element *meta.Element
+	// ElementType is the decoded type of the element, i.e. "index" or "column".
+	ElementType     string
+	StartKey        kv.Key
+	EndKey          kv.Key
+	PhysicalTableID int64
+	// RowCount is the count of the rows reorganized by the job so far, it's only set by ListReorgHandles.
+	RowCount int64
+}
+
+// reorgElementTypeName returns the name of the element type of the reorg handle.
+func reorgElementTypeName(tp []byte) string {
+	switch {
+	case bytes.Equal(tp, meta.IndexElementKey):
+		return "index"
+	case bytes.Equal(tp, meta.ColumnElementKey):
+		return "column"
+	}
+	return "unknown"
+}
+
+// getDDLReorgHandles gets all the DDL reorg handles of a job.
+func getDDLReorgHandles(sess *session, jobID int64) ([]*ReorgHandleInfo, error) {
+	sql := fmt.Sprintf("select job_id, ele_id, ele_type, start_key, end_key, physical_id from mysql.tidb_ddl_reorg where job_id = %d order by physical_id", jobID)
+	rows, err := sess.execute(context.Background(), sql, "get_handles")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	handles := make([]*ReorgHandleInfo, 0, len(rows))
+	for _, row := range rows {
+		handles = append(handles, &ReorgHandleInfo{
+			JobID:           row.GetInt64(0),
+			Element:         &meta.Element{ID: row.GetInt64(1), TypeKey: row.GetBytes(2)},
+			ElementType:     reorgElementTypeName(row.GetBytes(2)),
+			StartKey:        row.GetBytes(3),
+			EndKey:          row.GetBytes(4),
+			PhysicalTableID: row.GetInt64(5),
+		})
+	}
+	return handles, nil
+}
+
+// ListReorgHandles returns the reorg handles of all the in-flight reorg jobs ordered by the job ID and the physical
+// table ID. The progress of a handle is its start key and the row count of the job, the total count of the rows is
+// unknown until the reorg is done, so a finer estimation isn't provided. The row count is 0 if the job is finished.
+func (d *ddl) ListReorgHandles() ([]ReorgHandleInfo, error) {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	sess := newSession(se)
+	rows, err := sess.execute(context.Background(), "select job_id, start_key, end_key, physical_id, ele_id, ele_type from mysql.tidb_ddl_reorg order by job_id, physical_id", "list_handles")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	handles := make([]ReorgHandleInfo, 0, len(rows))
+	jobIDs := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		handles = append(handles, ReorgHandleInfo{
+			JobID:           row.GetInt64(0),
+			StartKey:        row.GetBytes(1),
+			EndKey:          row.GetBytes(2),
+			PhysicalTableID: row.GetInt64(3),
+			Element:         &meta.Element{ID: row.GetInt64(4), TypeKey: row.GetBytes(5)},
+			ElementType:     reorgElementTypeName(row.GetBytes(5)),
+		})
+		jobIDs = append(jobIDs, row.GetInt64(0))
+	}
+	jobs, err := getJobsByIDs(sess, jobIDs)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for i := range handles {
+		if job, ok := jobs[handles[i].JobID]; ok {
+			handles[i].RowCount = job.GetRowCount()
+		}
+	}
+	return handles, nil
+}
+
+// ValidateReorgHandles checks the reorg handles of a job are self-consistent, it's used as a safety check
+// before resuming a reorg job after manual intervention. The start key of each handle must not be greater
+// than the end key, and the physical table IDs of all handles must be distinct.
+func (d *ddl) ValidateReorgHandles(jobID int64) error {
+	se, err := d.sessPool.get()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer d.sessPool.put(se)
+	handles, err := getDDLReorgHandles(newSession(se), jobID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	physicalIDs := make(map[int64]struct{}, len(handles))
+	for _, h := range handles {
+		// An empty end key means the range is unbounded.
+		if len(h.EndKey) != 0 && h.StartKey.Cmp(h.EndKey) > 0 {
+			return errors.Errorf("invalid reorg handle of job %d: physical table %d has start key %s greater than end key %s",
+				jobID, h.PhysicalTableID, h.StartKey, h.EndKey)
+		}
+		if _, ok := physicalIDs[h.PhysicalTableID]; ok {
+			return errors.Errorf("invalid reorg handle of job %d: duplicated physical table %d", jobID, h.PhysicalTableID)
+		}
+		physicalIDs[h.PhysicalTableID] = struct{}{}
+	}
+	return nil
+}
+
 // updateDDLReorgStartHandle update the startKey of the handle.
 func updateDDLReorgStartHandle(sess *session, job *model.Job, element *meta.Element, startKey kv.Key) error {
 	sql := fmt.Sprintf("update mysql.tidb_ddl_reorg set ele_id = %d, ele_type = %s, start_key = %s where job_id = %d",
@@ -395,6 +2310,20 @@ func initDDLReorgHandle(sess *session, jobID int64, startKey kv.Key, endKey kv.K
 	return err
 }
 
+// migrateReorgHandle migrates the reorg handle of the job from the meta queue to mysql.tidb_ddl_reorg.
+// It's fine that the job has no reorg handle in the meta queue, nothing is migrated in this case.
+// The handle in the meta queue is kept, it's cleared along with the queue.
+func migrateReorgHandle(sess *session, t *meta.Meta, job *model.Job) error {
+	element, start, end, pid, err := t.GetDDLReorgHandle(job)
+	if meta.ErrDDLReorgElementNotExist.Equal(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(initDDLReorgHandle(sess, job.ID, start, end, pid, element))
+}
+
 // deleteDDLReorgHandle deletes the handle for ddl reorg.
 func removeDDLReorgHandle(sess *session, job *model.Job, elements []*meta.Element) error {
 	if len(elements) == 0 {
@@ -437,14 +2366,50 @@ func getJobsBySQL(sess *session, tbl, condition string) ([]*model.Job, error) {
 	return jobs, nil
 }
 
-// MoveJobFromQueue2Table move existing DDLs in queue to table.
-func (d *ddl) MoveJobFromQueue2Table(inBootstrap bool) error {
+// getJobsByIDsBatchSize is the max count of the IDs in the IN-list of a query issued by getJobsByIDs.
+var getJobsByIDsBatchSize = 256
+
+// getJobsByIDs gets the jobs of the IDs from the job table, the IDs are queried in batches to keep the size of
+// the SQL bounded. The missing jobs aren't in the returned map.
+func getJobsByIDs(sess *session, ids []int64) (map[int64]*model.Job, error) {
+	jobs := make(map[int64]*model.Job, len(ids))
+	for i := 0; i < len(ids); i += getJobsByIDsBatchSize {
+		batchEnd := len(ids)
+		if batchEnd > i+getJobsByIDsBatchSize {
+			batchEnd = i + getJobsByIDsBatchSize
+		}
+		idStrs := make([]string, 0, batchEnd-i)
+		for _, id := range ids[i:batchEnd] {
+			idStrs = append(idStrs, strconv.FormatInt(id, 10))
+		}
+		sql := fmt.Sprintf("select job_id, job_meta from mysql.tidb_ddl_job where job_id in (%s)", strings.Join(idStrs, ","))
+		rows, err := sess.execute(context.Background(), sql, "get_jobs_by_ids")
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, row := range rows {
+			job := &model.Job{}
+			if err := job.Decode(row.GetBytes(1)); err != nil {
+				return nil, errors.Trace(err)
+			}
+			jobs[row.GetInt64(0)] = job
+		}
+	}
+	return jobs, nil
+}
+
+// MoveJobFromQueue2Table move existing DDLs in queue to table. If transform is not nil, it's called on each job
+// before inserting it into the table, e.g. to repair the incompatible job encodings during upgrades, and the whole
+// migration is rolled back if it returns an error.
+func (d *ddl) MoveJobFromQueue2Table(inBootstrap bool, transform func(*model.Job) error) error {
 	sess, err := d.sessPool.get()
 	if err != nil {
 		return err
 	}
 	defer d.sessPool.put(sess)
-	return runInTxn(newSession(sess), func(se *session) error {
+	// The schema and table IDs of a queued job don't change, so they're computed once and reused by the retries.
+	jobsWithIDs := make(map[int64]*JobWithIDs)
+	return runInTxnWithRetry(newSession(sess), migrateJobsMaxRetries, func(se *session) error {
 		txn, err := se.txn()
 		if err != nil {
 			return errors.Trace(err)
@@ -469,7 +2434,19 @@ func (d *ddl) MoveJobFromQueue2Table(inBootstrap bool) error {
 				if inBootstrap && job.SchemaID == systemDBID {
 					continue
 				}
-				err = insertDDLJobs2Table(se, false, job)
+				if transform != nil {
+					if err = transform(job); err != nil {
+						return errors.Trace(err)
+					}
+				}
+				j, ok := jobsWithIDs[job.ID]
+				if ok {
+					j.Job = job
+				} else {
+					j = NewJobWithIDs(job)
+					jobsWithIDs[job.ID] = j
+				}
+				err = insertDDLJobs2Table(se, false, j)
 				if err != nil {
 					return errors.Trace(err)
 				}
@@ -477,19 +2454,18 @@ func (d *ddl) MoveJobFromQueue2Table(inBootstrap bool) error {
 					// General job do not have reorg info.
 					continue
 				}
-				element, start, end, pid, err := t.GetDDLReorgHandle(job)
-				if meta.ErrDDLReorgElementNotExist.Equal(err) {
-					continue
-				}
-				if err != nil {
-					return errors.Trace(err)
-				}
-				err = initDDLReorgHandle(se, job.ID, start, end, pid, element)
-				if err != nil {
+				if err = migrateReorgHandle(se, t, job); err != nil {
 					return errors.Trace(err)
 				}
 			}
 		}
+		// The table may have jobs already if the concurrent ddl is on, e.g. in bootstrap, so only the migration which
+		// turns it on is verified.
+		if !isConcurrentDDL {
+			if err = verifyMigration(se, txn, inBootstrap, systemDBID); err != nil {
+				return errors.Trace(err)
+			}
+		}
 
 		if err = t.ClearALLDDLJob(); err != nil {
 			return errors.Trace(err)
@@ -501,14 +2477,79 @@ func (d *ddl) MoveJobFromQueue2Table(inBootstrap bool) error {
 	})
 }
 
+// verifyMigration checks whether the jobs in the meta queues and the job table are the same, it's called by
+// MoveJobFromQueue2Table after the jobs are inserted and before the queues are cleared, so the migration is aborted
+// if any job is lost. The jobs of the system DB only in the queues are ignored in bootstrap, since they're skipped.
+func verifyMigration(se *session, txn kv.Transaction, inBootstrap bool, systemDBID int64) error {
+	queueJobs := make(map[int64]*model.Job)
+	for _, tp := range []workerType{addIdxWorker, generalWorker} {
+		jobs, err := newMetaWithQueueTp(txn, tp).GetAllDDLJobsInQueue()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, job := range jobs {
+			queueJobs[job.ID] = job
+		}
+	}
+	rows, err := se.execute(context.Background(), "select job_id from mysql.tidb_ddl_job order by job_id", "verify_migration")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var onlyInTable []int64
+	for _, row := range rows {
+		id := row.GetInt64(0)
+		if _, ok := queueJobs[id]; ok {
+			delete(queueJobs, id)
+			continue
+		}
+		onlyInTable = append(onlyInTable, id)
+	}
+	onlyInQueue := make([]int64, 0, len(queueJobs))
+	for id, job := range queueJobs {
+		if !inBootstrap || job.SchemaID != systemDBID {
+			onlyInQueue = append(onlyInQueue, id)
+		}
+	}
+	if len(onlyInQueue) == 0 && len(onlyInTable) == 0 {
+		return nil
+	}
+	slices.Sort(onlyInQueue)
+	return errors.Errorf("the ddl jobs in the queues and the table are inconsistent, jobs only in the queues: %v, jobs only in the table: %v",
+		onlyInQueue, onlyInTable)
+}
+
+// checkNoProcessingJobs returns an error if any job is processing, since its worker may still update the job in the
+// job table after it's moved.
+func (d *ddl) checkNoProcessingJobs(se *session) error {
+	rows, err := se.execute(context.Background(), "select job_id from mysql.tidb_ddl_job where processing limit 1", "get_processing_job_ids")
+	if err != nil || len(rows) == 0 {
+		return errors.Trace(err)
+	}
+	return dbterror.ErrMoveProcessingJob.GenWithStackByArgs(rows[0].GetInt64(0))
+}
+
+// getJobRunner returns the ID of the node whose worker is running the job, runByOthers is got by getJobsRunByOthers.
+func (d *ddl) getJobRunner(id int64, runByOthers map[int64]string) (string, bool) {
+	d.runningJobs.RLock()
+	_, ok := d.runningJobs.ids[id]
+	d.runningJobs.RUnlock()
+	if ok {
+		return d.uuid, true
+	}
+	node, ok := runByOthers[id]
+	return node, ok
+}
+
 // MoveJobFromTable2Queue move existing DDLs in table to queue.
+// The rows are deleted from the job table and the reorg handle table, call CompactJobTable to reclaim the space
+// after a large backlog is moved.
 func (d *ddl) MoveJobFromTable2Queue() error {
 	sess, err := d.sessPool.get()
 	if err != nil {
 		return err
 	}
 	defer d.sessPool.put(sess)
-	return runInTxn(newSession(sess), func(se *session) error {
+	return runInTxnWithRetry(newSession(sess), migrateJobsMaxRetries, func(se *session) error {
 		txn, err := se.txn()
 		if err != nil {
 			return errors.Trace(err)
@@ -518,6 +2559,9 @@ func (d *ddl) MoveJobFromTable2Queue() error {
 		if !isConcurrentDDL || err != nil {
 			return errors.Trace(err)
 		}
+		if err := d.checkNoProcessingJobs(se); err != nil {
+			return errors.Trace(err)
+		}
 		jobs, err := getJobsBySQL(se, "tidb_ddl_job", "1 order by job_id")
 		if err != nil {
 			return errors.Trace(err)
@@ -556,15 +2600,32 @@ func (d *ddl) MoveJobFromTable2Queue() error {
 	})
 }
 
+// migrateJobsMaxRetries is the max count to retry the transaction to migrate the jobs between the queues and the table,
+// the migration may conflict with the other writes during an upgrade.
+const migrateJobsMaxRetries = 3
+
 func runInTxn(se *session, f func(*session) error) (err error) {
-	err = se.begin()
-	if err != nil {
-		return err
-	}
-	err = f(se)
-	if err != nil {
-		se.rollback()
-		return
+	return runInTxnWithRetry(se, 0, f)
+}
+
+// runInTxnWithRetry runs f in a transaction. If the commit fails with a retryable error, e.g. a write conflict,
+// the whole f is run again in a new transaction, at most maxRetries times. The errors returned by f aren't retried.
+func runInTxnWithRetry(se *session, maxRetries int, f func(*session) error) (err error) {
+	for i := 0; ; i++ {
+		err = se.begin()
+		if err != nil {
+			return err
+		}
+		err = f(se)
+		if err != nil {
+			se.rollback()
+			return
+		}
+		err = se.commit()
+		if err == nil || i >= maxRetries || !kv.IsTxnRetryableError(err) {
+			return errors.Trace(err)
+		}
+		logutil.BgLogger().Info("[ddl] retry the transaction", zap.Int("retryCnt", i+1), zap.Error(err))
+		kv.BackOff(uint(i))
 	}
-	return errors.Trace(se.commit())
 }
@@ -18,19 +18,32 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"runtime"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
 	"github.com/pingcap/tidb/ddl"
+	"github.com/pingcap/tidb/domain"
+	"github.com/pingcap/tidb/errno"
 	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/meta"
+	"github.com/pingcap/tidb/metrics"
 	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/sessionctx"
 	"github.com/pingcap/tidb/sessionctx/variable"
+	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/testkit"
 	"github.com/pingcap/tidb/util"
+	"github.com/pingcap/tidb/util/dbterror"
+	"github.com/pingcap/tidb/util/timeutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/oracle"
 	"go.uber.org/atomic"
 	"golang.org/x/exp/slices"
 )
@@ -291,3 +304,1969 @@ func TestConcurrentDDLSwitch(t *testing.T) {
 		}
 	}
 }
+
+type flipCommentRewriter struct {
+	rewritten atomic.Int32
+}
+
+func (r *flipCommentRewriter) RewriteJobArgs(job *model.Job) (bool, error) {
+	if job.Type != model.ActionModifyTableComment {
+		return false, nil
+	}
+	var comment string
+	if err := job.DecodeArgs(&comment); err != nil {
+		return false, err
+	}
+	if comment == "rewritten" {
+		return false, nil
+	}
+	job.Args = []interface{}{"rewritten"}
+	r.rewritten.Add(1)
+	return true, nil
+}
+
+func TestJobArgsRewriter(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t (a int)")
+
+	d := dom.DDL().(interface {
+		SetJobArgsRewriter(ddl.JobArgsRewriter)
+	})
+	rewriter := &flipCommentRewriter{}
+	d.SetJobArgsRewriter(rewriter)
+	defer d.SetJobArgsRewriter(nil)
+
+	tk.MustExec("alter table t comment 'origin'")
+	require.Equal(t, int32(1), rewriter.rewritten.Load())
+	tk.MustQuery("select table_comment from information_schema.tables where table_schema = 'test' and table_name = 't'").Check(testkit.Rows("rewritten"))
+
+	// Without the rewriter, the job is executed as submitted.
+	d.SetJobArgsRewriter(nil)
+	tk.MustExec("alter table t comment 'origin'")
+	require.Equal(t, int32(1), rewriter.rewritten.Load())
+	tk.MustQuery("select table_comment from information_schema.tables where table_schema = 'test' and table_name = 't'").Check(testkit.Rows("origin"))
+}
+
+func TestDispatchLoopIdleDuration(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+
+	d := dom.DDL().(interface {
+		DispatchLoopIdleDuration() time.Duration
+	})
+	tk.MustExec("create table t (a int)")
+	idle := d.DispatchLoopIdleDuration()
+	time.Sleep(200 * time.Millisecond)
+	grown := d.DispatchLoopIdleDuration()
+	require.Greater(t, grown, idle)
+	require.GreaterOrEqual(t, grown, 200*time.Millisecond)
+
+	tk.MustExec("create table t1 (a int)")
+	require.Less(t, d.DispatchLoopIdleDuration(), grown)
+}
+
+func TestValidateReorgHandles(t *testing.T) {
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		ValidateReorgHandles(jobID int64) error
+	})
+
+	// No handles.
+	require.NoError(t, d.ValidateReorgHandles(1))
+
+	tk.MustExec("insert into mysql.tidb_ddl_reorg(job_id, ele_id, ele_type, start_key, end_key, physical_id) values (1, 1, 0x01, 0x0001, 0x0002, 100)")
+	require.NoError(t, d.ValidateReorgHandles(1))
+
+	tk.MustExec("insert into mysql.tidb_ddl_reorg(job_id, ele_id, ele_type, start_key, end_key, physical_id) values (1, 2, 0x01, 0x0005, 0x0001, 101)")
+	err := d.ValidateReorgHandles(1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "physical table 101")
+
+	tk.MustExec("delete from mysql.tidb_ddl_reorg where physical_id = 101")
+	tk.MustExec("insert into mysql.tidb_ddl_reorg(job_id, ele_id, ele_type, start_key, end_key, physical_id) values (1, 2, 0x01, 0x0001, 0x0002, 100)")
+	err = d.ValidateReorgHandles(1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "duplicated physical table 100")
+}
+
+type countingJobValidator struct {
+	cnt atomic.Int32
+}
+
+func (v *countingJobValidator) ValidateJob(job *model.Job) error {
+	if job.Type == model.ActionModifySchemaCharsetAndCollate {
+		v.cnt.Add(1)
+	}
+	return nil
+}
+
+func TestPreValidatedDDLJob(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		SetJobValidator(v ddl.JobValidator)
+		DoPreValidatedDDLJob(ctx sessionctx.Context, job *model.Job, checksum uint32) error
+	})
+	v := &countingJobValidator{}
+	d.SetJobValidator(v)
+	defer d.SetJobValidator(nil)
+
+	dbInfo, ok := dom.InfoSchema().SchemaByName(model.NewCIStr("test"))
+	require.True(t, ok)
+	newJob := func() *model.Job {
+		return &model.Job{
+			SchemaID:   dbInfo.ID,
+			SchemaName: dbInfo.Name.L,
+			Type:       model.ActionModifySchemaCharsetAndCollate,
+			BinlogInfo: &model.HistoryInfo{},
+			Args:       []interface{}{"utf8mb4", "utf8mb4_bin"},
+		}
+	}
+
+	tk.Session().SetValue(sessionctx.QueryString, "alter database test charset utf8mb4 collate utf8mb4_bin")
+
+	// The validation is skipped if the checksum matches.
+	job := newJob()
+	checksum, err := ddl.JobValidationChecksum(job)
+	require.NoError(t, err)
+	require.NoError(t, d.DoPreValidatedDDLJob(tk.Session(), job, checksum))
+	require.Equal(t, int32(0), v.cnt.Load())
+
+	// The job is validated if the checksum mismatches.
+	require.NoError(t, d.DoPreValidatedDDLJob(tk.Session(), newJob(), checksum+1))
+	require.Equal(t, int32(1), v.cnt.Load())
+
+	// The job is validated if it's not pre-validated.
+	tk.MustExec("alter database test charset utf8mb4 collate utf8mb4_general_ci")
+	require.Equal(t, int32(2), v.cnt.Load())
+}
+
+func TestReorgProgress(t *testing.T) {
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t (a int primary key clustered)")
+	for i := 1; i <= 10; i++ {
+		tk.MustExec("insert into t values (?)", i)
+	}
+	tbl, err := dom.InfoSchema().TableByName(model.NewCIStr("test"), model.NewCIStr("t"))
+	require.NoError(t, err)
+	d := dom.DDL().(interface {
+		ReorgProgress(job *model.Job) (float64, error)
+	})
+	job := &model.Job{ID: 1, TableID: tbl.Meta().ID}
+
+	_, err = d.ReorgProgress(job)
+	require.True(t, meta.ErrDDLReorgElementNotExist.Equal(err))
+
+	startKey := tablecodec.EncodeRecordKey(tbl.RecordPrefix(), kv.IntHandle(4))
+	endKey := tablecodec.EncodeRecordKey(tbl.RecordPrefix(), kv.IntHandle(10))
+	tk.MustExec(fmt.Sprintf("insert into mysql.tidb_ddl_reorg(job_id, ele_id, ele_type, start_key, end_key, physical_id) values (1, 1, 0x01, 0x%x, 0x%x, %d)",
+		[]byte(startKey), []byte(endKey), tbl.Meta().ID))
+	progress, err := d.ReorgProgress(job)
+	require.NoError(t, err)
+	require.InDelta(t, float64(3)/9*100, progress, 0.01)
+}
+
+func TestOrphanedBySchemaDrop(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	// newOrphanedJob creates a job for a table in a dropped schema.
+	newOrphanedJob := func(t *testing.T, store kv.Storage, dom *domain.Domain) *model.Job {
+		tk := testkit.NewTestKit(t, store)
+		tk.MustExec("create database orphan")
+		tk.MustExec("create table orphan.t (a int)")
+		dbInfo, ok := dom.InfoSchema().SchemaByName(model.NewCIStr("orphan"))
+		require.True(t, ok)
+		tbl, err := dom.InfoSchema().TableByName(model.NewCIStr("orphan"), model.NewCIStr("t"))
+		require.NoError(t, err)
+		job := &model.Job{
+			SchemaID:   dbInfo.ID,
+			TableID:    tbl.Meta().ID,
+			Type:       model.ActionModifyTableComment,
+			BinlogInfo: &model.HistoryInfo{},
+			Args:       []interface{}{"orphaned"},
+			Query:      "alter table orphan.t comment 'orphaned'",
+		}
+		tk.MustExec("drop database orphan")
+		ctx := kv.WithInternalSourceType(context.Background(), kv.InternalTxnDDL)
+		require.NoError(t, kv.RunInNewTxn(ctx, store, true, func(ctx context.Context, txn kv.Transaction) error {
+			job.ID, err = meta.NewMeta(txn).GenGlobalID()
+			return err
+		}))
+		return job
+	}
+
+	t.Run("report", func(t *testing.T) {
+		store, dom := testkit.CreateMockStoreAndDomain(t)
+		tk := testkit.NewTestKit(t, store)
+		d := dom.DDL().(interface {
+			DrainWorkers(timeout time.Duration) error
+			OrphanedBySchemaDrop(sctx sessionctx.Context) ([]int64, error)
+		})
+		job := newOrphanedJob(t, store, dom)
+		// Stop dispatching so that the job keeps pending.
+		require.NoError(t, d.DrainWorkers(10*time.Second))
+		ids, err := d.OrphanedBySchemaDrop(tk.Session())
+		require.NoError(t, err)
+		require.Empty(t, ids)
+
+		require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+		ids, err = d.OrphanedBySchemaDrop(tk.Session())
+		require.NoError(t, err)
+		require.Equal(t, []int64{job.ID}, ids)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		// Check the orphaned jobs on every tick, so the job is cancelled before it is dispatched.
+		ddl.SetOrphanedJobCheckInterval(0)
+		defer ddl.SetOrphanedJobCheckInterval(10 * time.Second)
+		store, dom := testkit.CreateMockStoreAndDomain(t)
+		tk := testkit.NewTestKit(t, store)
+		tk.MustExec("set global tidb_ddl_orphaned_job_policy = 'CANCEL'")
+		defer tk.MustExec("set global tidb_ddl_orphaned_job_policy = default")
+
+		job := newOrphanedJob(t, store, dom)
+		require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+		var historyJob *model.Job
+		require.Eventually(t, func() bool {
+			var err error
+			historyJob, err = ddl.GetHistoryJobByID(tk.Session(), job.ID)
+			require.NoError(t, err)
+			return historyJob != nil
+		}, 10*time.Second, 100*time.Millisecond)
+		require.True(t, historyJob.IsCancelled())
+		require.True(t, dbterror.ErrCancelledDDLJob.Equal(historyJob.Error))
+	})
+}
+
+func TestCompactReorgHandles(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	ddl.SetReorgHandleCompactCheckInterval(100 * time.Millisecond)
+	t.Cleanup(func() { ddl.SetReorgHandleCompactCheckInterval(time.Minute) })
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		SetReorgHandleCompactThreshold(threshold int64)
+	})
+	d.SetReorgHandleCompactThreshold(5)
+
+	// The orphans are kept if the row count doesn't exceed the threshold.
+	for i := 1; i <= 5; i++ {
+		tk.MustExec(fmt.Sprintf("insert into mysql.tidb_ddl_reorg(job_id, ele_id, ele_type, start_key, end_key, physical_id) values (%d, 1, 0x01, 0x01, 0x02, 100)", i))
+	}
+	time.Sleep(2 * time.Second)
+	tk.MustQuery("select count(1) from mysql.tidb_ddl_reorg").Check(testkit.Rows("5"))
+
+	tk.MustExec("insert into mysql.tidb_ddl_reorg(job_id, ele_id, ele_type, start_key, end_key, physical_id) values (6, 1, 0x01, 0x01, 0x02, 100)")
+	require.Eventually(t, func() bool {
+		return tk.MustQuery("select count(1) from mysql.tidb_ddl_reorg").Rows()[0][0] == "0"
+	}, 10*time.Second, 100*time.Millisecond)
+}
+
+func TestSetJobPriority(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t (a int)")
+	dbInfo, ok := dom.InfoSchema().SchemaByName(model.NewCIStr("test"))
+	require.True(t, ok)
+	tbl, err := dom.InfoSchema().TableByName(model.NewCIStr("test"), model.NewCIStr("t"))
+	require.NoError(t, err)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		SetJobPriority(id int64, p int) error
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+	require.True(t, dbterror.ErrDDLJobNotFound.Equal(d.SetJobPriority(1, 10)))
+
+	job := &model.Job{
+		ID:         1,
+		SchemaID:   dbInfo.ID,
+		TableID:    tbl.Meta().ID,
+		Type:       model.ActionModifyTableComment,
+		BinlogInfo: &model.HistoryInfo{},
+		Args:       []interface{}{"comment"},
+	}
+	require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	require.NoError(t, d.SetJobPriority(1, 10))
+	rows := tk.MustQuery("select job_meta from mysql.tidb_ddl_job where job_id = 1").Rows()
+	require.Len(t, rows, 1)
+	var saved model.Job
+	require.NoError(t, saved.Decode([]byte(rows[0][0].(string))))
+	require.Equal(t, 10, saved.DispatchPriority)
+	require.Equal(t, job.RawArgs, saved.RawArgs)
+}
+
+func TestReclassifyJob(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		ReclassifyJob(id int64, needsReorg bool) error
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+	require.True(t, dbterror.ErrDDLJobNotFound.Equal(d.ReclassifyJob(1, true)))
+
+	job := &model.Job{
+		ID:         1,
+		SchemaID:   1,
+		TableID:    1,
+		Type:       model.ActionModifyTableComment,
+		BinlogInfo: &model.HistoryInfo{},
+	}
+	require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	tk.MustQuery("select reorg from mysql.tidb_ddl_job where job_id = 1").Check(testkit.Rows("0"))
+	require.NoError(t, d.ReclassifyJob(1, true))
+	tk.MustQuery("select reorg from mysql.tidb_ddl_job where job_id = 1").Check(testkit.Rows("1"))
+	require.NoError(t, d.ReclassifyJob(1, false))
+	tk.MustQuery("select reorg from mysql.tidb_ddl_job where job_id = 1").Check(testkit.Rows("0"))
+
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 1 where job_id = 1")
+	require.ErrorContains(t, d.ReclassifyJob(1, true), "processing")
+	tk.MustQuery("select reorg from mysql.tidb_ddl_job where job_id = 1").Check(testkit.Rows("0"))
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+}
+
+func TestForceClearReorgHandle(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		ForceClearReorgHandle(jobID int64) error
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+	require.True(t, dbterror.ErrDDLJobNotFound.Equal(d.ForceClearReorgHandle(1)))
+
+	job := &model.Job{
+		ID:         1,
+		SchemaID:   1,
+		TableID:    1,
+		Type:       model.ActionAddIndex,
+		BinlogInfo: &model.HistoryInfo{},
+	}
+	require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	tk.MustExec("insert into mysql.tidb_ddl_reorg(job_id, ele_id, ele_type, start_key, end_key, physical_id) values (1, 1, 0x01, 0x0001, 0x0002, 100)")
+	tk.MustExec("insert into mysql.tidb_ddl_reorg(job_id, ele_id, ele_type, start_key, end_key, physical_id) values (2, 1, 0x01, 0x0001, 0x0002, 100)")
+
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 1 where job_id = 1")
+	require.ErrorContains(t, d.ForceClearReorgHandle(1), "processing")
+	tk.MustQuery("select job_id from mysql.tidb_ddl_reorg order by job_id").Check(testkit.Rows("1", "2"))
+
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 0 where job_id = 1")
+	require.NoError(t, d.ForceClearReorgHandle(1))
+	// Only the reorg handle of the given job is cleared.
+	tk.MustQuery("select job_id from mysql.tidb_ddl_reorg order by job_id").Check(testkit.Rows("2"))
+	tk.MustQuery("select count(*) from mysql.tidb_ddl_job where job_id = 1").Check(testkit.Rows("1"))
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+	tk.MustExec("delete from mysql.tidb_ddl_reorg")
+}
+
+func TestListReorgHandles(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		ListReorgHandles() ([]ddl.ReorgHandleInfo, error)
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+	handles, err := d.ListReorgHandles()
+	require.NoError(t, err)
+	require.Empty(t, handles)
+
+	job := &model.Job{
+		ID:         1,
+		SchemaID:   100,
+		TableID:    101,
+		Type:       model.ActionAddIndex,
+		BinlogInfo: &model.HistoryInfo{},
+	}
+	job.SetRowCount(42)
+	require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	tk.MustExec(fmt.Sprintf("insert into mysql.tidb_ddl_reorg(job_id, ele_id, ele_type, start_key, end_key, physical_id) values (1, 2, '%s', 'b', 'z', 103), (1, 1, '%s', 'a', 'y', 102)",
+		meta.IndexElementKey, meta.IndexElementKey))
+	// The handle of the finished job, whose row count isn't known.
+	tk.MustExec(fmt.Sprintf("insert into mysql.tidb_ddl_reorg(job_id, ele_id, ele_type, start_key, end_key, physical_id) values (2, 3, '%s', 'c', 'x', 201)", meta.ColumnElementKey))
+
+	handles, err = d.ListReorgHandles()
+	require.NoError(t, err)
+	require.Len(t, handles, 3)
+	require.Equal(t, ddl.ReorgHandleInfo{
+		JobID:           1,
+		Element:         &meta.Element{ID: 1, TypeKey: meta.IndexElementKey},
+		ElementType:     "index",
+		StartKey:        kv.Key("a"),
+		EndKey:          kv.Key("y"),
+		PhysicalTableID: 102,
+		RowCount:        42,
+	}, handles[0])
+	require.Equal(t, int64(103), handles[1].PhysicalTableID)
+	require.Equal(t, int64(2), handles[1].Element.ID)
+	require.Equal(t, kv.Key("b"), handles[1].StartKey)
+	require.Equal(t, int64(42), handles[1].RowCount)
+	require.Equal(t, ddl.ReorgHandleInfo{
+		JobID:           2,
+		Element:         &meta.Element{ID: 3, TypeKey: meta.ColumnElementKey},
+		ElementType:     "column",
+		StartKey:        kv.Key("c"),
+		EndKey:          kv.Key("x"),
+		PhysicalTableID: 201,
+	}, handles[2])
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+	tk.MustExec("delete from mysql.tidb_ddl_reorg")
+}
+
+func TestRetryJob(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		InsertRunningDDLJobMap(id int64)
+		DeleteRunningDDLJobMap(id int64)
+		RetryJob(jobID int64, resetReorgHandle bool) error
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+	require.True(t, dbterror.ErrDDLJobNotFound.Equal(d.RetryJob(1, true)))
+
+	for i, tc := range []struct {
+		tp        model.ActionType
+		state     model.JobState
+		submitted bool
+		handle    bool
+	}{
+		{model.ActionRenameTable, model.JobStateRollbackDone, false, true},
+		{model.ActionRenameTable, model.JobStateCancelled, false, false},
+		{model.ActionAddIndex, model.JobStateRollbackDone, true, true},
+		{model.ActionModifyColumn, model.JobStateRollbackDone, false, true},
+		{model.ActionRenameTable, model.JobStateDone, false, true},
+		{model.ActionRenameTable, model.JobStateRunning, false, true},
+	} {
+		job := &model.Job{
+			ID:         int64(i + 1),
+			SchemaID:   100,
+			TableID:    int64(101 + i),
+			Type:       tc.tp,
+			State:      model.JobStateQueueing,
+			Args:       []interface{}{"submitted"},
+			BinlogInfo: &model.HistoryInfo{},
+		}
+		if tc.submitted {
+			b, err := job.Encode(true)
+			require.NoError(t, err)
+			job.SubmittedJob = b
+		}
+		// The job is rolled back.
+		job.State = tc.state
+		job.SchemaState = model.StateWriteReorganization
+		job.SnapshotVer = 10
+		job.ErrorCount = 3
+		job.Error = dbterror.ErrCancelledDDLJob
+		job.Args = []interface{}{"rollback"}
+		job.SetRowCount(42)
+		require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+		if tc.handle {
+			tk.MustExec(fmt.Sprintf("insert into mysql.tidb_ddl_reorg(job_id, ele_id, ele_type, start_key, end_key, physical_id) values (%d, 1, '%s', 'a', 'z', %d)",
+				job.ID, meta.ColumnElementKey, job.TableID))
+		}
+	}
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 1")
+
+	require.ErrorContains(t, d.RetryJob(4, true), "isn't kept, please resubmit the ddl instead")
+	require.ErrorContains(t, d.RetryJob(5, true), "completed successfully")
+	require.ErrorContains(t, d.RetryJob(6, true), "only the rolled back job can be retried")
+	d.InsertRunningDDLJobMap(1)
+	require.ErrorContains(t, d.RetryJob(1, true), "is being run by the worker")
+	d.DeleteRunningDDLJobMap(1)
+	// The reorg can't be resumed from the handle, since the reorganized data is removed by the rollback.
+	require.ErrorContains(t, d.RetryJob(3, false), "its reorg handle must be reset to retry it")
+
+	require.NoError(t, d.RetryJob(1, true))
+	require.NoError(t, d.RetryJob(2, false))
+	require.NoError(t, d.RetryJob(3, true))
+	tk.MustQuery("select job_id, processing from mysql.tidb_ddl_job order by job_id").Check(testkit.Rows("1 0", "2 0", "3 0", "4 1", "5 1", "6 1"))
+	tk.MustQuery("select job_id from mysql.tidb_ddl_reorg order by job_id").Check(testkit.Rows("4", "5", "6"))
+	jobs, err := ddl.GetAllDDLJobs(tk.Session(), nil)
+	require.NoError(t, err)
+	require.Len(t, jobs, 6)
+	slices.SortFunc(jobs, func(a, b *model.Job) bool { return a.ID < b.ID })
+	for _, job := range jobs[:3] {
+		require.Equal(t, model.JobStateQueueing, job.State)
+		require.Equal(t, model.StateNone, job.SchemaState)
+		require.Nil(t, job.Error)
+		require.Zero(t, job.ErrorCount)
+		require.Zero(t, job.SnapshotVer)
+		require.Zero(t, job.GetRowCount())
+	}
+	// The args rewritten by the rollback are restored.
+	var arg string
+	require.NoError(t, jobs[2].DecodeArgs(&arg))
+	require.Equal(t, "submitted", arg)
+	require.NotEmpty(t, jobs[2].SubmittedJob)
+	require.Equal(t, model.JobStateRollbackDone, jobs[3].State)
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+	tk.MustExec("delete from mysql.tidb_ddl_reorg")
+}
+
+func TestKeepSubmittedJob(t *testing.T) {
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t (a int)")
+
+	submitted := make(map[model.ActionType][]byte)
+	var jobIDs []int64
+	hook := &ddl.TestDDLCallback{}
+	hook.OnJobRunBeforeExported = func(job *model.Job) {
+		if _, ok := submitted[job.Type]; !ok {
+			submitted[job.Type] = job.SubmittedJob
+			jobIDs = append(jobIDs, job.ID)
+		}
+	}
+	dom.DDL().SetHook(hook)
+	tk.MustExec("alter table t add index idx(a)")
+	tk.MustExec("alter table t comment 'comment'")
+
+	// Only the job whose args are rewritten by the rollback is kept.
+	job := &model.Job{}
+	require.NoError(t, job.Decode(submitted[model.ActionAddIndex]))
+	require.Equal(t, model.JobStateQueueing, job.State)
+	require.Equal(t, model.StateNone, job.SchemaState)
+	require.Nil(t, submitted[model.ActionModifyTableComment])
+	// The kept job is dropped once the job is finished.
+	for _, id := range jobIDs {
+		job, err := ddl.GetHistoryJobByID(tk.Session(), id)
+		require.NoError(t, err)
+		require.Nil(t, job.SubmittedJob)
+	}
+}
+
+func TestGetJobsByIDs(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+	jobs, err := ddl.GetJobsByIDs(tk.Session(), nil)
+	require.NoError(t, err)
+	require.Empty(t, jobs)
+
+	for i := 1; i <= 5; i++ {
+		job := &model.Job{
+			ID:         int64(i),
+			SchemaID:   100,
+			TableID:    int64(100 + i),
+			Type:       model.ActionModifyTableComment,
+			BinlogInfo: &model.HistoryInfo{},
+		}
+		require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	}
+	// Query the IDs in batches, the missing ones are skipped.
+	ddl.SetGetJobsByIDsBatchSize(2)
+	defer ddl.SetGetJobsByIDsBatchSize(256)
+	jobs, err = ddl.GetJobsByIDs(tk.Session(), []int64{5, 1, 7, 3, 9})
+	require.NoError(t, err)
+	require.Len(t, jobs, 3)
+	for _, id := range []int64{1, 3, 5} {
+		require.Equal(t, id, jobs[id].ID)
+		require.Equal(t, 100+id, jobs[id].TableID)
+	}
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+}
+
+func TestCheckReorgConsistency(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		CheckReorgConsistency() ([]int64, error)
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+	ids, err := d.CheckReorgConsistency()
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	job := &model.Job{
+		ID:         1,
+		SchemaID:   100,
+		TableID:    101,
+		Type:       model.ActionAddIndex,
+		BinlogInfo: &model.HistoryInfo{},
+	}
+	require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	tk.MustExec("insert into mysql.tidb_ddl_reorg(job_id, ele_id, ele_type, start_key, end_key, physical_id) values (1, 1, 0x01, 0x0001, 0x0002, 101)")
+	ids, err = d.CheckReorgConsistency()
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	// The job with multiple orphaned handles is reported once.
+	tk.MustExec("insert into mysql.tidb_ddl_reorg(job_id, ele_id, ele_type, start_key, end_key, physical_id) values (3, 1, 0x01, 0x0001, 0x0002, 103), (2, 1, 0x01, 0x0001, 0x0002, 102), (2, 2, 0x01, 0x0001, 0x0002, 102)")
+	ids, err = d.CheckReorgConsistency()
+	require.NoError(t, err)
+	require.Equal(t, []int64{2, 3}, ids)
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+	tk.MustExec("delete from mysql.tidb_ddl_reorg")
+}
+
+func TestJobTableStats(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		JobTableStats() (map[string]int, error)
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+	stats, err := d.JobTableStats()
+	require.NoError(t, err)
+	require.Len(t, stats, 0)
+
+	types := []model.ActionType{model.ActionCreateTable, model.ActionCreateTable, model.ActionAddIndex, model.ActionAddIndex}
+	for i, tp := range types {
+		job := &model.Job{
+			ID:         int64(i + 1),
+			SchemaID:   1,
+			TableID:    int64(i + 1),
+			Type:       tp,
+			BinlogInfo: &model.HistoryInfo{},
+		}
+		require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	}
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 1 where job_id in (1, 3)")
+	tk.MustExec("insert into mysql.tidb_ddl_job(job_id, reorg, schema_ids, table_ids, job_meta, type, processing) select 5, reorg, schema_ids, '5', job_meta, type, processing from mysql.tidb_ddl_job where job_id = 4")
+	stats, err = d.JobTableStats()
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{
+		"general/processing": 1,
+		"general/pending":    1,
+		"reorg/processing":   1,
+		"reorg/pending":      2,
+	}, stats)
+
+	// The reclassified job is counted by the reorg column rather than its type.
+	tk.MustExec("update mysql.tidb_ddl_job set reorg = 1 where job_id = 2")
+	tk.MustExec("update mysql.tidb_ddl_job set reorg = 0 where job_id = 5")
+	stats, err = d.JobTableStats()
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{
+		"general/processing": 1,
+		"general/pending":    1,
+		"reorg/processing":   1,
+		"reorg/pending":      2,
+	}, stats)
+	tk.MustExec("update mysql.tidb_ddl_job set reorg = 0 where job_id = 4")
+	stats, err = d.JobTableStats()
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{
+		"general/processing": 1,
+		"general/pending":    2,
+		"reorg/processing":   1,
+		"reorg/pending":      1,
+	}, stats)
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+}
+
+func TestMarkJobProcessingRetry(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	// The first two attempts meet write conflicts and the third one succeeds.
+	require.NoError(t, failpoint.Enable("github.com/pingcap/tidb/ddl/mockMarkJobProcessingConflict", `2*return(true)`))
+	defer func() {
+		require.NoError(t, failpoint.Disable("github.com/pingcap/tidb/ddl/mockMarkJobProcessingConflict"))
+	}()
+	tk.MustExec("create table t (a int)")
+	tk.MustExec("insert into t values (1)")
+	tk.MustQuery("select * from t").Check(testkit.Rows("1"))
+}
+
+func TestReorgJobConflictCache(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t (a int)")
+	tk.MustExec("create table t2 (a int)")
+	dbInfo, ok := dom.InfoSchema().SchemaByName(model.NewCIStr("test"))
+	require.True(t, ok)
+	tbl, err := dom.InfoSchema().TableByName(model.NewCIStr("test"), model.NewCIStr("t"))
+	require.NoError(t, err)
+	tbl2, err := dom.InfoSchema().TableByName(model.NewCIStr("test"), model.NewCIStr("t2"))
+	require.NoError(t, err)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		IsReorgJobConflicted(sctx sessionctx.Context, job *model.Job) (bool, error)
+		InvalidateProcessingJobs()
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+
+	reorgJob := &model.Job{SchemaID: dbInfo.ID, TableID: tbl.Meta().ID, Type: model.ActionAddIndex}
+	reorgJob2 := &model.Job{SchemaID: dbInfo.ID, TableID: tbl2.Meta().ID, Type: model.ActionAddIndex}
+	processing := &model.Job{
+		ID:         1,
+		SchemaID:   dbInfo.ID,
+		TableID:    tbl.Meta().ID,
+		Type:       model.ActionModifyTableComment,
+		BinlogInfo: &model.HistoryInfo{},
+		Args:       []interface{}{"comment"},
+	}
+	require.NoError(t, addDDLJobs(tk.Session(), nil, processing))
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 1 where job_id = 1")
+	d.InvalidateProcessingJobs()
+	conflicted, err := d.IsReorgJobConflicted(tk.Session(), reorgJob)
+	require.NoError(t, err)
+	require.True(t, conflicted)
+	conflicted, err = d.IsReorgJobConflicted(tk.Session(), reorgJob2)
+	require.NoError(t, err)
+	require.False(t, conflicted)
+
+	// The processing jobs are cached until they're invalidated.
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+	conflicted, err = d.IsReorgJobConflicted(tk.Session(), reorgJob)
+	require.NoError(t, err)
+	require.True(t, conflicted)
+	d.InvalidateProcessingJobs()
+	conflicted, err = d.IsReorgJobConflicted(tk.Session(), reorgJob)
+	require.NoError(t, err)
+	require.False(t, conflicted)
+
+	// A processing drop schema job conflicts with all the tables in the schema.
+	dropSchema := &model.Job{
+		ID:         2,
+		SchemaID:   dbInfo.ID,
+		Type:       model.ActionDropSchema,
+		BinlogInfo: &model.HistoryInfo{},
+	}
+	require.NoError(t, addDDLJobs(tk.Session(), nil, dropSchema))
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 1 where job_id = 2")
+	d.InvalidateProcessingJobs()
+	conflicted, err = d.IsReorgJobConflicted(tk.Session(), reorgJob2)
+	require.NoError(t, err)
+	require.True(t, conflicted)
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+}
+
+type alwaysConflictChecker struct{}
+
+func (alwaysConflictChecker) GeneralJobConflictSQL(*model.Job) string {
+	return "select 1"
+}
+
+func (alwaysConflictChecker) ReorgJobConflictSQL(*model.Job) string {
+	return "select 1"
+}
+
+func TestConflictChecker(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t (a int)")
+	tk.MustExec("create table t2 (a int)")
+	d := dom.DDL().(interface {
+		SetConflictChecker(c ddl.ConflictChecker)
+	})
+	d.SetConflictChecker(alwaysConflictChecker{})
+
+	var wg util.WaitGroupWrapper
+	wg.Run(func() {
+		tk1 := testkit.NewTestKit(t, store)
+		tk1.MustExec("alter table test.t comment 'conflict'")
+	})
+	wg.Run(func() {
+		tk1 := testkit.NewTestKit(t, store)
+		tk1.MustExec("alter table test.t2 add index idx(a)")
+	})
+	// No job is dispatched since the jobs always conflict.
+	require.Eventually(t, func() bool {
+		return tk.MustQuery("select count(1) from mysql.tidb_ddl_job").Rows()[0][0] == "2"
+	}, 10*time.Second, 100*time.Millisecond)
+	time.Sleep(2 * time.Second)
+	tk.MustQuery("select count(1) from mysql.tidb_ddl_job where processing = 1").Check(testkit.Rows("0"))
+
+	d.SetConflictChecker(nil)
+	wg.Wait()
+	tk.MustQuery("select count(1) from mysql.tidb_ddl_job").Check(testkit.Rows("0"))
+}
+
+func TestPauseAllDDL(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t (a int)")
+	d := dom.DDL().(interface {
+		PauseAllDDL() (string, error)
+		ResumeAllDDL(token string) error
+		SetMaintenanceMode(ctx context.Context, on bool) error
+	})
+	require.EqualError(t, d.ResumeAllDDL("token"), "ddl is not paused")
+	token, err := d.PauseAllDDL()
+	require.NoError(t, err)
+	_, err = d.PauseAllDDL()
+	require.EqualError(t, err, "ddl is already paused")
+
+	var wg util.WaitGroupWrapper
+	wg.Run(func() {
+		tk1 := testkit.NewTestKit(t, store)
+		tk1.MustExec("alter table test.t comment 'paused'")
+	})
+	require.Eventually(t, func() bool {
+		return tk.MustQuery("select count(1) from mysql.tidb_ddl_job").Rows()[0][0] == "1"
+	}, 10*time.Second, 100*time.Millisecond)
+	time.Sleep(2 * time.Second)
+	tk.MustQuery("select processing from mysql.tidb_ddl_job").Check(testkit.Rows("0"))
+
+	require.EqualError(t, d.ResumeAllDDL("wrong"), "the token doesn't match the one of the pause")
+	// The pause can't be cleared by turning off the maintenance mode directly.
+	require.ErrorContains(t, d.SetMaintenanceMode(context.Background(), false), "ddl is paused")
+	tk.MustQuery("select processing from mysql.tidb_ddl_job").Check(testkit.Rows("0"))
+	require.NoError(t, d.ResumeAllDDL(token))
+	wg.Wait()
+	tk.MustQuery("select table_comment from information_schema.tables where table_schema = 'test' and table_name = 't'").Check(testkit.Rows("paused"))
+}
+
+func TestJobDeadlineWithFakeClock(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t (a int)")
+	dbInfo, ok := dom.InfoSchema().SchemaByName(model.NewCIStr("test"))
+	require.True(t, ok)
+	tbl, err := dom.InfoSchema().TableByName(model.NewCIStr("test"), model.NewCIStr("t"))
+	require.NoError(t, err)
+
+	clock := timeutil.NewFakeClock(time.Now())
+	d := dom.DDL().(interface {
+		SetClock(c timeutil.Clock)
+		DoDDLJobWithTimeout(ctx sessionctx.Context, job *model.Job, timeout time.Duration) error
+	})
+	d.SetClock(clock)
+	defer d.SetClock(nil)
+
+	const timeout = time.Hour
+	hook := &ddl.TestDDLCallback{Do: dom}
+	hook.OnJobUpdatedExported = func(job *model.Job) {
+		if job.SchemaState == model.StateDeleteOnly {
+			// Exceed the deadline without sleeping, the next step cancels the job.
+			clock.Advance(2 * timeout)
+		}
+	}
+	dom.DDL().SetHook(hook)
+
+	job := buildCreateIdxJob(dbInfo, tbl.Meta(), false, "idx", "a")
+	ctx := testkit.NewTestKit(t, store).Session()
+	ctx.SetValue(sessionctx.QueryString, "skip")
+	err = d.DoDDLJobWithTimeout(ctx, job, timeout)
+	require.True(t, dbterror.ErrCancelledDDLJob.Equal(err))
+	tk.MustGetErrCode("select * from t use index(idx)", errno.ErrKeyDoesNotExist)
+}
+
+func TestNewJobWithIDs(t *testing.T) {
+	job := &model.Job{
+		Type:    model.ActionRenameTables,
+		CtxVars: []interface{}{[]int64{3, 1, 3}, []int64{12, 10, 11}},
+	}
+	j := ddl.NewJobWithIDs(job)
+	require.Equal(t, "1,3", j.SchemaIDs())
+	require.Equal(t, "10,11,12", j.TableIDs())
+	// The IDs are computed once.
+	job.CtxVars = []interface{}{[]int64{4}, []int64{13}}
+	require.Equal(t, "1,3", j.SchemaIDs())
+	require.Equal(t, "10,11,12", j.TableIDs())
+
+	j = ddl.NewJobWithIDs(&model.Job{Type: model.ActionAddIndex, SchemaID: 1, TableID: 2})
+	require.Equal(t, "1", j.SchemaIDs())
+	require.Equal(t, "2", j.TableIDs())
+}
+
+func TestNewJobWithMalformedCtxVars(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+
+	for _, ctxVars := range [][]interface{}{
+		nil,
+		{[]int64{1}},
+		{[]int64{1}, "10"},
+		{1, []int64{10}},
+	} {
+		job := &model.Job{
+			ID:         100,
+			SchemaID:   1,
+			TableID:    10,
+			Type:       model.ActionRenameTable,
+			BinlogInfo: &model.HistoryInfo{},
+			CtxVars:    ctxVars,
+		}
+		// It doesn't panic, the error is returned when the job is inserted.
+		j := ddl.NewJobWithIDs(job)
+		err := ddl.InsertDDLJobs2Table(tk.Session(), j)
+		require.ErrorContains(t, err, "malformed CtxVars of the rename table job 100")
+		tk.MustQuery("select count(*) from mysql.tidb_ddl_job where job_id = 100").Check(testkit.Rows("0"))
+	}
+
+	job := &model.Job{
+		ID:         100,
+		SchemaID:   1,
+		TableID:    10,
+		Type:       model.ActionRenameTable,
+		BinlogInfo: &model.HistoryInfo{},
+		CtxVars:    []interface{}{[]int64{1, 2}, []int64{10}},
+	}
+	require.NoError(t, ddl.InsertDDLJobs2Table(tk.Session(), ddl.NewJobWithIDs(job)))
+	tk.MustQuery("select schema_ids, table_ids from mysql.tidb_ddl_job where job_id = 100").Check(testkit.Rows("1,2 10"))
+}
+
+func TestCancelJobsForSchema(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		CancelJobsForSchema(schemaID int64) (int, error)
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+
+	for i, schemaID := range []int64{1, 1, 1, 2} {
+		job := &model.Job{
+			ID:         int64(i + 1),
+			SchemaID:   schemaID,
+			TableID:    int64(i + 1),
+			Type:       model.ActionModifyTableComment,
+			BinlogInfo: &model.HistoryInfo{},
+		}
+		require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	}
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 1 where job_id = 3")
+
+	n, err := d.CancelJobsForSchema(1)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	jobs, err := ddl.GetAllDDLJobs(tk.Session(), nil)
+	require.NoError(t, err)
+	require.Len(t, jobs, 4)
+	for _, job := range jobs {
+		require.Equal(t, job.ID <= 2, job.IsCancelling(), job.ID)
+	}
+
+	n, err = d.CancelJobsForSchema(3)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+}
+
+func TestOldestPendingJobAge(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		SetClock(c timeutil.Clock)
+		SetPendingJobAgeInterval(interval time.Duration)
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+	getAge := func() float64 {
+		m := &dto.Metric{}
+		require.NoError(t, metrics.DDLOldestPendingJobAge.Write(m))
+		return m.GetGauge().GetValue()
+	}
+
+	startTime := time.Now().Truncate(time.Millisecond)
+	clock := timeutil.NewFakeClock(startTime.Add(time.Hour))
+	d.SetClock(clock)
+	defer d.SetClock(nil)
+	d.SetPendingJobAgeInterval(10 * time.Millisecond)
+	defer d.SetPendingJobAgeInterval(30 * time.Second)
+	require.Eventually(t, func() bool {
+		return getAge() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for i, start := range []time.Time{startTime, startTime.Add(30 * time.Minute)} {
+		job := &model.Job{
+			ID:         int64(i + 1),
+			SchemaID:   1,
+			TableID:    int64(i + 1),
+			Type:       model.ActionModifyTableComment,
+			StartTS:    oracle.GoTimeToTS(start),
+			BinlogInfo: &model.HistoryInfo{},
+		}
+		require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	}
+	require.Eventually(t, func() bool {
+		return getAge() == time.Hour.Seconds()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The processing jobs are not pending.
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 1 where job_id = 1")
+	require.Eventually(t, func() bool {
+		return getAge() == (30 * time.Minute).Seconds()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The non-positive intervals disable the report.
+	for _, interval := range []time.Duration{0, -time.Second} {
+		d.SetPendingJobAgeInterval(interval)
+		require.Eventually(t, func() bool {
+			return getAge() == 0
+		}, 5*time.Second, 10*time.Millisecond)
+		d.SetPendingJobAgeInterval(10 * time.Millisecond)
+		require.Eventually(t, func() bool {
+			return getAge() == (30 * time.Minute).Seconds()
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+}
+
+func TestPendingJobGroups(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		SetPendingJobAgeInterval(interval time.Duration)
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+	getGroups := func() float64 {
+		m := &dto.Metric{}
+		require.NoError(t, metrics.DDLPendingJobGroups.Write(m))
+		return m.GetGauge().GetValue()
+	}
+	d.SetPendingJobAgeInterval(10 * time.Millisecond)
+	defer d.SetPendingJobAgeInterval(30 * time.Second)
+	defer tk.MustExec("delete from mysql.tidb_ddl_job")
+
+	// The jobs of the same table are in the same group.
+	for i, tableID := range []int64{1, 1, 2} {
+		job := &model.Job{
+			ID:         int64(i + 1),
+			SchemaID:   1,
+			TableID:    tableID,
+			Type:       model.ActionModifyTableComment,
+			BinlogInfo: &model.HistoryInfo{},
+		}
+		require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	}
+	require.Eventually(t, func() bool {
+		return getGroups() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+	require.Eventually(t, func() bool {
+		return getGroups() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSkipCorruptJobMeta(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t (a int)")
+	tk.MustExec("insert into mysql.tidb_ddl_job(job_id, reorg, schema_ids, table_ids, job_meta, type, processing) values (1, 0, '999', '999', 'corrupt', 0, 0)")
+	// The corrupt job doesn't block the other jobs.
+	tk.MustExec("alter table t comment 'comment'")
+	tk.MustExec("alter table t add index idx(a)")
+	tk.MustQuery("select job_id from mysql.tidb_ddl_job").Check(testkit.Rows("1"))
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+}
+
+func TestHasProcessingJobs(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		HasProcessingJobs(sctx sessionctx.Context) (bool, error)
+		InvalidateProcessingJobs()
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+	d.InvalidateProcessingJobs()
+	processing, err := d.HasProcessingJobs(tk.Session())
+	require.NoError(t, err)
+	require.False(t, processing)
+
+	job := &model.Job{
+		ID:         1,
+		SchemaID:   1,
+		TableID:    1,
+		Type:       model.ActionModifyTableComment,
+		BinlogInfo: &model.HistoryInfo{},
+	}
+	require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	d.InvalidateProcessingJobs()
+	processing, err = d.HasProcessingJobs(tk.Session())
+	require.NoError(t, err)
+	require.False(t, processing)
+
+	// A job is still processing between its steps, though it's not running.
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 1 where job_id = 1")
+	d.InvalidateProcessingJobs()
+	processing, err = d.HasProcessingJobs(tk.Session())
+	require.NoError(t, err)
+	require.True(t, processing)
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+}
+
+func TestMigrateReorgHandle(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+
+	tk.MustExec("begin")
+	txn, err := tk.Session().Txn(true)
+	require.NoError(t, err)
+	m := meta.NewMeta(txn, meta.AddIndexJobListKey)
+	job := &model.Job{ID: 10001}
+	missedJob := &model.Job{ID: 10002}
+	element := &meta.Element{ID: 1, TypeKey: meta.IndexElementKey}
+	require.NoError(t, m.UpdateDDLReorgHandle(job.ID, []byte("a"), []byte("z"), 100, element))
+
+	require.NoError(t, ddl.MigrateReorgHandle(tk.Session(), m, job))
+	// The job without reorg handle is skipped.
+	require.NoError(t, ddl.MigrateReorgHandle(tk.Session(), m, missedJob))
+	tk.MustQuery("select job_id, ele_id, ele_type, start_key, end_key, physical_id from mysql.tidb_ddl_reorg where job_id in (10001, 10002)").
+		Check(testkit.Rows(fmt.Sprintf("10001 1 %s a z 100", meta.IndexElementKey)))
+	tk.MustExec("rollback")
+}
+
+func TestDispatchMultipleJobsPerTick(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	// Make sure there are more than one reorg workers.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t1 (a int)")
+	tk.MustExec("create table t2 (a int)")
+	d := dom.DDL().(interface {
+		PauseAllDDL() (string, error)
+		ResumeAllDDL(token string) error
+	})
+	token, err := d.PauseAllDDL()
+	require.NoError(t, err)
+
+	var wg util.WaitGroupWrapper
+	for _, tbl := range []string{"t1", "t2"} {
+		sql := fmt.Sprintf("alter table test.%s add index idx(a)", tbl)
+		wg.Run(func() {
+			testkit.NewTestKit(t, store).MustExec(sql)
+		})
+	}
+	require.Eventually(t, func() bool {
+		return tk.MustQuery("select count(1) from mysql.tidb_ddl_job").Rows()[0][0] == "2"
+	}, 10*time.Second, 100*time.Millisecond)
+
+	// Each tick of the dispatch loop starts with getting the general jobs.
+	var mu sync.Mutex
+	ticks := 0
+	firstDispatchTicks := make(map[int64]int)
+	hook := &ddl.TestDDLCallback{}
+	hook.OnGetJobBeforeExported = func(jobType string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if jobType == "general" {
+			ticks++
+		}
+	}
+	hook.OnGetJobAfterExported = func(jobType string, job *model.Job) {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := firstDispatchTicks[job.ID]; !ok {
+			firstDispatchTicks[job.ID] = ticks
+		}
+	}
+	dom.DDL().SetHook(hook)
+	require.NoError(t, d.ResumeAllDDL(token))
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, firstDispatchTicks, 2)
+	dispatchTicks := make([]int, 0, 2)
+	for _, tick := range firstDispatchTicks {
+		dispatchTicks = append(dispatchTicks, tick)
+	}
+	// Both jobs are dispatched in the first tick after resuming.
+	require.Equal(t, dispatchTicks[0], dispatchTicks[1])
+}
+
+func TestVerifyMigration(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+
+	systemDB, ok := dom.InfoSchema().SchemaByName(model.NewCIStr("mysql"))
+	require.True(t, ok)
+	generalJob := &model.Job{ID: 10001, SchemaID: 100, TableID: 1, Type: model.ActionModifyTableComment, BinlogInfo: &model.HistoryInfo{}}
+	reorgJob := &model.Job{ID: 10002, SchemaID: 100, TableID: 2, Type: model.ActionAddIndex, BinlogInfo: &model.HistoryInfo{}}
+	internalJob := &model.Job{ID: 10003, SchemaID: systemDB.ID, TableID: 3, Type: model.ActionModifyTableComment, BinlogInfo: &model.HistoryInfo{}}
+	ctx := kv.WithInternalSourceType(context.Background(), kv.InternalTxnDDL)
+	isConcurrentDDL := func() (b bool) {
+		require.NoError(t, kv.RunInNewTxn(ctx, store, false, func(ctx context.Context, txn kv.Transaction) (err error) {
+			b, err = meta.NewMeta(txn).IsConcurrentDDL()
+			return err
+		}))
+		return b
+	}
+	require.NoError(t, kv.RunInNewTxn(ctx, store, true, func(ctx context.Context, txn kv.Transaction) error {
+		m := meta.NewMeta(txn)
+		if err := m.SetConcurrentDDL(false); err != nil {
+			return err
+		}
+		if err := m.EnQueueDDLJob(generalJob); err != nil {
+			return err
+		}
+		if err := m.EnQueueDDLJob(internalJob); err != nil {
+			return err
+		}
+		return m.EnQueueDDLJob(reorgJob, meta.AddIndexJobListKey)
+	}))
+	defer func() {
+		require.NoError(t, kv.RunInNewTxn(ctx, store, true, func(ctx context.Context, txn kv.Transaction) error {
+			m := meta.NewMeta(txn)
+			if err := m.ClearALLDDLJob(); err != nil {
+				return err
+			}
+			return m.SetConcurrentDDL(true)
+		}))
+		tk.MustExec("delete from mysql.tidb_ddl_job")
+	}()
+
+	// The migration is aborted if the table has a job not in the queues.
+	tableOnlyJob := &model.Job{ID: 10004, SchemaID: 100, TableID: 4, Type: model.ActionModifyTableComment, BinlogInfo: &model.HistoryInfo{}}
+	require.NoError(t, addDDLJobs(tk.Session(), nil, tableOnlyJob))
+	err := dom.DDL().MoveJobFromQueue2Table(false, nil)
+	require.EqualError(t, err, "the ddl jobs in the queues and the table are inconsistent, jobs only in the queues: [], jobs only in the table: [10004]")
+	tk.MustQuery("select job_id from mysql.tidb_ddl_job").Check(testkit.Rows("10004"))
+	require.False(t, isConcurrentDDL())
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+
+	// The internal job skipped in bootstrap is ignored.
+	require.NoError(t, dom.DDL().MoveJobFromQueue2Table(true, nil))
+	tk.MustQuery("select job_id from mysql.tidb_ddl_job order by job_id").Check(testkit.Rows("10001", "10002"))
+	require.True(t, isConcurrentDDL())
+	require.NoError(t, kv.RunInNewTxn(ctx, store, false, func(ctx context.Context, txn kv.Transaction) error {
+		for _, key := range []meta.JobListKeyType{meta.DefaultJobListKey, meta.AddIndexJobListKey} {
+			jobs, err := meta.NewMeta(txn).GetAllDDLJobsInQueue(key)
+			require.NoError(t, err)
+			require.Empty(t, jobs)
+		}
+		return nil
+	}))
+}
+
+func TestReorgElementTypeFilter(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t (a int)")
+	tk.MustExec("create table t1 (a int)")
+	d := dom.DDL().(interface {
+		SetReorgElementTypeFilter(tp []byte)
+	})
+	d.SetReorgElementTypeFilter(meta.ColumnElementKey)
+	defer d.SetReorgElementTypeFilter(nil)
+
+	var wg util.WaitGroupWrapper
+	wg.Run(func() {
+		testkit.NewTestKit(t, store).MustExec("alter table test.t add index idx(a)")
+	})
+	require.Eventually(t, func() bool {
+		return tk.MustQuery("select count(1) from mysql.tidb_ddl_job").Rows()[0][0] == "1"
+	}, 10*time.Second, 100*time.Millisecond)
+	// The general jobs are not filtered.
+	tk.MustExec("alter table t1 comment 'not filtered'")
+	time.Sleep(2 * time.Second)
+	tk.MustQuery("select processing from mysql.tidb_ddl_job").Check(testkit.Rows("0"))
+
+	d.SetReorgElementTypeFilter(meta.IndexElementKey)
+	wg.Wait()
+	tk.MustQuery("show index from t").CheckAt([]int{2}, testkit.Rows("idx"))
+}
+
+func TestGetJobScanLimit(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		GetGeneralJob(sctx sessionctx.Context) (*model.Job, error)
+		SetGetJobScanLimit(limit int64)
+		DeleteRunningDDLJobMap(id int64)
+		SetJobPriority(id int64, p int) error
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+	defer tk.MustExec("delete from mysql.tidb_ddl_job")
+	for i := int64(1); i <= 5; i++ {
+		job := &model.Job{ID: 10000 + i, SchemaID: 100, TableID: i, Type: model.ActionModifyTableComment, BinlogInfo: &model.HistoryInfo{}}
+		require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	}
+	require.NoError(t, d.SetJobPriority(10004, 1))
+	require.NoError(t, d.SetJobPriority(10005, -1))
+
+	job, err := d.GetGeneralJob(tk.Session())
+	require.NoError(t, err)
+	require.Equal(t, int64(10004), job.ID)
+	d.DeleteRunningDDLJobMap(job.ID)
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 0")
+
+	// The job with a higher priority beyond the limit by job ID is still picked up.
+	d.SetGetJobScanLimit(2)
+	defer d.SetGetJobScanLimit(1024)
+	job, err = d.GetGeneralJob(tk.Session())
+	require.NoError(t, err)
+	require.Equal(t, int64(10004), job.ID)
+	d.DeleteRunningDDLJobMap(job.ID)
+	tk.MustExec("delete from mysql.tidb_ddl_job where job_id = 10004")
+
+	// The jobs with the same priority are picked up in FIFO order, before the ones with lower priorities.
+	job, err = d.GetGeneralJob(tk.Session())
+	require.NoError(t, err)
+	require.Equal(t, int64(10001), job.ID)
+	d.DeleteRunningDDLJobMap(job.ID)
+	tk.MustExec("delete from mysql.tidb_ddl_job where job_id = 10001")
+
+	// The changed priority applies to the next pickup.
+	require.NoError(t, d.SetJobPriority(10003, 2))
+	job, err = d.GetGeneralJob(tk.Session())
+	require.NoError(t, err)
+	require.Equal(t, int64(10003), job.ID)
+	d.DeleteRunningDDLJobMap(job.ID)
+	tk.MustExec("delete from mysql.tidb_ddl_job where job_id in (10002, 10003)")
+	job, err = d.GetGeneralJob(tk.Session())
+	require.NoError(t, err)
+	require.Equal(t, int64(10005), job.ID)
+	d.DeleteRunningDDLJobMap(job.ID)
+}
+
+func TestMoveJobFromQueue2TableTransform(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+	defer tk.MustExec("delete from mysql.tidb_ddl_job")
+
+	ctx := kv.WithInternalSourceType(context.Background(), kv.InternalTxnDDL)
+	require.NoError(t, kv.RunInNewTxn(ctx, store, true, func(ctx context.Context, txn kv.Transaction) error {
+		m := meta.NewMeta(txn)
+		for i := int64(1); i <= 2; i++ {
+			job := &model.Job{ID: 10000 + i, SchemaID: 100, TableID: i, Type: model.ActionModifyTableComment, BinlogInfo: &model.HistoryInfo{}}
+			if err := m.EnQueueDDLJob(job); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	// The migration is rolled back if the transform fails.
+	err := dom.DDL().MoveJobFromQueue2Table(true, func(job *model.Job) error {
+		if job.ID == 10002 {
+			return errors.New("mock transform error")
+		}
+		return nil
+	})
+	require.EqualError(t, err, "mock transform error")
+	tk.MustQuery("select count(1) from mysql.tidb_ddl_job").Check(testkit.Rows("0"))
+
+	require.NoError(t, dom.DDL().MoveJobFromQueue2Table(true, func(job *model.Job) error {
+		job.Query = fmt.Sprintf("transformed %d", job.ID)
+		return nil
+	}))
+	jobs, err := ddl.GetAllDDLJobs(tk.Session(), nil)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	for _, job := range jobs {
+		require.Equal(t, fmt.Sprintf("transformed %d", job.ID), job.Query)
+	}
+	require.NoError(t, kv.RunInNewTxn(ctx, store, false, func(ctx context.Context, txn kv.Transaction) error {
+		jobs, err := meta.NewMeta(txn).GetAllDDLJobsInQueue()
+		require.Empty(t, jobs)
+		return err
+	}))
+}
+
+func TestConcurrentDDLEnabled(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	d := dom.DDL().(interface {
+		ConcurrentDDLEnabled() (bool, error)
+	})
+	enabled, err := d.ConcurrentDDLEnabled()
+	require.NoError(t, err)
+	require.True(t, enabled)
+
+	variable.EnableConcurrentDDL.Store(false)
+	defer variable.EnableConcurrentDDL.Store(true)
+	_, err = d.ConcurrentDDLEnabled()
+	require.ErrorContains(t, err, "the concurrent ddl variable is false, but the flag in the meta is true")
+
+	setConcurrentDDL := func(on bool) {
+		ctx := kv.WithInternalSourceType(context.Background(), kv.InternalTxnDDL)
+		require.NoError(t, kv.RunInNewTxn(ctx, store, true, func(ctx context.Context, txn kv.Transaction) error {
+			return meta.NewMeta(txn).SetConcurrentDDL(on)
+		}))
+	}
+	setConcurrentDDL(false)
+	defer setConcurrentDDL(true)
+	enabled, err = d.ConcurrentDDLEnabled()
+	require.NoError(t, err)
+	require.False(t, enabled)
+}
+
+func TestHasLongRunningReorgJob(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		HasLongRunningReorgJob(sctx sessionctx.Context, threshold time.Duration) (bool, error)
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+
+	job := &model.Job{
+		ID:          1,
+		SchemaID:    100,
+		TableID:     101,
+		Type:        model.ActionAddIndex,
+		BinlogInfo:  &model.HistoryInfo{},
+		RealStartTS: oracle.GoTimeToTS(time.Now().Add(-time.Hour)),
+	}
+	require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	// The pending job isn't running.
+	longRunning, err := d.HasLongRunningReorgJob(tk.Session(), 30*time.Minute)
+	require.NoError(t, err)
+	require.False(t, longRunning)
+
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 1 where job_id = 1")
+	longRunning, err = d.HasLongRunningReorgJob(tk.Session(), 30*time.Minute)
+	require.NoError(t, err)
+	require.True(t, longRunning)
+	longRunning, err = d.HasLongRunningReorgJob(tk.Session(), 2*time.Hour)
+	require.NoError(t, err)
+	require.False(t, longRunning)
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+}
+
+func TestWaitJobDone(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	d := dom.DDL().(interface {
+		PauseAllDDL() (string, error)
+		ResumeAllDDL(token string) error
+		WaitJobDone(ctx context.Context, jobID int64) error
+	})
+	require.True(t, dbterror.ErrDDLJobNotFound.Equal(d.WaitJobDone(context.Background(), 10000)))
+
+	tk.MustExec("create table t (a int)")
+	tk.MustExec("insert into t values (1), (1)")
+	lastJobID := func() int64 {
+		jobID, err := strconv.ParseInt(tk.MustQuery("admin show ddl jobs 1").Rows()[0][0].(string), 10, 64)
+		require.NoError(t, err)
+		return jobID
+	}
+	require.NoError(t, d.WaitJobDone(context.Background(), lastJobID()))
+	tk.MustGetErrCode("alter table t add unique index idx(a)", errno.ErrDupEntry)
+	err := d.WaitJobDone(context.Background(), lastJobID())
+	require.Error(t, err)
+	require.Equal(t, errno.ErrDupEntry, int(errors.Cause(err).(*terror.Error).Code()))
+
+	token, err := d.PauseAllDDL()
+	require.NoError(t, err)
+	var wg util.WaitGroupWrapper
+	wg.Run(func() {
+		tk1 := testkit.NewTestKit(t, store)
+		tk1.MustExec("alter table test.t comment 'wait'")
+	})
+	var jobID int64
+	require.Eventually(t, func() bool {
+		rows := tk.MustQuery("select job_id from mysql.tidb_ddl_job").Rows()
+		if len(rows) == 0 {
+			return false
+		}
+		jobID, err = strconv.ParseInt(rows[0][0].(string), 10, 64)
+		require.NoError(t, err)
+		return true
+	}, 10*time.Second, 100*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, d.WaitJobDone(ctx, jobID), context.DeadlineExceeded)
+
+	require.NoError(t, d.ResumeAllDDL(token))
+	require.NoError(t, d.WaitJobDone(context.Background(), jobID))
+	wg.Wait()
+}
+
+func TestJobTableHint(t *testing.T) {
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	d := dom.DDL().(interface {
+		SetJobTableHint(hint string)
+	})
+	d.SetJobTableHint("use_index(tidb_ddl_job, primary)")
+	defer d.SetJobTableHint("")
+	tk.MustExec("create table t (a int)")
+	tk.MustExec("alter table t add index idx(a)")
+	tk.MustQuery("select count(*) from mysql.tidb_ddl_job").Check(testkit.Rows("0"))
+}
+
+func TestSchemaSyncWaitHonored(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	d := dom.DDL().(interface {
+		SetSchemaSyncWait(wait time.Duration) error
+	})
+	mockSyncer, ok := dom.DDL().SchemaSyncer().(*ddl.MockSchemaSyncer)
+	require.True(t, ok)
+	// The schema is never synced, so the wait lasts until the deadline.
+	mockSyncer.SetSyncDelay(time.Hour)
+	defer mockSyncer.SetSyncDelay(0)
+
+	// The lease of the mock store is 0, so the schema sync isn't waited by default.
+	start := time.Now()
+	tk.MustExec("create table t1 (a int)")
+	require.Less(t, time.Since(start), time.Second)
+
+	wait := 500 * time.Millisecond
+	require.NoError(t, d.SetSchemaSyncWait(wait))
+	defer func() { require.NoError(t, d.SetSchemaSyncWait(0)) }()
+	start = time.Now()
+	tk.MustExec("create table t2 (a int)")
+	elapsed := time.Since(start)
+	require.GreaterOrEqual(t, elapsed, wait)
+	require.Less(t, elapsed, 10*wait)
+}
+
+func TestMoveJobFromTable2QueueWithRunningJob(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		InsertRunningDDLJobMap(id int64)
+		DeleteRunningDDLJobMap(id int64)
+		MoveJobFromTable2Queue() error
+		MoveJobFromQueue2Table(inBootstrap bool, transform func(*model.Job) error) error
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+
+	job := &model.Job{
+		ID:         1,
+		SchemaID:   100,
+		TableID:    101,
+		Type:       model.ActionAddIndex,
+		BinlogInfo: &model.HistoryInfo{},
+	}
+	require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 1 where job_id = 1")
+	d.InsertRunningDDLJobMap(job.ID)
+	err := d.MoveJobFromTable2Queue()
+	require.True(t, dbterror.ErrMoveProcessingJob.Equal(err))
+	require.ErrorContains(t, err, "drain the DDL workers")
+	tk.MustQuery("select job_id from mysql.tidb_ddl_job").Check(testkit.Rows("1"))
+
+	// The processing job between its steps can't be moved either.
+	d.DeleteRunningDDLJobMap(job.ID)
+	require.True(t, dbterror.ErrMoveProcessingJob.Equal(d.MoveJobFromTable2Queue()))
+	tk.MustQuery("select job_id from mysql.tidb_ddl_job").Check(testkit.Rows("1"))
+
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 0 where job_id = 1")
+	require.NoError(t, d.MoveJobFromTable2Queue())
+	tk.MustQuery("select count(*) from mysql.tidb_ddl_job").Check(testkit.Rows("0"))
+	require.NoError(t, d.MoveJobFromQueue2Table(false, nil))
+	tk.MustQuery("select job_id from mysql.tidb_ddl_job").Check(testkit.Rows("1"))
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+}
+
+func TestRunInTxnWithRetry(t *testing.T) {
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t (id int primary key, a int)")
+	tk.MustExec("insert into t values (1, 0)")
+	tk2 := testkit.NewTestKit(t, store)
+	tk2.MustExec("use test")
+
+	// The conflicted commit is retried in a new transaction.
+	attempts := 0
+	err := ddl.RunInTxnWithRetry(tk.Session(), 2, func() error {
+		attempts++
+		tk.MustExec("update t set a = a + 1 where id = 1")
+		if attempts == 1 {
+			tk2.MustExec("update t set a = a + 10 where id = 1")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, attempts)
+	tk.MustQuery("select a from t where id = 1").Check(testkit.Rows("11"))
+
+	// The retries are exhausted.
+	attempts = 0
+	err = ddl.RunInTxnWithRetry(tk.Session(), 1, func() error {
+		attempts++
+		tk.MustExec("update t set a = a + 1 where id = 1")
+		tk2.MustExec("update t set a = a + 10 where id = 1")
+		return nil
+	})
+	require.True(t, kv.IsTxnRetryableError(err), err)
+	require.Equal(t, 2, attempts)
+	tk.MustQuery("select a from t where id = 1").Check(testkit.Rows("31"))
+
+	// The error of f isn't retried.
+	attempts = 0
+	err = ddl.RunInTxnWithRetry(tk.Session(), 2, func() error {
+		attempts++
+		tk.MustExec("update t set a = a + 1 where id = 1")
+		return errors.New("mock error")
+	})
+	require.ErrorContains(t, err, "mock error")
+	require.Equal(t, 1, attempts)
+	tk.MustQuery("select a from t where id = 1").Check(testkit.Rows("31"))
+}
+
+func TestDispatchOnce(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		DispatchOnce(sctx sessionctx.Context)
+	})
+	// Stop the dispatch loop, so the jobs are only dispatched by DispatchOnce.
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+
+	done := make(chan struct{})
+	go func() {
+		tk1 := testkit.NewTestKit(t, store)
+		tk1.MustExec("create table test.t (a int)")
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		return tk.MustQuery("select count(*) from mysql.tidb_ddl_job").Rows()[0][0] == "1"
+	}, 10*time.Second, 10*time.Millisecond)
+	// The job is only finished by driving the dispatch rounds manually, each round runs a step of it.
+	finished := false
+	for i := 0; i < 10 && !finished; i++ {
+		d.DispatchOnce(tk.Session())
+		require.NoError(t, d.DrainWorkers(10*time.Second))
+		select {
+		case <-done:
+			finished = true
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	require.True(t, finished, "the ddl job isn't finished")
+	tk.MustQuery("select count(*) from mysql.tidb_ddl_job").Check(testkit.Rows("0"))
+	tk.MustExec("insert into test.t values (1)")
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t (a int)")
+	d := dom.DDL().(interface {
+		SetMaintenanceMode(ctx context.Context, on bool) error
+	})
+	require.NoError(t, d.SetMaintenanceMode(context.Background(), true))
+
+	var wg util.WaitGroupWrapper
+	wg.Run(func() {
+		tk1 := testkit.NewTestKit(t, store)
+		tk1.MustExec("alter table test.t comment 'maintenance'")
+	})
+	require.Eventually(t, func() bool {
+		return tk.MustQuery("select count(1) from mysql.tidb_ddl_job").Rows()[0][0] == "1"
+	}, 10*time.Second, 100*time.Millisecond)
+	time.Sleep(2 * time.Second)
+	tk.MustQuery("select processing from mysql.tidb_ddl_job").Check(testkit.Rows("0"))
+
+	require.NoError(t, d.SetMaintenanceMode(context.Background(), false))
+	wg.Wait()
+	tk.MustQuery("select table_comment from information_schema.tables where table_schema = 'test' and table_name = 't'").Check(testkit.Rows("maintenance"))
+}
+
+func TestDecodeJobMeta(t *testing.T) {
+	job := &model.Job{
+		ID:         1,
+		Type:       model.ActionRenameTable,
+		SchemaID:   2,
+		TableID:    3,
+		SchemaName: "test",
+		TableName:  "t",
+		State:      model.JobStateQueueing,
+		Args:       []interface{}{int64(2), model.NewCIStr("t1")},
+	}
+	// The job is readable before it's encoded.
+	require.Contains(t, ddl.HumanReadableJob(job), `Args:[2,{"O":"t1","L":"t1"}]`)
+
+	b, err := job.Encode(true)
+	require.NoError(t, err)
+	decoded, err := ddl.DecodeJobMeta(b)
+	require.NoError(t, err)
+	require.Equal(t, `ID:1, Type:rename table, State:queueing, SchemaState:none, SchemaID:2, TableID:3, SchemaName:test, TableName:t, Args:[2,{"O":"t1","L":"t1"}]`,
+		ddl.HumanReadableJob(decoded))
+
+	// The raw args are absent.
+	job.Args, job.RawArgs = nil, nil
+	b, err = job.Encode(false)
+	require.NoError(t, err)
+	decoded, err = ddl.DecodeJobMeta(b)
+	require.NoError(t, err)
+	require.Contains(t, ddl.HumanReadableJob(decoded), "TableName:t, Args:[]")
+
+	_, err = ddl.DecodeJobMeta([]byte("malformed"))
+	require.Error(t, err)
+}
+
+func TestDispatchLatencyFailpoint(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+
+	require.NoError(t, failpoint.Enable("github.com/pingcap/tidb/ddl/mockDispatchLatency", `return(200)`))
+	defer func() {
+		require.NoError(t, failpoint.Disable("github.com/pingcap/tidb/ddl/mockDispatchLatency"))
+	}()
+	start := time.Now()
+	tk.MustExec("create table t (a int)")
+	// The job is delivered once at least.
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	tk.MustExec("insert into t values (1)")
+}
+
+func TestJobIDsNumericOrder(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store := testkit.CreateMockStore(t)
+	tk := testkit.NewTestKit(t, store)
+
+	job := &model.Job{
+		ID:         100,
+		Type:       model.ActionRenameTables,
+		BinlogInfo: &model.HistoryInfo{},
+		CtxVars:    []interface{}{[]int64{10, 9, 10}, []int64{100, 20, 3}},
+	}
+	require.NoError(t, ddl.InsertDDLJobs2Table(tk.Session(), ddl.NewJobWithIDs(job)))
+	// The IDs are sorted numerically, and find_in_set still matches any of them.
+	tk.MustQuery("select schema_ids, table_ids from mysql.tidb_ddl_job where job_id = 100").Check(testkit.Rows("9,10 3,20,100"))
+	tk.MustQuery("select find_in_set('10', schema_ids), find_in_set('100', table_ids), find_in_set('2', table_ids) from mysql.tidb_ddl_job where job_id = 100").
+		Check(testkit.Rows("2 3 0"))
+}
+
+func TestJobResultCh(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	d := dom.DDL().(interface {
+		SetJobResultCh(ch chan<- ddl.JobResult)
+	})
+
+	ch := make(chan ddl.JobResult, 100)
+	d.SetJobResultCh(ch)
+	tk.MustExec("create table t (a int)")
+	jobID, err := strconv.ParseInt(tk.MustQuery("select max(job_id) from mysql.tidb_ddl_history").Rows()[0][0].(string), 10, 64)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		for {
+			select {
+			case res := <-ch:
+				if res.JobID == jobID {
+					require.NoError(t, res.Err)
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// The result is dropped if the channel is full, the workers aren't blocked.
+	d.SetJobResultCh(make(chan ddl.JobResult))
+	tk.MustExec("alter table t add index idx(a)")
+	d.SetJobResultCh(nil)
+	tk.MustExec("drop table t")
+}
+
+func TestResetOrphanedProcessingJobs(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		InsertRunningDDLJobMap(id int64)
+		DeleteRunningDDLJobMap(id int64)
+		ResetOrphanedProcessingJobs(sctx sessionctx.Context) error
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+
+	for i := 1; i <= 4; i++ {
+		job := &model.Job{
+			ID:         int64(i),
+			SchemaID:   100,
+			TableID:    int64(100 + i),
+			Type:       model.ActionModifyTableComment,
+			BinlogInfo: &model.HistoryInfo{},
+		}
+		// Job 2 has run a step.
+		if i == 2 {
+			job.RealStartTS = 1
+		}
+		require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	}
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 1 where job_id <= 3")
+	// Job 3 is being run by the worker.
+	d.InsertRunningDDLJobMap(3)
+	defer d.DeleteRunningDDLJobMap(3)
+
+	require.NoError(t, d.ResetOrphanedProcessingJobs(tk.Session()))
+	tk.MustQuery("select job_id, processing from mysql.tidb_ddl_job order by job_id").Check(testkit.Rows("1 0", "2 1", "3 1", "4 0"))
+	// It's idempotent.
+	require.NoError(t, d.ResetOrphanedProcessingJobs(tk.Session()))
+	tk.MustQuery("select job_id, processing from mysql.tidb_ddl_job order by job_id").Check(testkit.Rows("1 0", "2 1", "3 1", "4 0"))
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+}
+
+func TestResetOrphanedProcessingJobsPublishedLate(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	d := dom.DDL().(interface {
+		DrainWorkers(timeout time.Duration) error
+		DeleteRunningDDLJobMap(id int64)
+		ResetOrphanedProcessingJobs(sctx sessionctx.Context) error
+	})
+	require.NoError(t, d.DrainWorkers(10*time.Second))
+
+	job := &model.Job{
+		ID:         1,
+		SchemaID:   100,
+		TableID:    101,
+		Type:       model.ActionModifyTableComment,
+		BinlogInfo: &model.HistoryInfo{},
+	}
+	require.NoError(t, addDDLJobs(tk.Session(), nil, job))
+	tk.MustExec("update mysql.tidb_ddl_job set processing = 1 where job_id = 1")
+
+	// The job is published after the processing jobs are read, it's still not taken as orphaned.
+	require.NoError(t, failpoint.Enable("github.com/pingcap/tidb/ddl/mockJobPublishedLate", `return(1)`))
+	err := d.ResetOrphanedProcessingJobs(tk.Session())
+	require.NoError(t, failpoint.Disable("github.com/pingcap/tidb/ddl/mockJobPublishedLate"))
+	require.NoError(t, err)
+	tk.MustQuery("select processing from mysql.tidb_ddl_job where job_id = 1").Check(testkit.Rows("1"))
+
+	d.DeleteRunningDDLJobMap(1)
+	require.NoError(t, d.ResetOrphanedProcessingJobs(tk.Session()))
+	tk.MustQuery("select processing from mysql.tidb_ddl_job where job_id = 1").Check(testkit.Rows("0"))
+	tk.MustExec("delete from mysql.tidb_ddl_job")
+}
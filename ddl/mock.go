@@ -16,6 +16,7 @@ package ddl
 
 import (
 	"context"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -31,18 +32,28 @@ import (
 
 var _ syncer.SchemaSyncer = &MockSchemaSyncer{}
 
-const mockCheckVersInterval = 2 * time.Millisecond
+const (
+	mockCheckVersInterval = 2 * time.Millisecond
+	// defaultMockStuckTicks is the default count of ticks before OwnerCheckAllVersions fails if the syncer is stuck.
+	defaultMockStuckTicks = 10
+)
 
 // MockSchemaSyncer is a mock schema syncer, it is exported for tesing.
 type MockSchemaSyncer struct {
 	selfSchemaVersion int64
-	globalVerCh       chan clientv3.WatchResponse
-	mockSession       chan struct{}
+	// syncDelay is the minimal duration of OwnerCheckAllVersions in nanoseconds.
+	syncDelay int64
+	// stuck simulates a node that never catches up the latest version, it's 1 if stuck.
+	stuck int32
+	// stuckTicks is the count of ticks before OwnerCheckAllVersions fails if stuck.
+	stuckTicks  int64
+	globalVerCh chan clientv3.WatchResponse
+	mockSession chan struct{}
 }
 
 // NewMockSchemaSyncer creates a new mock SchemaSyncer.
 func NewMockSchemaSyncer() syncer.SchemaSyncer {
-	return &MockSchemaSyncer{}
+	return &MockSchemaSyncer{stuckTicks: defaultMockStuckTicks}
 }
 
 // Init implements SchemaSyncer.Init interface.
@@ -76,6 +87,27 @@ func (s *MockSchemaSyncer) CloseSession() {
 	close(s.mockSession)
 }
 
+// SetSyncDelay makes OwnerCheckAllVersions wait at least d before the version is considered synced,
+// it is exported for testing.
+func (s *MockSchemaSyncer) SetSyncDelay(d time.Duration) {
+	atomic.StoreInt64(&s.syncDelay, int64(d))
+}
+
+// SetStuck simulates a permanently lagging node, OwnerCheckAllVersions returns context.DeadlineExceeded
+// after the ticks set by SetStuckTicks if stuck, it is exported for testing.
+func (s *MockSchemaSyncer) SetStuck(stuck bool) {
+	var v int32
+	if stuck {
+		v = 1
+	}
+	atomic.StoreInt32(&s.stuck, v)
+}
+
+// SetStuckTicks sets the count of ticks before OwnerCheckAllVersions fails if stuck, it is exported for testing.
+func (s *MockSchemaSyncer) SetStuckTicks(ticks int) {
+	atomic.StoreInt64(&s.stuckTicks, int64(ticks))
+}
+
 // Restart implements SchemaSyncer.Restart interface.
 func (s *MockSchemaSyncer) Restart(_ context.Context) error {
 	s.mockSession = make(chan struct{}, 1)
@@ -95,6 +127,8 @@ func (s *MockSchemaSyncer) OwnerUpdateGlobalVersion(ctx context.Context, version
 func (s *MockSchemaSyncer) OwnerCheckAllVersions(ctx context.Context, latestVer int64) error {
 	ticker := time.NewTicker(mockCheckVersInterval)
 	defer ticker.Stop()
+	start := time.Now()
+	ticks := int64(0)
 
 	for {
 		select {
@@ -106,8 +140,15 @@ func (s *MockSchemaSyncer) OwnerCheckAllVersions(ctx context.Context, latestVer
 			})
 			return errors.Trace(ctx.Err())
 		case <-ticker.C:
+			if atomic.LoadInt32(&s.stuck) == 1 {
+				ticks++
+				if ticks >= atomic.LoadInt64(&s.stuckTicks) {
+					return errors.Trace(context.DeadlineExceeded)
+				}
+				continue
+			}
 			ver := atomic.LoadInt64(&s.selfSchemaVersion)
-			if ver >= latestVer {
+			if ver >= latestVer && time.Since(start) >= time.Duration(atomic.LoadInt64(&s.syncDelay)) {
 				return nil
 			}
 		}
@@ -118,6 +159,17 @@ func (s *MockSchemaSyncer) OwnerCheckAllVersions(ctx context.Context, latestVer
 func (*MockSchemaSyncer) Close() {}
 
 type mockDelRange struct {
+	mu struct {
+		sync.Mutex
+		addedJobs []int64
+		removed   []MockRemovedDelRange
+	}
+}
+
+// MockRemovedDelRange records the arguments of removeFromGCDeleteRange of mockDelRange, it is exported for testing.
+type MockRemovedDelRange struct {
+	JobID    int64
+	TableIDs []int64
 }
 
 // newMockDelRangeManager creates a mock delRangeManager only used for test.
@@ -126,15 +178,38 @@ func newMockDelRangeManager() delRangeManager {
 }
 
 // addDelRangeJob implements delRangeManager interface.
-func (*mockDelRange) addDelRangeJob(_ context.Context, _ *model.Job) error {
+func (dr *mockDelRange) addDelRangeJob(_ context.Context, job *model.Job) error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	dr.mu.addedJobs = append(dr.mu.addedJobs, job.ID)
 	return nil
 }
 
 // removeFromGCDeleteRange implements delRangeManager interface.
-func (*mockDelRange) removeFromGCDeleteRange(_ context.Context, _ int64, _ []int64) error {
+func (dr *mockDelRange) removeFromGCDeleteRange(_ context.Context, jobID int64, tableIDs []int64) error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	dr.mu.removed = append(dr.mu.removed, MockRemovedDelRange{
+		JobID:    jobID,
+		TableIDs: append([]int64(nil), tableIDs...),
+	})
 	return nil
 }
 
+// AddedJobs returns the IDs of the jobs passed to addDelRangeJob, it is exported for testing.
+func (dr *mockDelRange) AddedJobs() []int64 {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return append([]int64(nil), dr.mu.addedJobs...)
+}
+
+// RemovedFromGC returns the arguments passed to removeFromGCDeleteRange, it is exported for testing.
+func (dr *mockDelRange) RemovedFromGC() []MockRemovedDelRange {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return append([]MockRemovedDelRange(nil), dr.mu.removed...)
+}
+
 // start implements delRangeManager interface.
 func (dr *mockDelRange) start() {}
 
@@ -69,6 +69,11 @@ type reorgCtx struct {
 	// accessed by reorg-worker and daemon-worker concurrently.
 	element atomic.Value
 
+	// lastPersistTime and lastPersistRowCount record the last persistence of the start handle,
+	// they're only accessed by the DDL worker.
+	lastPersistTime     time.Time
+	lastPersistRowCount int64
+
 	mu struct {
 		sync.Mutex
 		// warnings are used to store the warnings when doing the reorg job under certain SQL modes.
@@ -263,6 +268,11 @@ func (w *worker) runReorgJob(rh *reorgHandler, reorgInfo *reorgInfo, tblInfo *mo
 		}
 	case <-w.ctx.Done():
 		logutil.BgLogger().Info("[ddl] run reorg job quit")
+		// Always persist the start handle on exit, so that the next owner doesn't re-scan the processed keys.
+		rowCount, doneKey, currentElement := rc.getRowCountAndKey()
+		if _, err := d.persistReorgStartHandle(rh, rc, job, currentElement, doneKey, rowCount, true); err != nil {
+			logutil.BgLogger().Warn("[ddl] run reorg job quit, update reorg start handle failed", zap.Error(err))
+		}
 		d.removeReorgCtx(job)
 		// We return dbterror.ErrWaitReorgTimeout here too, so that outer loop will break.
 		return dbterror.ErrWaitReorgTimeout
@@ -280,7 +290,7 @@ func (w *worker) runReorgJob(rh *reorgHandler, reorgInfo *reorgInfo, tblInfo *mo
 		// Update a reorgInfo's handle.
 		// Since daemon-worker is triggered by timer to store the info half-way.
 		// you should keep these infos is read-only (like job) / atomic (like doneKey & element) / concurrent safe.
-		err := rh.UpdateDDLReorgStartHandle(job, currentElement, doneKey)
+		persisted, err := d.persistReorgStartHandle(rh, rc, job, currentElement, doneKey, rowCount, false)
 
 		logutil.BgLogger().Info("[ddl] run reorg job wait timeout",
 			zap.Duration("waitTime", waitTimeout),
@@ -288,6 +298,7 @@ func (w *worker) runReorgJob(rh *reorgHandler, reorgInfo *reorgInfo, tblInfo *mo
 			zap.Int64("elementID", currentElement.ID),
 			zap.Int64("totalAddedRowCount", rowCount),
 			zap.String("doneKey", tryDecodeToHandleString(doneKey)),
+			zap.Bool("handlePersisted", persisted),
 			zap.Error(err))
 		// If timeout, we will return, check the owner and retry to wait job done again.
 		return dbterror.ErrWaitReorgTimeout
@@ -295,6 +306,38 @@ func (w *worker) runReorgJob(rh *reorgHandler, reorgInfo *reorgInfo, tblInfo *mo
 	return nil
 }
 
+// SetReorgCheckpointThresholds sets the thresholds to persist the start handle of the reorg jobs.
+// The start handle is persisted only if the interval elapsed or the row count processed since the last
+// persistence is reached. A zero interval persists it every time, a zero row count disables that threshold.
+func (d *ddl) SetReorgCheckpointThresholds(interval time.Duration, rowCount int64) {
+	d.reorgCheckpointInterval.Store(interval)
+	d.reorgCheckpointRowCount.Store(rowCount)
+}
+
+// needPersistStartHandle checks whether the start handle should be persisted by the thresholds.
+func (rc *reorgCtx) needPersistStartHandle(now time.Time, interval time.Duration, rowThreshold, rowCount int64) bool {
+	if now.Sub(rc.lastPersistTime) >= interval {
+		return true
+	}
+	return rowThreshold > 0 && rowCount-rc.lastPersistRowCount >= rowThreshold
+}
+
+// persistReorgStartHandle persists the start handle if the checkpoint thresholds are reached or force is true.
+// It returns whether the start handle is persisted.
+func (dc *ddlCtx) persistReorgStartHandle(rh *reorgHandler, rc *reorgCtx, job *model.Job, element *meta.Element,
+	doneKey kv.Key, rowCount int64, force bool) (bool, error) {
+	now := dc.now()
+	if !force && !rc.needPersistStartHandle(now, dc.reorgCheckpointInterval.Load(), dc.reorgCheckpointRowCount.Load(), rowCount) {
+		return false, nil
+	}
+	if err := rh.UpdateDDLReorgStartHandle(job, element, doneKey); err != nil {
+		return false, errors.Trace(err)
+	}
+	rc.lastPersistTime = now
+	rc.lastPersistRowCount = rowCount
+	return true, nil
+}
+
 func (w *worker) mergeWarningsIntoJob(job *model.Job) {
 	rc := w.getReorgCtx(job)
 	rc.mu.Lock()
@@ -367,7 +410,8 @@ func (dc *ddlCtx) isReorgRunnable(job *model.Job) error {
 		logutil.BgLogger().Info("[ddl] DDL is not the DDL owner", zap.String("ID", dc.uuid))
 		return errors.Trace(dbterror.ErrNotOwner)
 	}
-	return nil
+	// The job may be run by the owner again once the running jobs registry is lost.
+	return errors.Trace(dc.checkRunningJobsRegistry(job.ID))
 }
 
 type reorgInfo struct {
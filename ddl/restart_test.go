@@ -17,16 +17,20 @@ package ddl_test
 import (
 	"context"
 	"errors"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ngaut/pools"
 	"github.com/pingcap/tidb/ddl"
 	"github.com/pingcap/tidb/domain"
+	"github.com/pingcap/tidb/errno"
 	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/sessionctx/variable"
 	"github.com/pingcap/tidb/testkit"
+	"github.com/pingcap/tidb/util/dbterror"
 	"github.com/stretchr/testify/require"
 )
 
@@ -199,3 +203,58 @@ func TestTableResume(t *testing.T) {
 	testRunInterruptedJob(t, store, dom, job)
 	testCheckTableState(t, store, dbInfo, tblInfo, model.StateNone)
 }
+
+func TestJobDeadlineAcrossOwnerChange(t *testing.T) {
+	if !variable.EnableConcurrentDDL.Load() {
+		t.Skipf("test requires concurrent ddl")
+	}
+	store, dom := testkit.CreateMockStoreAndDomain(t)
+	tk := testkit.NewTestKit(t, store)
+	tk.MustExec("use test")
+	tk.MustExec("create table t (a int)")
+	dbInfo, ok := dom.InfoSchema().SchemaByName(model.NewCIStr("test"))
+	require.True(t, ok)
+	tbl, err := dom.InfoSchema().TableByName(model.NewCIStr("test"), model.NewCIStr("t"))
+	require.NoError(t, err)
+
+	const timeout = time.Second
+	job := buildCreateIdxJob(dbInfo, tbl.Meta(), false, "idx", "a")
+	var once sync.Once
+	halfway := make(chan struct{})
+	hook := &ddl.TestDDLCallback{Do: dom}
+	hook.OnJobUpdatedExported = func(job *model.Job) {
+		if job.SchemaState != model.StateDeleteOnly {
+			return
+		}
+		once.Do(func() {
+			close(halfway)
+			// Hold the old owner until the deadline is exceeded, the next step is run by the new owner.
+			time.Sleep(timeout)
+		})
+	}
+	dom.DDL().SetHook(hook)
+
+	done := make(chan error, 1)
+	go func() {
+		ctx := testkit.NewTestKit(t, store).Session()
+		ctx.SetValue(sessionctx.QueryString, "skip")
+		done <- dom.DDL().(interface {
+			DoDDLJobWithTimeout(ctx sessionctx.Context, job *model.Job, timeout time.Duration) error
+		}).DoDDLJobWithTimeout(ctx, job, timeout)
+	}()
+	<-halfway
+	restartWorkers(t, store, dom)
+	// The old DDL is stopped before the job is finished.
+	require.ErrorIs(t, <-done, context.Canceled)
+
+	var historyJob *model.Job
+	require.Eventually(t, func() bool {
+		historyJob, err = ddl.GetHistoryJobByID(tk.Session(), job.ID)
+		require.NoError(t, err)
+		return historyJob != nil
+	}, 30*time.Second, 100*time.Millisecond)
+	require.True(t, historyJob.IsRollbackDone())
+	require.True(t, dbterror.ErrCancelledDDLJob.Equal(historyJob.Error))
+	require.Equal(t, timeout, historyJob.Timeout)
+	tk.MustGetErrCode("select * from t use index(idx)", errno.ErrKeyDoesNotExist)
+}
@@ -530,7 +530,7 @@ func (d Checker) DoDDLJob(ctx sessionctx.Context, job *model.Job) error {
 }
 
 // MoveJobFromQueue2Table implements the DDL interface.
-func (d Checker) MoveJobFromQueue2Table(bool) error {
+func (d Checker) MoveJobFromQueue2Table(bool, func(*model.Job) error) error {
 	panic("implement me")
 }
 
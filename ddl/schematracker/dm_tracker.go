@@ -1194,7 +1194,7 @@ func (SchemaTracker) DoDDLJob(ctx sessionctx.Context, job *model.Job) error {
 }
 
 // MoveJobFromQueue2Table implements the DDL interface, it's no-op in DM's case.
-func (SchemaTracker) MoveJobFromQueue2Table(b bool) error {
+func (SchemaTracker) MoveJobFromQueue2Table(bool, func(*model.Job) error) error {
 	panic("implement me")
 }
 
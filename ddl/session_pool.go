@@ -83,6 +83,38 @@ func (sg *sessionPool) put(ctx sessionctx.Context) {
 	sg.resPool.Put(ctx.(pools.Resource))
 }
 
+// warm creates at most n sessions in advance and returns the count of the warmed sessions. Only the idle sessions
+// are taken, so it never waits for the sessions in use and never exceeds the capacity of the pool.
+func (sg *sessionPool) warm(n int) (int, error) {
+	if sg.resPool == nil {
+		return 0, nil
+	}
+	sg.mu.Lock()
+	if sg.mu.closed {
+		sg.mu.Unlock()
+		return 0, errors.Errorf("sessionPool is closed")
+	}
+	sg.mu.Unlock()
+
+	resources := make([]pools.Resource, 0, n)
+	defer func() {
+		for _, res := range resources {
+			sg.resPool.Put(res)
+		}
+	}()
+	for len(resources) < n {
+		res, err := sg.resPool.TryGet()
+		if err != nil {
+			return len(resources), errors.Trace(err)
+		}
+		if res == nil {
+			break
+		}
+		resources = append(resources, res)
+	}
+	return len(resources), nil
+}
+
 // close clean up the sessionPool.
 func (sg *sessionPool) close() {
 	sg.mu.Lock()
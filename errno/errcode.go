@@ -1027,6 +1027,8 @@ const (
 	ErrSettingNoopVariable                 = 8144
 	ErrGettingNoopVariable                 = 8145
 	ErrCannotMigrateSession                = 8146
+	ErrStmtTableRowLimitExceeded           = 8147
+	ErrBinlogTxnSizeLimitExceeded          = 8148
 
 	// Error codes used by TiDB ddl package
 	ErrUnsupportedDDLOperation            = 8200
@@ -1075,6 +1077,8 @@ const (
 	ErrPartitionColumnStatsMissing        = 8244
 	ErrColumnInChange                     = 8245
 	ErrDDLSetting                         = 8246
+	ErrCorruptJobMeta                     = 8247
+	ErrMoveProcessingJob                  = 8248
 
 	// TiKV/PD/TiFlash errors.
 	ErrPDServerTimeout           = 9001
@@ -1022,6 +1022,8 @@ var MySQLErrName = map[uint16]*mysql.ErrMessage{
 	ErrSettingNoopVariable:           mysql.Message("setting %s has no effect in TiDB", nil),
 	ErrGettingNoopVariable:           mysql.Message("variable %s has no effect in TiDB", nil),
 	ErrCannotMigrateSession:          mysql.Message("cannot migrate the current session: %s", nil),
+	ErrStmtTableRowLimitExceeded:     mysql.Message("the statement mutates %d rows of table %d, which exceeds tidb_stmt_table_row_limit %d", nil),
+	ErrBinlogTxnSizeLimitExceeded:    mysql.Message("the binlog of the transaction is %d bytes, which exceeds tidb_binlog_txn_size_limit %d", nil),
 
 	ErrWarnOptimizerHintInvalidInteger:  mysql.Message("integer value is out of range in '%s'", nil),
 	ErrWarnOptimizerHintUnsupportedHint: mysql.Message("Optimizer hint %s is not supported by TiDB and is ignored", nil),
@@ -1068,6 +1070,8 @@ var MySQLErrName = map[uint16]*mysql.ErrMessage{
 	ErrPartitionStatsMissing:       mysql.Message("Build table: %s global-level stats failed due to missing partition-level stats", nil),
 	ErrPartitionColumnStatsMissing: mysql.Message("Build table: %s global-level stats failed due to missing partition-level column stats, please run analyze table to refresh columns of all partitions", nil),
 	ErrDDLSetting:                  mysql.Message("Error happened when enable/disable DDL: %s", nil),
+	ErrCorruptJobMeta:              mysql.Message("The meta of DDL job %d is corrupt: %s", nil),
+	ErrMoveProcessingJob:           mysql.Message("DDL job %d is processing, please drain the DDL workers and wait for the processing jobs to finish before moving the jobs to the queue", nil),
 	ErrNotSupportedWithSem:         mysql.Message("Feature '%s' is not supported when security enhanced mode is enabled", nil),
 
 	ErrPlacementPolicyCheck:            mysql.Message("Placement policy didn't meet the constraint, reason: %s", nil),
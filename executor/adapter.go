@@ -711,6 +711,9 @@ func (a *ExecStmt) handleNoDelayExecutor(ctx context.Context, e Executor) (sqlex
 		if lowResolutionTSO {
 			return nil, errors.New("can not execute write statement when 'tidb_low_resolution_tso' is set")
 		}
+		if sctx.GetSessionVars().TxnStaleness > 0 {
+			return nil, errors.New("can not execute write statement when the staleness of the start TS is set")
+		}
 	}
 
 	err = a.next(ctx, e, newFirstChunk(e))
@@ -91,6 +91,9 @@ const (
 	RequestSourceType
 	// ReplicaReadAdjuster set the adjust function of cop requsts.
 	ReplicaReadAdjuster
+	// RequestTag is the tag attached by the application to the kv requests of the transaction and its snapshot. It's
+	// appended to the ResourceGroupTag, so it doesn't override the tag set for Top SQL.
+	RequestTag
 )
 
 // ReplicaReadType is the type of replica to read data from
@@ -138,6 +138,22 @@ var (
 			Name:      "running_job_count",
 			Help:      "Running DDL jobs count",
 		}, []string{LblType})
+
+	DDLOldestPendingJobAge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "ddl",
+			Name:      "oldest_pending_job_age_seconds",
+			Help:      "Age (s) of the oldest pending DDL job, it's 0 if there is no pending job",
+		})
+
+	DDLPendingJobGroups = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "tidb",
+			Subsystem: "ddl",
+			Name:      "pending_job_groups",
+			Help:      "Number of the distinct schema and table groups of the DDL jobs, which bounds the jobs can be run in parallel",
+		})
 )
 
 // Label constants.
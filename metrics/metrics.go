@@ -102,6 +102,8 @@ func RegisterMetrics() {
 	prometheus.MustRegister(DDLWorkerHistogram)
 	prometheus.MustRegister(DDLJobTableDuration)
 	prometheus.MustRegister(DDLRunningJobCount)
+	prometheus.MustRegister(DDLOldestPendingJobAge)
+	prometheus.MustRegister(DDLPendingJobGroups)
 	prometheus.MustRegister(DeploySyncerHistogram)
 	prometheus.MustRegister(DistSQLPartialCountHistogram)
 	prometheus.MustRegister(DistSQLCoprCacheCounter)
@@ -191,6 +193,8 @@ func RegisterMetrics() {
 	prometheus.MustRegister(ReadFromTableCacheCounter)
 	prometheus.MustRegister(LoadTableCacheDurationHistogram)
 	prometheus.MustRegister(NonTransactionalDeleteCount)
+	prometheus.MustRegister(TxnLargeWriteSetWarningCounter)
+	prometheus.MustRegister(StmtBufferOutcomeCounter)
 	prometheus.MustRegister(MemoryUsage)
 	prometheus.MustRegister(StatsCacheLRUCounter)
 	prometheus.MustRegister(StatsCacheLRUGauge)
@@ -227,6 +231,10 @@ func ToggleSimplifiedMode(simplified bool) {
 		TiFlashQueryTotalCounter,
 		CampaignOwnerCounter,
 		NonTransactionalDeleteCount,
+		TxnLargeWriteSetWarningCounter,
+		StmtBufferOutcomeCounter,
+		DDLOldestPendingJobAge,
+		DDLPendingJobGroups,
 		MemoryUsage,
 		TokenGauge,
 		tikvmetrics.TiKVRawkvSizeHistogram,
@@ -135,6 +135,20 @@ var (
 			Name:      "non_transactional_delete_count",
 			Help:      "Counter of non-transactional delete",
 		})
+	TxnLargeWriteSetWarningCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "session",
+			Name:      "txn_large_write_set_warning_total",
+			Help:      "Counter of the transactions whose write set exceeds the warning threshold",
+		})
+	StmtBufferOutcomeCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "tidb",
+			Subsystem: "session",
+			Name:      "stmt_buffer_outcome_total",
+			Help:      "Counter of the statement staging buffers which are flushed or cleaned up",
+		}, []string{LblResult})
 	TxnStatusEnteringCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "tidb",
@@ -408,6 +408,21 @@ type Job struct {
 
 	// SeqNum is the total order in all DDLs, it's used to identify the order of DDL.
 	SeqNum uint64 `json:"seq_num"`
+
+	// DispatchPriority is the priority to dispatch the job, the job with higher priority is dispatched first.
+	DispatchPriority int `json:"dispatch_priority,omitempty"`
+
+	// ValidationChecksum is the checksum of the validation inputs if the job is validated by the submitter.
+	// It's 0 if the job isn't pre-validated.
+	ValidationChecksum uint32 `json:"validation_checksum,omitempty"`
+
+	// Timeout is the max duration the job can run since it's submitted, it's 0 if there is no limit.
+	// The job is cancelled if it's not finished before the deadline.
+	Timeout time.Duration `json:"timeout,omitempty"`
+
+	// SubmittedJob is the encoded job as it's submitted, it's kept for the job whose args are rewritten by the
+	// rollback, so the job can be retried after it's rolled back. It's nil for the other jobs.
+	SubmittedJob json.RawMessage `json:"submitted_job,omitempty"`
 }
 
 // FinishTableJob is called when a job is finished.
@@ -721,6 +736,15 @@ func (job *Job) IsQueueing() bool {
 	return job.State == JobStateQueueing
 }
 
+// Deadline returns the deadline of the job, it's calculated from the submit time so that it doesn't
+// change when the DDL owner changes. The second return value is false if the job has no timeout.
+func (job *Job) Deadline() (time.Time, bool) {
+	if job.Timeout <= 0 {
+		return time.Time{}, false
+	}
+	return TSConvert2Time(job.StartTS).Add(job.Timeout), true
+}
+
 // NotStarted returns true if the job is never run by a worker.
 func (job *Job) NotStarted() bool {
 	return job.State == JobStateNone || job.State == JobStateQueueing
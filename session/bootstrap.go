@@ -821,7 +821,7 @@ func upgrade(s Session) {
 
 	if err == nil && ver <= version92 {
 		logutil.BgLogger().Info("start migrate DDLs")
-		err = domain.GetDomain(s).DDL().MoveJobFromQueue2Table(true)
+		err = domain.GetDomain(s).DDL().MoveJobFromQueue2Table(true, nil)
 	}
 
 	if err != nil {
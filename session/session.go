@@ -274,6 +274,9 @@ type session struct {
 
 	// Contains a list of sessions used to collect advisory locks.
 	advisoryLocks map[string]*advisoryLock
+
+	// tsFutureHook substitutes the TSO future of the new transactions if it's not nil, see SetTSFutureHook.
+	tsFutureHook func(future oracle.Future, scope string) oracle.Future
 }
 
 var parserPool = &sync.Pool{New: func() interface{} { return parser.New() }}
@@ -566,6 +569,17 @@ func (s *session) TxnInfo() *txninfo.TxnInfo {
 	return &txnInfo
 }
 
+// ListSavepoints returns the names of the savepoints of the current transaction in the creation order,
+// the savepoints can be rolled back to by ROLLBACK TO SAVEPOINT.
+func (s *session) ListSavepoints() []string {
+	savepoints := s.sessionVars.TxnCtx.Savepoints
+	names := make([]string, 0, len(savepoints))
+	for _, sp := range savepoints {
+		names = append(names, sp.Name)
+	}
+	return names
+}
+
 func (s *session) doCommit(ctx context.Context) error {
 	if !s.txn.Valid() {
 		return nil
@@ -1167,7 +1181,9 @@ func (s *session) retry(ctx context.Context, maxCnt uint) (err error) {
 				s.StmtRollback()
 				break
 			}
-			s.StmtCommit()
+			if err = s.StmtCommit(); err != nil {
+				break
+			}
 		}
 		logutil.Logger(ctx).Warn("transaction association",
 			zap.Uint64("retrying txnStartTS", s.GetSessionVars().TxnCtx.StartTS),
@@ -2003,6 +2019,9 @@ func (s *session) ExecuteStmt(ctx context.Context, stmtNode ast.StmtNode) (sqlex
 	// Uncorrelated subqueries will execute once when building plan, so we reset process info before building plan.
 	cmd32 := atomic.LoadUint32(&s.GetSessionVars().CommandValue)
 	s.SetProcessInfo(stmtNode.Text(), time.Now(), byte(cmd32), 0)
+	s.txn.SetLongTxnLogThreshold(s.sessionVars.LongTxnLogThreshold)
+	s.txn.SetMemBufferThreshold(s.sessionVars.TxnMemBufferThreshold)
+	s.txn.SetStmtBufferFlushThreshold(s.sessionVars.StmtBufferFlushThreshold)
 	s.txn.onStmtStart(digest.String())
 	defer s.txn.onStmtEnd()
 
@@ -2462,6 +2481,9 @@ func (s *session) executePlanCacheStmt(ctx context.Context, stmt *plannercore.Pl
 		}
 	}
 
+	s.txn.SetLongTxnLogThreshold(s.sessionVars.LongTxnLogThreshold)
+	s.txn.SetMemBufferThreshold(s.sessionVars.TxnMemBufferThreshold)
+	s.txn.SetStmtBufferFlushThreshold(s.sessionVars.StmtBufferFlushThreshold)
 	s.txn.onStmtStart(stmt.SQLDigest.String())
 	defer s.txn.onStmtEnd()
 
@@ -3125,14 +3147,29 @@ func (s *session) PrepareTSFuture(ctx context.Context, future oracle.Future, sco
 		future = txnFailFuture{}
 	})
 
-	s.txn.changeToPending(&txnFuture{
-		future:   future,
-		store:    s.store,
-		txnScope: scope,
-	})
+	if s.tsFutureHook != nil {
+		future = s.tsFutureHook(future, scope)
+	}
+	s.txn.changeToPending(newTxnFuture(future, s.store, scope, withStaleness(s.sessionVars.TxnStaleness)))
 	return nil
 }
 
+// SetTxnStaleness sets the bounded staleness of the start TS of the new transactions of the session. If it's non-zero,
+// the start TS is at least staleness behind the current time, and it's computed from the low resolution timestamp
+// instead of requesting a fresh TSO, which reduces the load of PD. The fresh TSO is only requested if the stale start
+// TS can't be computed. The transactions can't see the data committed within the staleness, so it's only for the
+// read-only sessions, e.g. the analytics ones, and the write statements are refused. 0 disables it, which is the
+// default.
+func (s *session) SetTxnStaleness(staleness time.Duration) {
+	s.sessionVars.TxnStaleness = staleness
+}
+
+// SetTSFutureHook sets a hook to substitute the TSO future of the new transactions, e.g. to return a scripted
+// sequence of start timestamps in tests. A nil hook restores the default behavior.
+func (s *session) SetTSFutureHook(hook func(future oracle.Future, scope string) oracle.Future) {
+	s.tsFutureHook = hook
+}
+
 // GetPreparedTxnFuture returns the TxnFuture if it is valid or pending.
 // It returns nil otherwise.
 func (s *session) GetPreparedTxnFuture() sessionctx.TxnFuture {
@@ -232,19 +232,19 @@ func recordAbortTxnDuration(sessVars *variable.SessionVars) {
 func finishStmt(ctx context.Context, se *session, meetsErr error, sql sqlexec.Statement) error {
 	sessVars := se.sessionVars
 	if !sql.IsReadOnly(sessVars) {
-		// All the history should be added here.
-		if meetsErr == nil && sessVars.TxnCtx.CouldRetry {
-			GetHistory(se).Add(sql, sessVars.StmtCtx)
-		}
-
 		// Handle the stmt commit/rollback.
 		if se.txn.Valid() {
 			if meetsErr != nil {
 				se.StmtRollback()
 			} else {
-				se.StmtCommit()
+				meetsErr = se.StmtCommit()
 			}
 		}
+
+		// All the history should be added here.
+		if meetsErr == nil && sessVars.TxnCtx.CouldRetry {
+			GetHistory(se).Add(sql, sessVars.StmtCtx)
+		}
 	}
 	err := autoCommitAfterStmt(ctx, se, meetsErr, sql)
 	if se.txn.pending() {
@@ -381,5 +381,8 @@ func ResultSetToStringSlice(ctx context.Context, s Session, rs sqlexec.RecordSet
 
 // Session errors.
 var (
-	ErrForUpdateCantRetry = dbterror.ClassSession.NewStd(errno.ErrForUpdateCantRetry)
+	ErrForUpdateCantRetry         = dbterror.ClassSession.NewStd(errno.ErrForUpdateCantRetry)
+	ErrStmtTableRowLimitExceeded  = dbterror.ClassSession.NewStd(errno.ErrStmtTableRowLimitExceeded)
+	ErrWriteInReadOnlySession     = dbterror.ClassSession.NewStd(errno.ErrCantExecuteInReadOnlyTransaction)
+	ErrBinlogTxnSizeLimitExceeded = dbterror.ClassSession.NewStd(errno.ErrBinlogTxnSizeLimitExceeded)
 )
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"math"
 	"runtime/trace"
 	"strings"
 	"sync"
@@ -28,14 +29,17 @@ import (
 	"github.com/pingcap/failpoint"
 	"github.com/pingcap/tidb/config"
 	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/metrics"
 	"github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tidb/parser/terror"
 	"github.com/pingcap/tidb/session/txninfo"
 	"github.com/pingcap/tidb/sessionctx"
 	"github.com/pingcap/tidb/sessionctx/binloginfo"
+	"github.com/pingcap/tidb/sessionctx/variable"
 	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/util/logutil"
 	"github.com/pingcap/tidb/util/sli"
+	"github.com/pingcap/tidb/util/timeutil"
 	"github.com/pingcap/tipb/go-binlog"
 	"github.com/tikv/client-go/v2/oracle"
 	"github.com/tikv/client-go/v2/tikv"
@@ -56,8 +60,54 @@ type LazyTxn struct {
 
 	initCnt       int
 	stagingHandle kv.StagingHandle
-	mutations     map[int64]*binlog.TableMutation
-	writeSLI      sli.TxnWriteThroughputSLI
+	// stmtBuf tracks the flushes of the statement buffer in the middle of the statement, see flushStmtBufIfNeeded.
+	stmtBuf struct {
+		// flushThreshold is the number of the entries staged since the last flush to flush the statement buffer,
+		// 0 means disabled.
+		flushThreshold int64
+		// startCheckpoint is the checkpoint of the mem buffer at the start of the statement, the statement is rolled
+		// back to it once any change is flushed.
+		startCheckpoint *tikv.MemDBCheckpoint
+		// stageCnt is the length of the mem buffer when the current staging buffer starts.
+		stageCnt int
+		flushed  bool
+		// flushedRows and flushedLockKeys are the count of the rows per table and the keys need to be locked of the
+		// flushed changes, they're counted by checkStmtTableRowLimit and returned by KeysNeedToLock.
+		flushedRows     map[int64]int64
+		flushedLockKeys []kv.Key
+	}
+	mutations map[int64]*binlog.TableMutation
+	writeSLI  sli.TxnWriteThroughputSLI
+	// largeWriteSetWarned indicates whether the large write set warning has been emitted for the transaction.
+	largeWriteSetWarned bool
+	// clock is the source of the time recorded in TxnInfo, nowFunc is used unless it's set by SetClock.
+	clock timeutil.Clock
+	// keepLatestSQLDigests indicates whether TxnInfo.AllSQLDigests keeps the latest digests instead of the first ones
+	// once it's full.
+	keepLatestSQLDigests bool
+	// trackLockDigests indicates whether TxnInfo.LockedKeysByDigest is recorded by LockKeys.
+	trackLockDigests bool
+	// readOnly indicates whether the statements are rejected once they stage any write, see SetReadOnly.
+	readOnly bool
+	// longTxnLogThreshold is the duration of the transaction to log its statement digests when it ends, 0 means disabled.
+	longTxnLogThreshold time.Duration
+	// stateDurations accumulates the durations the transaction spends in each state, it's reset when the transaction ends.
+	stateDurations [txninfo.TxnStateCounter]time.Duration
+	// binlogDisabled indicates whether the binlog mutations are not accumulated, see SetBinlogMutationDisabled.
+	binlogDisabled bool
+	// binlogSize is the estimated size of the binlog mutations merged into the transaction by the committed statements.
+	binlogSize int64
+	// memBufferThreshold is the size of the mem buffer to emit a warning when it's crossed, 0 means disabled.
+	// memBufferThresholdCrossed indicates whether the size is above the threshold since the last crossing.
+	memBufferThreshold        int64
+	memBufferThresholdCrossed bool
+	// memBufferThresholdHook is called with the transaction info when the threshold is crossed.
+	memBufferThresholdHook func(info *txninfo.TxnInfo)
+	// requestTag is set by SetRequestTag, it's applied to the transaction and recorded in the TxnInfo once the
+	// transaction becomes valid.
+	requestTag []byte
+	// sqlDigestsMemoryLimit is the soft limit of the bytes of the SQL digests kept by the session, 0 means unlimited.
+	sqlDigestsMemoryLimit int64
 
 	// TxnInfo is added for the lock view feature, the data is frequent modified but
 	// rarely read (just in query select * from information_schema.tidb_trx).
@@ -66,6 +116,8 @@ type LazyTxn struct {
 	mu struct {
 		sync.RWMutex
 		txninfo.TxnInfo
+		// recentTxns records the summaries of the recently finished transactions.
+		recentTxns recentTxnRing
 	}
 }
 
@@ -79,11 +131,77 @@ func (txn *LazyTxn) CacheTableInfo(id int64, info *model.TableInfo) {
 	txn.Transaction.CacheTableInfo(id, info)
 }
 
+// SetClock sets the source of the time recorded in TxnInfo, a nil clock restores nowFunc.
+// It's used to make the time-based tests deterministic.
+func (txn *LazyTxn) SetClock(c timeutil.Clock) {
+	txn.clock = c
+}
+
+// SetKeepLatestSQLDigests sets whether TxnInfo.AllSQLDigests works as a ring buffer. If it's true, the oldest digest is
+// evicted when a new statement starts and the history is full, otherwise the new digest is dropped, which is the default.
+func (txn *LazyTxn) SetKeepLatestSQLDigests(keepLatest bool) {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	txn.keepLatestSQLDigests = keepLatest
+}
+
+// SetTrackLockDigests sets whether LockKeys records the count of the keys locked by each statement digest in
+// TxnInfo.LockedKeysByDigest, so the statements acquiring the locks can be told when diagnosing the lock waits and
+// the deadlocks. It's disabled by default.
+func (txn *LazyTxn) SetTrackLockDigests(track bool) {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	txn.trackLockDigests = track
+}
+
+// SetReadOnly sets whether the transaction rejects the writes. If it's true, a statement which stages any write fails
+// at StmtCommit and its writes are discarded. Locking keys doesn't stage writes, so it's still allowed.
+func (txn *LazyTxn) SetReadOnly(readOnly bool) {
+	txn.readOnly = readOnly
+}
+
+// nowFunc is the default source of the time recorded in TxnInfo of all the transactions, it's a function variable
+// rather than a timeutil.Clock since it's on the hot path, and it can be overridden by the tests.
+var nowFunc = time.Now
+
+func (txn *LazyTxn) now() time.Time {
+	if txn.clock == nil {
+		return nowFunc()
+	}
+	return txn.clock.Now()
+}
+
 func (txn *LazyTxn) init() {
-	txn.mutations = make(map[int64]*binlog.TableMutation)
+	// The mutations are allocated lazily by StmtGetMutation, since they're only used when binlog is enabled.
+	txn.mutations = nil
 	txn.mu.Lock()
 	defer txn.mu.Unlock()
 	txn.mu.TxnInfo = txninfo.TxnInfo{}
+	txn.mu.recentTxns.resize(defaultRecentTxnsCapacity)
+}
+
+// TimeInCurrentState returns the elapsed time since the transaction entered its current state.
+// It returns 0 if the state has never been set, e.g. the transaction is invalid.
+func (txn *LazyTxn) TimeInCurrentState() time.Duration {
+	txn.mu.RLock()
+	lastStateChangeTime := txn.mu.TxnInfo.LastStateChangeTime
+	txn.mu.RUnlock()
+	if lastStateChangeTime.IsZero() {
+		return 0
+	}
+	return txn.now().Sub(lastStateChangeTime)
+}
+
+// Age returns the elapsed wall-clock time since the transaction became valid, it's useful for the timeout
+// policies based on the wall time. It returns 0 if the transaction isn't valid.
+func (txn *LazyTxn) Age() time.Duration {
+	txn.mu.RLock()
+	startTime := txn.mu.TxnInfo.StartTime
+	txn.mu.RUnlock()
+	if startTime.IsZero() {
+		return 0
+	}
+	return txn.now().Sub(startTime)
 }
 
 // call this under lock!
@@ -92,10 +210,11 @@ func (txn *LazyTxn) updateState(state txninfo.TxnRunningState) {
 		lastState := txn.mu.TxnInfo.State
 		lastStateChangeTime := txn.mu.TxnInfo.LastStateChangeTime
 		txn.mu.TxnInfo.State = state
-		txn.mu.TxnInfo.LastStateChangeTime = time.Now()
+		txn.mu.TxnInfo.LastStateChangeTime = txn.now()
 		if !lastStateChangeTime.IsZero() {
+			txn.stateDurations[lastState] += txn.mu.TxnInfo.LastStateChangeTime.Sub(lastStateChangeTime)
 			hasLockLbl := !txn.mu.TxnInfo.BlockStartTime.IsZero()
-			txninfo.TxnDurationHistogram(lastState, hasLockLbl).Observe(time.Since(lastStateChangeTime).Seconds())
+			txninfo.TxnDurationHistogram(lastState, hasLockLbl).Observe(txn.mu.TxnInfo.LastStateChangeTime.Sub(lastStateChangeTime).Seconds())
 		}
 		txninfo.TxnStatusEnteringCounter(state).Inc()
 	}
@@ -108,6 +227,64 @@ func (txn *LazyTxn) initStmtBuf() {
 	buf := txn.Transaction.GetMemBuffer()
 	txn.initCnt = buf.Len()
 	txn.stagingHandle = buf.Staging()
+	txn.stmtBuf.stageCnt = txn.initCnt
+	txn.stmtBuf.startCheckpoint = nil
+	if txn.stmtBuf.flushThreshold > 0 {
+		txn.stmtBuf.startCheckpoint = txn.Transaction.GetMemDBCheckpoint()
+	}
+}
+
+// SetStmtBufferFlushThreshold sets the number of the entries staged since the last flush to flush the statement
+// buffer in the middle of the statement, 0 disables the flush. It's set at the start of a statement.
+func (txn *LazyTxn) SetStmtBufferFlushThreshold(threshold int64) {
+	txn.stmtBuf.flushThreshold = threshold
+	// The staging buffer of the statement may be started before the flush is enabled, it's still empty.
+	if threshold > 0 && txn.stmtBuf.startCheckpoint == nil && txn.stagingHandle != kv.InvalidStagingHandle && txn.countHint() == 0 {
+		txn.stmtBuf.startCheckpoint = txn.Transaction.GetMemDBCheckpoint()
+	}
+}
+
+// needFlushStmtBuf returns whether there are more than the threshold entries staged since the last flush.
+func (txn *LazyTxn) needFlushStmtBuf() bool {
+	if txn.stmtBuf.flushThreshold <= 0 || txn.stmtBuf.startCheckpoint == nil || txn.stagingHandle == kv.InvalidStagingHandle {
+		return false
+	}
+	return int64(txn.Transaction.GetMemBuffer().Len()-txn.stmtBuf.stageCnt) > txn.stmtBuf.flushThreshold
+}
+
+// flushStmtBufIfNeeded flushes the changes staged since the last flush to the transaction and starts a new staging
+// buffer for the following changes of the statement, if there are more than the threshold ones. The statement is
+// rolled back to the checkpoint of its start by cleanupStmtBuf. The row count and the keys need to be locked of the
+// flushed changes are kept, since the checks at StmtCommit only inspect the current staging buffer.
+func (txn *LazyTxn) flushStmtBufIfNeeded() {
+	if !txn.needFlushStmtBuf() {
+		return
+	}
+	buf := txn.Transaction.GetMemBuffer()
+	if txn.stmtBuf.flushedRows == nil {
+		txn.stmtBuf.flushedRows = make(map[int64]int64)
+	}
+	pessimistic := txn.Transaction.IsPessimistic()
+	buf.InspectStage(txn.stagingHandle, func(k kv.Key, flags kv.KeyFlags, v []byte) {
+		if tablecodec.IsRecordKey(k) {
+			txn.stmtBuf.flushedRows[tablecodec.DecodeTableID(k)]++
+		}
+		if pessimistic && keyNeedToLock(k, v, flags) {
+			txn.stmtBuf.flushedLockKeys = append(txn.stmtBuf.flushedLockKeys, k.Clone())
+		}
+	})
+	buf.Release(txn.stagingHandle)
+	txn.stagingHandle = buf.Staging()
+	txn.stmtBuf.stageCnt = buf.Len()
+	txn.stmtBuf.flushed = true
+}
+
+// resetStmtBufFlush clears the flushes of the statement buffer when the statement ends.
+func (txn *LazyTxn) resetStmtBufFlush() {
+	txn.stmtBuf.startCheckpoint = nil
+	txn.stmtBuf.flushed = false
+	txn.stmtBuf.flushedRows = nil
+	txn.stmtBuf.flushedLockKeys = nil
 }
 
 // countHint is estimated count of mutations.
@@ -118,27 +295,87 @@ func (txn *LazyTxn) countHint() int {
 	return txn.Transaction.GetMemBuffer().Len() - txn.initCnt
 }
 
+var (
+	stmtBufferFlushCounter   = metrics.StmtBufferOutcomeCounter.WithLabelValues("flush")
+	stmtBufferCleanupCounter = metrics.StmtBufferOutcomeCounter.WithLabelValues("cleanup")
+)
+
 func (txn *LazyTxn) flushStmtBuf() {
 	if txn.stagingHandle == kv.InvalidStagingHandle {
 		return
 	}
+	stmtBufferFlushCounter.Inc()
 	buf := txn.Transaction.GetMemBuffer()
 	buf.Release(txn.stagingHandle)
+	// The released staging buffer is invalidated so that the cleanup following the flush is a no-op.
+	txn.stagingHandle = kv.InvalidStagingHandle
+	txn.resetStmtBufFlush()
 	txn.initCnt = buf.Len()
+	txn.updateEntriesInfo()
 }
 
 func (txn *LazyTxn) cleanupStmtBuf() {
 	if txn.stagingHandle == kv.InvalidStagingHandle {
 		return
 	}
+	stmtBufferCleanupCounter.Inc()
 	buf := txn.Transaction.GetMemBuffer()
 	buf.Cleanup(txn.stagingHandle)
+	txn.stagingHandle = kv.InvalidStagingHandle
+	if txn.stmtBuf.flushed {
+		txn.Transaction.RollbackMemDBToCheckpoint(txn.stmtBuf.startCheckpoint)
+	}
+	txn.resetStmtBufFlush()
 	txn.initCnt = buf.Len()
+	txn.updateEntriesInfo()
+}
 
+func (txn *LazyTxn) updateEntriesInfo() {
 	txn.mu.Lock()
-	defer txn.mu.Unlock()
 	txn.mu.TxnInfo.EntriesCount = uint64(txn.Transaction.Len())
 	txn.mu.TxnInfo.EntriesSize = uint64(txn.Transaction.Size())
+	txn.mu.Unlock()
+	txn.checkMemBufferThreshold()
+}
+
+// SetMemBufferThreshold sets the size of the mem buffer to emit a warning when it's crossed, 0 disables the warning.
+func (txn *LazyTxn) SetMemBufferThreshold(threshold int64) {
+	txn.memBufferThreshold = threshold
+}
+
+// SetMemBufferThresholdHook sets the function called with a copy of the transaction info when the size of the mem
+// buffer crosses the threshold, nil removes the hook.
+func (txn *LazyTxn) SetMemBufferThresholdHook(hook func(info *txninfo.TxnInfo)) {
+	txn.memBufferThresholdHook = hook
+}
+
+// checkMemBufferThreshold emits a warning when the size of the mem buffer crosses the threshold. It's emitted once
+// per crossing, i.e. it's emitted again only if the size falls below the threshold then crosses it again.
+func (txn *LazyTxn) checkMemBufferThreshold() {
+	if txn.memBufferThreshold <= 0 {
+		return
+	}
+	txn.mu.RLock()
+	// Copy on read, the hook may keep the info.
+	info := txn.mu.TxnInfo
+	txn.mu.RUnlock()
+	if info.EntriesSize < uint64(txn.memBufferThreshold) {
+		txn.memBufferThresholdCrossed = false
+		return
+	}
+	if txn.memBufferThresholdCrossed {
+		return
+	}
+	txn.memBufferThresholdCrossed = true
+	logutil.BgLogger().Warn("the mem buffer of the transaction exceeds the threshold",
+		zap.Uint64("startTS", info.StartTS),
+		zap.Uint64("entriesCount", info.EntriesCount),
+		zap.Uint64("entriesSize", info.EntriesSize),
+		zap.Int64("threshold", txn.memBufferThreshold),
+		zap.String("sqlDigest", info.CurrentSQLDigest))
+	if txn.memBufferThresholdHook != nil {
+		txn.memBufferThresholdHook(&info)
+	}
 }
 
 // resetTxnInfo resets the transaction info.
@@ -151,19 +388,20 @@ func (txn *LazyTxn) resetTxnInfo(
 	currentSQLDigest string,
 	allSQLDigests []string,
 ) {
+	now := txn.now()
 	if !txn.mu.LastStateChangeTime.IsZero() {
 		lastState := txn.mu.State
 		hasLockLbl := !txn.mu.BlockStartTime.IsZero()
-		txninfo.TxnDurationHistogram(lastState, hasLockLbl).Observe(time.Since(txn.mu.TxnInfo.LastStateChangeTime).Seconds())
+		txninfo.TxnDurationHistogram(lastState, hasLockLbl).Observe(now.Sub(txn.mu.TxnInfo.LastStateChangeTime).Seconds())
 	}
 	if txn.mu.TxnInfo.StartTS != 0 {
-		txninfo.Recorder.OnTrxEnd(&txn.mu.TxnInfo)
+		txn.onTrxEnd(now)
 	}
 	txn.mu.TxnInfo = txninfo.TxnInfo{}
 	txn.mu.TxnInfo.StartTS = startTS
 	txn.mu.TxnInfo.State = state
 	txninfo.TxnStatusEnteringCounter(state).Inc()
-	txn.mu.TxnInfo.LastStateChangeTime = time.Now()
+	txn.mu.TxnInfo.LastStateChangeTime = now
 	txn.mu.TxnInfo.EntriesCount = entriesCount
 	txn.mu.TxnInfo.EntriesSize = entriesSize
 	txn.mu.TxnInfo.CurrentSQLDigest = currentSQLDigest
@@ -178,11 +416,109 @@ func (txn *LazyTxn) Size() int {
 	return txn.Transaction.Size()
 }
 
+// checkLargeWriteSet emits a warning when the size of the transaction first exceeds
+// the warning threshold, it's emitted at most once per transaction.
+func (txn *LazyTxn) checkLargeWriteSet() {
+	threshold := variable.TxnLargeWriteSetWarningThreshold.Load()
+	if threshold <= 0 || txn.largeWriteSetWarned {
+		return
+	}
+	size := txn.Size()
+	if int64(size) < threshold {
+		return
+	}
+	txn.largeWriteSetWarned = true
+	metrics.TxnLargeWriteSetWarningCounter.Inc()
+	txn.mu.RLock()
+	startTS, digest := txn.mu.TxnInfo.StartTS, txn.mu.TxnInfo.CurrentSQLDigest
+	txn.mu.RUnlock()
+	logutil.BgLogger().Warn("TxnLargeWriteSetWarning",
+		zap.Uint64("startTS", startTS),
+		zap.Int("size", size),
+		zap.Int64("threshold", threshold),
+		zap.String("sqlDigest", digest))
+}
+
+// checkStmtTableRowLimit checks whether the current statement mutates more rows than the limit in any table.
+// The row keys in the statement buffer are counted, so it works whether the binlog is enabled or not.
+func (txn *LazyTxn) checkStmtTableRowLimit(limit int64) error {
+	if limit <= 0 || txn.stagingHandle == kv.InvalidStagingHandle {
+		return nil
+	}
+	rows := make(map[int64]int64, len(txn.stmtBuf.flushedRows))
+	for tableID, cnt := range txn.stmtBuf.flushedRows {
+		rows[tableID] = cnt
+	}
+	var err error
+	txn.Transaction.GetMemBuffer().InspectStage(txn.stagingHandle, func(k kv.Key, _ kv.KeyFlags, _ []byte) {
+		if err != nil || !tablecodec.IsRecordKey(k) {
+			return
+		}
+		tableID := tablecodec.DecodeTableID(k)
+		rows[tableID]++
+		if rows[tableID] > limit {
+			err = ErrStmtTableRowLimitExceeded.GenWithStackByArgs(rows[tableID], tableID, limit)
+		}
+	})
+	return err
+}
+
+// checkBinlogSizeLimit checks whether the binlog of the transaction exceeds the limit after the binlog mutations of
+// the current statement are merged, it returns the size of the binlog mutations of the current statement.
+func (txn *LazyTxn) checkBinlogSizeLimit(limit int64) (int64, error) {
+	var stmtSize int64
+	for _, m := range txn.mutations {
+		stmtSize += int64(m.Size())
+	}
+	if limit > 0 && txn.binlogSize+stmtSize > limit {
+		return 0, ErrBinlogTxnSizeLimitExceeded.GenWithStackByArgs(txn.binlogSize+stmtSize, limit)
+	}
+	return stmtSize, nil
+}
+
+// BinlogSize returns the estimated size in bytes of the binlog of the transaction, the binlog mutations of the
+// current statement are not included until the statement is committed.
+func (txn *LazyTxn) BinlogSize() int64 {
+	return txn.binlogSize
+}
+
+// Mutations returns a deep copy of the binlog mutations of the current statement keyed by the table ID.
+func (txn *LazyTxn) Mutations() map[int64]*binlog.TableMutation {
+	return cloneTableMutations(txn.mutations)
+}
+
+// checkReadOnly checks whether the current statement stages any write when the transaction is read-only.
+// Only the entries with values are inspected, so the keys which are only locked are not treated as writes.
+func (txn *LazyTxn) checkReadOnly() error {
+	if !txn.readOnly || txn.stagingHandle == kv.InvalidStagingHandle {
+		return nil
+	}
+	hasWrite := false
+	txn.Transaction.GetMemBuffer().InspectStage(txn.stagingHandle, func(kv.Key, kv.KeyFlags, []byte) {
+		hasWrite = true
+	})
+	if hasWrite {
+		return ErrWriteInReadOnlySession.GenWithStackByArgs()
+	}
+	return nil
+}
+
 // Valid implements the kv.Transaction interface.
 func (txn *LazyTxn) Valid() bool {
 	return txn.Transaction != nil && txn.Transaction.Valid()
 }
 
+// ValidStartTS returns the start TS of the transaction and true if it's valid, or 0 and false if it's pending or
+// invalid, it doesn't activate the pending transaction. It's safe to be called by the other sessions, e.g. to get
+// the oldest transaction of a session manager. It can't be named StartTS, which is implemented for kv.Transaction.
+func (txn *LazyTxn) ValidStartTS() (uint64, bool) {
+	txn.mu.RLock()
+	defer txn.mu.RUnlock()
+	// The start TS in TxnInfo is set when the transaction becomes valid, and reset when it becomes invalid.
+	startTS := txn.mu.TxnInfo.StartTS
+	return startTS, startTS != 0
+}
+
 func (txn *LazyTxn) pending() bool {
 	return txn.Transaction == nil && txn.txnFuture != nil
 }
@@ -253,6 +589,10 @@ func (txn *LazyTxn) changePendingToValid(ctx context.Context) error {
 		return err
 	}
 	txn.Transaction = t
+	txn.largeWriteSetWarned = false
+	if txn.requestTag != nil {
+		t.SetOption(kv.RequestTag, txn.requestTag)
+	}
 	txn.initStmtBuf()
 
 	// The txnInfo may already recorded the first statement (usually "begin") when it's pending, so keep them.
@@ -265,15 +605,79 @@ func (txn *LazyTxn) changePendingToValid(ctx context.Context) error {
 		uint64(txn.Transaction.Size()),
 		txn.mu.TxnInfo.CurrentSQLDigest,
 		txn.mu.TxnInfo.AllSQLDigests)
+	txn.mu.TxnInfo.StartTime = txn.mu.TxnInfo.LastStateChangeTime
+	txn.mu.TxnInfo.RequestTag = txn.requestTag
 
 	return nil
 }
 
+// SetRequestTag attaches the tag, e.g. the request ID of the application, to the TiKV requests of the transaction and
+// to its TxnInfo, so it shows up in the transaction views. The tag is set as kv.RequestTag, which is appended to the
+// resource group tags set by the statements for Top SQL instead of overriding them. If the transaction is pending or
+// invalid, the tag is applied once it becomes valid. The tag is cleared when the transaction ends. The tag is copied.
+func (txn *LazyTxn) SetRequestTag(tag []byte) {
+	if tag != nil {
+		tag = append([]byte{}, tag...)
+	}
+	txn.requestTag = tag
+	if txn.Valid() {
+		txn.Transaction.SetOption(kv.RequestTag, tag)
+	}
+	txn.mu.Lock()
+	txn.mu.TxnInfo.RequestTag = tag
+	txn.mu.Unlock()
+}
+
+// onTrxEnd records the end of the transaction, the duration is measured from the start time recorded by the same
+// clock as now.
+// Note: call it under lock!
+func (txn *LazyTxn) onTrxEnd(now time.Time) {
+	txninfo.Recorder.OnTrxEnd(&txn.mu.TxnInfo)
+	txn.mu.recentTxns.push(RecentTxnInfo{
+		StartTS:       txn.mu.TxnInfo.StartTS,
+		AllSQLDigests: txn.mu.TxnInfo.AllSQLDigests,
+		Duration:      now.Sub(txn.mu.TxnInfo.StartTime),
+	})
+	// The digests of the transaction are moved to the recent transactions.
+	txn.trimSQLDigests(nil)
+}
+
+// SetLongTxnLogThreshold sets the duration of the transaction to log its statement digests when it ends,
+// 0 disables the log.
+func (txn *LazyTxn) SetLongTxnLogThreshold(threshold time.Duration) {
+	txn.longTxnLogThreshold = threshold
+}
+
+// logLongTxn logs the statement digests and the durations of the states of the transaction if it lasts longer
+// than the threshold, the duration is measured from the start time recorded by the same clock as now.
+// Note: call it under lock!
+func (txn *LazyTxn) logLongTxn(now time.Time) {
+	if txn.longTxnLogThreshold <= 0 {
+		return
+	}
+	duration := now.Sub(txn.mu.TxnInfo.StartTime)
+	if duration < txn.longTxnLogThreshold {
+		return
+	}
+	logutil.BgLogger().Warn("long transaction finished",
+		zap.Uint64("conn", txn.mu.TxnInfo.ConnectionID),
+		zap.Uint64("startTS", txn.mu.TxnInfo.StartTS),
+		zap.Duration("duration", duration),
+		zap.Duration("threshold", txn.longTxnLogThreshold),
+		zap.Strings("allSQLDigests", txn.mu.TxnInfo.AllSQLDigests),
+		zap.Duration("idleDuration", txn.stateDurations[txninfo.TxnIdle]),
+		zap.Duration("executingSQLDuration", txn.stateDurations[txninfo.TxnRunning]),
+		zap.Duration("acquiringLockDuration", txn.stateDurations[txninfo.TxnLockAcquiring]),
+		zap.Duration("committingDuration", txn.stateDurations[txninfo.TxnCommitting]),
+		zap.Duration("rollingBackDuration", txn.stateDurations[txninfo.TxnRollingBack]))
+}
+
 func (txn *LazyTxn) changeToInvalid() {
 	if txn.stagingHandle != kv.InvalidStagingHandle {
 		txn.Transaction.GetMemBuffer().Cleanup(txn.stagingHandle)
 	}
 	txn.stagingHandle = kv.InvalidStagingHandle
+	txn.resetStmtBufFlush()
 	txn.Transaction = nil
 	txn.txnFuture = nil
 
@@ -281,16 +685,28 @@ func (txn *LazyTxn) changeToInvalid() {
 	lastState := txn.mu.TxnInfo.State
 	lastStateChangeTime := txn.mu.TxnInfo.LastStateChangeTime
 	hasLock := !txn.mu.TxnInfo.BlockStartTime.IsZero()
+	now := txn.now()
+	if !lastStateChangeTime.IsZero() {
+		txn.stateDurations[lastState] += now.Sub(lastStateChangeTime)
+	}
 	if txn.mu.TxnInfo.StartTS != 0 {
-		txninfo.Recorder.OnTrxEnd(&txn.mu.TxnInfo)
+		txn.onTrxEnd(now)
+		txn.logLongTxn(now)
 	}
 	txn.mu.TxnInfo = txninfo.TxnInfo{}
+	txn.stateDurations = [txninfo.TxnStateCounter]time.Duration{}
 	txn.mu.Unlock()
+	txn.binlogSize = 0
+	txn.memBufferThresholdCrossed = false
+	txn.requestTag = nil
 	if !lastStateChangeTime.IsZero() {
-		txninfo.TxnDurationHistogram(lastState, hasLock).Observe(time.Since(lastStateChangeTime).Seconds())
+		txninfo.TxnDurationHistogram(lastState, hasLock).Observe(now.Sub(lastStateChangeTime).Seconds())
 	}
 }
 
+// maxTransactionStmtHistory is the max count of the digests kept in TxnInfo.AllSQLDigests.
+const maxTransactionStmtHistory int = 50
+
 func (txn *LazyTxn) onStmtStart(currentSQLDigest string) {
 	if len(currentSQLDigest) == 0 {
 		return
@@ -301,9 +717,42 @@ func (txn *LazyTxn) onStmtStart(currentSQLDigest string) {
 	txn.updateState(txninfo.TxnRunning)
 	txn.mu.TxnInfo.CurrentSQLDigest = currentSQLDigest
 	// Keeps at most 50 history sqls to avoid consuming too much memory.
-	const maxTransactionStmtHistory int = 50
 	if len(txn.mu.TxnInfo.AllSQLDigests) < maxTransactionStmtHistory {
 		txn.mu.TxnInfo.AllSQLDigests = append(txn.mu.TxnInfo.AllSQLDigests, currentSQLDigest)
+	} else if txn.keepLatestSQLDigests {
+		// The readers may hold a copy of TxnInfo sharing the slice, so don't modify it in place.
+		digests := make([]string, 0, maxTransactionStmtHistory)
+		digests = append(digests, txn.mu.TxnInfo.AllSQLDigests[1:]...)
+		txn.mu.TxnInfo.AllSQLDigests = append(digests, currentSQLDigest)
+	}
+	txn.trimSQLDigests(txn.mu.TxnInfo.AllSQLDigests)
+}
+
+func sqlDigestsSize(digests []string) int64 {
+	var size int64
+	for _, digest := range digests {
+		size += int64(len(digest))
+	}
+	return size
+}
+
+// sqlDigestsMemoryUsage returns the bytes of the SQL digests of the current transaction and the recently finished
+// transactions. Note: call it under lock!
+func (txn *LazyTxn) sqlDigestsMemoryUsage() int64 {
+	return sqlDigestsSize(txn.mu.TxnInfo.AllSQLDigests) + txn.mu.recentTxns.digestsSize
+}
+
+// trimSQLDigests removes the oldest recently finished transactions until the SQL digests kept by the session are
+// within the limit, current is the digests of the current transaction. They're never trimmed, so it's a soft limit.
+// Note: call it under lock!
+func (txn *LazyTxn) trimSQLDigests(current []string) {
+	limit := txn.sqlDigestsMemoryLimit
+	if limit <= 0 {
+		return
+	}
+	currentSize := sqlDigestsSize(current)
+	for txn.mu.recentTxns.size > 0 && currentSize+txn.mu.recentTxns.digestsSize > limit {
+		txn.mu.recentTxns.popOldest()
 	}
 }
 
@@ -342,6 +791,10 @@ func ResetMockAutoRandIDRetryCount(failTimes int64) {
 
 // Commit overrides the Transaction interface.
 func (txn *LazyTxn) Commit(ctx context.Context) error {
+	// The transaction may be already committed or rolled back.
+	if txn.Transaction == nil {
+		return errors.Trace(kv.ErrInvalidTxn)
+	}
 	defer txn.reset()
 	if len(txn.mutations) != 0 || txn.countHint() != 0 {
 		logutil.BgLogger().Error("the code should never run here",
@@ -382,8 +835,17 @@ func (txn *LazyTxn) Commit(ctx context.Context) error {
 	return txn.Transaction.Commit(ctx)
 }
 
+// WriteThroughputSLI returns a copy of the write throughput SLI of the transaction. The SLI isn't reset by Commit,
+// so the throughput of the finished transaction can be read until the SLI is reported after the statement.
+func (txn *LazyTxn) WriteThroughputSLI() sli.TxnWriteThroughputSLI {
+	return txn.writeSLI
+}
+
 // Rollback overrides the Transaction interface.
 func (txn *LazyTxn) Rollback() error {
+	if txn.Transaction == nil {
+		return errors.Trace(kv.ErrInvalidTxn)
+	}
 	defer txn.reset()
 	txn.mu.Lock()
 	txn.updateState(txninfo.TxnRollingBack)
@@ -403,7 +865,7 @@ func (txn *LazyTxn) RollbackMemDBToCheckpoint(savepoint *tikv.MemDBCheckpoint) {
 // LockKeys Wrap the inner transaction's `LockKeys` to record the status
 func (txn *LazyTxn) LockKeys(ctx context.Context, lockCtx *kv.LockCtx, keys ...kv.Key) error {
 	failpoint.Inject("beforeLockKeys", func() {})
-	t := time.Now()
+	t := txn.now()
 
 	var originState txninfo.TxnRunningState
 	txn.mu.Lock()
@@ -411,6 +873,10 @@ func (txn *LazyTxn) LockKeys(ctx context.Context, lockCtx *kv.LockCtx, keys ...k
 	txn.updateState(txninfo.TxnLockAcquiring)
 	txn.mu.TxnInfo.BlockStartTime.Valid = true
 	txn.mu.TxnInfo.BlockStartTime.Time = t
+	if len(keys) > 0 {
+		// The caller may reuse the key, so clone it for the readers.
+		txn.mu.TxnInfo.WaitingForKey = keys[0].Clone()
+	}
 	txn.mu.Unlock()
 
 	err := txn.Transaction.LockKeys(ctx, lockCtx, keys...)
@@ -419,11 +885,49 @@ func (txn *LazyTxn) LockKeys(ctx context.Context, lockCtx *kv.LockCtx, keys ...k
 	defer txn.mu.Unlock()
 	txn.updateState(originState)
 	txn.mu.TxnInfo.BlockStartTime.Valid = false
+	txn.mu.TxnInfo.WaitingForKey = nil
+	if err == nil && len(keys) > 0 && txn.mu.TxnInfo.FirstLockTime.IsZero() {
+		txn.mu.TxnInfo.FirstLockTime = txn.now()
+	}
+	if err == nil && txn.trackLockDigests {
+		txn.recordLockDigest(len(keys))
+	}
+	// The failure is recorded since the original state is restored anyway.
+	if err != nil {
+		txn.mu.TxnInfo.LockFailureCount++
+		txn.mu.TxnInfo.LastLockFailureTime = txn.now()
+	} else {
+		txn.mu.TxnInfo.LastLockFailureTime = time.Time{}
+	}
 	txn.mu.TxnInfo.EntriesCount = uint64(txn.Transaction.Len())
 	txn.mu.TxnInfo.EntriesSize = uint64(txn.Transaction.Size())
 	return err
 }
 
+// maxLockDigests is the max count of the digests kept in TxnInfo.LockedKeysByDigest.
+const maxLockDigests = 32
+
+// recordLockDigest adds the count of the keys locked by the current statement to TxnInfo.LockedKeysByDigest, the new
+// digests are dropped once there are maxLockDigests ones.
+// Note: call it under lock!
+func (txn *LazyTxn) recordLockDigest(keyCnt int) {
+	digest := txn.mu.TxnInfo.CurrentSQLDigest
+	if len(digest) == 0 || keyCnt == 0 {
+		return
+	}
+	old := txn.mu.TxnInfo.LockedKeysByDigest
+	if _, ok := old[digest]; !ok && len(old) >= maxLockDigests {
+		return
+	}
+	// The readers may hold a copy of TxnInfo sharing the map, so don't modify it in place.
+	m := make(map[string]uint64, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[digest] += uint64(keyCnt)
+	txn.mu.TxnInfo.LockedKeysByDigest = m
+}
+
 func (txn *LazyTxn) reset() {
 	txn.cleanup()
 	txn.changeToInvalid()
@@ -443,6 +947,7 @@ func (txn *LazyTxn) KeysNeedToLock() ([]kv.Key, error) {
 		return nil, nil
 	}
 	keys := make([]kv.Key, 0, txn.countHint())
+	keys = append(keys, txn.stmtBuf.flushedLockKeys...)
 	buf := txn.Transaction.GetMemBuffer()
 	buf.InspectStage(txn.stagingHandle, func(k kv.Key, flags kv.KeyFlags, v []byte) {
 		if !keyNeedToLock(k, v, flags) {
@@ -535,9 +1040,54 @@ type txnFuture struct {
 	future   oracle.Future
 	store    kv.Storage
 	txnScope string
+	// staleness is the bounded staleness of the start TS. If it's non-zero, the start TS is
+	// computed from the low resolution timestamp instead of waiting for a fresh one.
+	staleness time.Duration
+}
+
+// txnFutureOption is used to customize a txnFuture.
+type txnFutureOption func(*txnFuture)
+
+// withStaleness makes the txnFuture use a start TS that is at least d behind the current time.
+func withStaleness(d time.Duration) txnFutureOption {
+	return func(tf *txnFuture) {
+		tf.staleness = d
+	}
+}
+
+func newTxnFuture(future oracle.Future, store kv.Storage, txnScope string, opts ...txnFutureOption) *txnFuture {
+	tf := &txnFuture{
+		future:   future,
+		store:    store,
+		txnScope: txnScope,
+	}
+	for _, opt := range opts {
+		opt(tf)
+	}
+	return tf
+}
+
+// staleStartTS computes a start TS which is tf.staleness behind the low resolution timestamp.
+func (tf *txnFuture) staleStartTS() (uint64, error) {
+	ts, err := tf.store.GetOracle().GetLowResolutionTimestamp(context.Background(), &oracle.Option{TxnScope: tf.txnScope})
+	if err != nil {
+		return 0, err
+	}
+	t := oracle.GetTimeFromTS(ts).Add(-tf.staleness)
+	if t.UnixMilli() <= 0 {
+		return 0, errors.Errorf("staleness %v is too large", tf.staleness)
+	}
+	return oracle.GoTimeToTS(t), nil
 }
 
 func (tf *txnFuture) wait() (kv.Transaction, error) {
+	if tf.staleness > 0 {
+		startTS, err := tf.staleStartTS()
+		if err == nil {
+			return tf.store.Begin(tikv.WithTxnScope(tf.txnScope), tikv.WithStartTS(startTS))
+		}
+		logutil.BgLogger().Warn("get stale start ts failed, fallback to fresh tso", zap.Duration("staleness", tf.staleness), zap.Error(err))
+	}
 	startTS, err := tf.future.Wait()
 	failpoint.Inject("txnFutureWait", func() {})
 	if err == nil {
@@ -563,20 +1113,297 @@ func (s *session) HasDirtyContent(tid int64) bool {
 	return it.Valid() && bytes.HasPrefix(it.Key(), seekKey)
 }
 
+// DirtyTableIDs returns the sorted IDs of the tables which have dirty update in the transaction, including the
+// changes of the current statement. The partitions are returned by their physical IDs.
+func (s *session) DirtyTableIDs() []int64 {
+	if s.txn.Transaction == nil {
+		return nil
+	}
+	buf := s.txn.GetMemBuffer()
+	upperBound := kv.Key(tablecodec.TablePrefix()).PrefixNext()
+	var ids []int64
+	// The table ID is encoded in a memcomparable format, so the IDs are sorted. Once a table ID is found, the rest
+	// keys of the table, e.g. all its rows and index entries, are skipped by seeking to the prefix of the next table.
+	for seekKey := kv.Key(tablecodec.TablePrefix()); ; {
+		tid := firstTableID(buf, seekKey, upperBound)
+		if tid == 0 {
+			break
+		}
+		ids = append(ids, tid)
+		if tid == math.MaxInt64 {
+			break
+		}
+		seekKey = tablecodec.EncodeTablePrefix(tid + 1)
+	}
+	return ids
+}
+
+// firstTableID returns the table ID of the first table key in [start, end) of the mem buffer, or 0 if there is none.
+func firstTableID(buf kv.MemBuffer, start, end kv.Key) int64 {
+	it, err := buf.Iter(start, end)
+	if err != nil {
+		terror.Log(err)
+		return 0
+	}
+	defer it.Close()
+	for it.Valid() {
+		if tid := tablecodec.DecodeTableID(it.Key()); tid != 0 {
+			return tid
+		}
+		if err := it.Next(); err != nil {
+			terror.Log(err)
+			return 0
+		}
+	}
+	return 0
+}
+
+// HasAnyDirtyContent checks whether there's any dirty content in the transaction, including the changes of the
+// current statement and the previous ones. The keys only locked, e.g. by `select ... for update`, aren't dirty
+// content since they don't change any data, they're skipped by the iterator of the mem buffer.
+func (s *session) HasAnyDirtyContent() bool {
+	if !s.txn.Valid() || s.txn.Transaction.Len() == 0 {
+		return false
+	}
+	it, err := s.txn.GetMemBuffer().Iter(nil, nil)
+	if err != nil {
+		terror.Log(err)
+		return true
+	}
+	defer it.Close()
+	return it.Valid()
+}
+
 // StmtCommit implements the sessionctx.Context interface.
-func (s *session) StmtCommit() {
+func (s *session) StmtCommit() error {
 	defer func() {
 		s.txn.cleanup()
 	}()
 
 	st := &s.txn
+	// The statement buffer is discarded by cleanup if the checks fail.
+	if err := st.checkReadOnly(); err != nil {
+		return err
+	}
+	if err := st.checkStmtTableRowLimit(s.sessionVars.StmtTableRowLimit); err != nil {
+		return err
+	}
+	binlogSize, err := st.checkBinlogSizeLimit(s.sessionVars.BinlogTxnSizeLimit)
+	if err != nil {
+		return err
+	}
 	st.flushStmtBuf()
+	st.checkLargeWriteSet()
 
+	// Skip merging the mutations if the binlog mutation is disabled.
+	if st.binlogDisabled {
+		return nil
+	}
 	// Need to flush binlog.
 	for tableID, delta := range st.mutations {
 		mutation := getBinlogMutation(s, tableID)
 		mergeToMutation(mutation, delta)
 	}
+	st.binlogSize += binlogSize
+	return nil
+}
+
+// SetReadOnlyAssertion sets whether the session rejects the statements which write data, it's useful for the sessions
+// that are supposed to only read, such as the ones serving the analytics.
+func (s *session) SetReadOnlyAssertion(readOnly bool) {
+	s.txn.SetReadOnly(readOnly)
+}
+
+// TableMutationStats is the summary of the row changes of a table.
+type TableMutationStats struct {
+	Inserted int
+	Updated  int
+	Deleted  int
+}
+
+// StmtMutationStats summarizes the row changes per table of the current staged statement.
+// The mutations already merged into the transaction by previous statements are not included.
+func (s *session) StmtMutationStats() map[int64]TableMutationStats {
+	stats := make(map[int64]TableMutationStats, len(s.txn.mutations))
+	for tableID, m := range s.txn.mutations {
+		stats[tableID] = TableMutationStats{
+			Inserted: len(m.InsertedRows),
+			Updated:  len(m.UpdatedRows),
+			Deleted:  len(m.DeletedIds) + len(m.DeletedPks) + len(m.DeletedRows),
+		}
+	}
+	return stats
+}
+
+// SnapshotStmtMutations returns a deep copy of the binlog mutations of the current staged statement,
+// they can be set back by RestoreStmtMutations, e.g. after a speculative execution.
+func (s *session) SnapshotStmtMutations() map[int64]*binlog.TableMutation {
+	return s.txn.Mutations()
+}
+
+// RestoreStmtMutations sets the binlog mutations of the current staged statement to a deep copy of m.
+// It only restores the binlog mutations, the changes in the mem buffer are not affected.
+func (s *session) RestoreStmtMutations(m map[int64]*binlog.TableMutation) {
+	s.txn.mutations = cloneTableMutations(m)
+}
+
+// PendingBinlogMutations returns a deep copy of the binlog mutations merged into the transaction by the committed
+// statements, which are written to the binlog when the transaction commits. The mutations of the current staged
+// statement are not included, see SnapshotStmtMutations.
+func (s *session) PendingBinlogMutations() []binlog.TableMutation {
+	bin := binloginfo.GetPrewriteValue(s, false)
+	if bin == nil {
+		return nil
+	}
+	mutations := make([]binlog.TableMutation, 0, len(bin.Mutations))
+	for i := range bin.Mutations {
+		mutations = append(mutations, cloneTableMutation(&bin.Mutations[i]))
+	}
+	return mutations
+}
+
+func cloneTableMutations(mutations map[int64]*binlog.TableMutation) map[int64]*binlog.TableMutation {
+	cloned := make(map[int64]*binlog.TableMutation, len(mutations))
+	for tableID, m := range mutations {
+		c := cloneTableMutation(m)
+		cloned[tableID] = &c
+	}
+	return cloned
+}
+
+func cloneTableMutation(m *binlog.TableMutation) binlog.TableMutation {
+	return binlog.TableMutation{
+		TableId:          m.TableId,
+		InsertedRows:     cloneRows(m.InsertedRows),
+		UpdatedRows:      cloneRows(m.UpdatedRows),
+		DeletedIds:       append([]int64(nil), m.DeletedIds...),
+		DeletedPks:       cloneRows(m.DeletedPks),
+		DeletedRows:      cloneRows(m.DeletedRows),
+		Sequence:         append([]binlog.MutationType(nil), m.Sequence...),
+		XXX_unrecognized: append([]byte(nil), m.XXX_unrecognized...),
+	}
+}
+
+func cloneRows(rows [][]byte) [][]byte {
+	if rows == nil {
+		return nil
+	}
+	cloned := make([][]byte, len(rows))
+	for i, row := range rows {
+		cloned[i] = append([]byte(nil), row...)
+	}
+	return cloned
+}
+
+// defaultRecentTxnsCapacity is the default count of the recently finished transactions kept by a session.
+const defaultRecentTxnsCapacity = 8
+
+// RecentTxnInfo is the summary of a finished transaction.
+type RecentTxnInfo struct {
+	StartTS uint64
+	// AllSQLDigests are the digests of all SQLs executed in the transaction.
+	AllSQLDigests []string
+	// Duration is the duration from the start ts to the end of the transaction.
+	Duration time.Duration
+}
+
+// recentTxnRing is a ring buffer of RecentTxnInfo, the oldest one is overwritten when it's full.
+type recentTxnRing struct {
+	buf  []RecentTxnInfo
+	next int
+	size int
+	// digestsSize is the bytes of the SQL digests of the transactions in the ring.
+	digestsSize int64
+}
+
+func (r *recentTxnRing) push(info RecentTxnInfo) {
+	if len(r.buf) == 0 {
+		return
+	}
+	if r.size == len(r.buf) {
+		r.digestsSize -= sqlDigestsSize(r.buf[r.next].AllSQLDigests)
+	}
+	r.digestsSize += sqlDigestsSize(info.AllSQLDigests)
+	r.buf[r.next] = info
+	r.next = (r.next + 1) % len(r.buf)
+	if r.size < len(r.buf) {
+		r.size++
+	}
+}
+
+// list returns the transactions from the oldest to the latest.
+func (r *recentTxnRing) list() []RecentTxnInfo {
+	infos := make([]RecentTxnInfo, 0, r.size)
+	for i := len(r.buf) - r.size; i < len(r.buf); i++ {
+		infos = append(infos, r.buf[(r.next+i)%len(r.buf)])
+	}
+	return infos
+}
+
+// popOldest removes the oldest transaction.
+func (r *recentTxnRing) popOldest() {
+	if r.size == 0 {
+		return
+	}
+	i := (r.next + len(r.buf) - r.size) % len(r.buf)
+	r.digestsSize -= sqlDigestsSize(r.buf[i].AllSQLDigests)
+	r.buf[i] = RecentTxnInfo{}
+	r.size--
+}
+
+// resize changes the capacity of the ring, the latest transactions are kept.
+func (r *recentTxnRing) resize(capacity int) {
+	if capacity < 0 {
+		capacity = 0
+	}
+	infos := r.list()
+	if len(infos) > capacity {
+		infos = infos[len(infos)-capacity:]
+	}
+	r.buf = make([]RecentTxnInfo, capacity)
+	r.size = copy(r.buf, infos)
+	r.next = 0
+	if capacity > 0 {
+		r.next = r.size % capacity
+	}
+	r.digestsSize = 0
+	for _, info := range infos {
+		r.digestsSize += sqlDigestsSize(info.AllSQLDigests)
+	}
+}
+
+// RecentTransactions returns the summaries of the transactions recently finished by the session,
+// from the oldest to the latest.
+func (s *session) RecentTransactions() []RecentTxnInfo {
+	s.txn.mu.RLock()
+	defer s.txn.mu.RUnlock()
+	return s.txn.mu.recentTxns.list()
+}
+
+// SetRecentTransactionsCapacity sets the count of the recently finished transactions kept by the session,
+// 0 disables the recording.
+func (s *session) SetRecentTransactionsCapacity(capacity int) {
+	s.txn.mu.Lock()
+	defer s.txn.mu.Unlock()
+	s.txn.mu.recentTxns.resize(capacity)
+}
+
+// SetSQLDigestsMemoryLimit sets the soft limit of the bytes of the SQL digests kept by the session, including the
+// digests of the current transaction and the recently finished transactions. The oldest finished transactions are
+// removed once it's exceeded, but the digests of the current transaction are kept. 0 means unlimited, which is the
+// default.
+func (s *session) SetSQLDigestsMemoryLimit(limit int64) {
+	s.txn.mu.Lock()
+	defer s.txn.mu.Unlock()
+	s.txn.sqlDigestsMemoryLimit = limit
+	s.txn.trimSQLDigests(s.txn.mu.TxnInfo.AllSQLDigests)
+}
+
+// SQLDigestsMemoryUsage returns the bytes of the SQL digests kept by the session, see SetSQLDigestsMemoryLimit.
+func (s *session) SQLDigestsMemoryUsage() int64 {
+	s.txn.mu.RLock()
+	defer s.txn.mu.RUnlock()
+	return s.txn.sqlDigestsMemoryUsage()
 }
 
 // StmtRollback implements the sessionctx.Context interface.
@@ -584,9 +1411,39 @@ func (s *session) StmtRollback() {
 	s.txn.cleanup()
 }
 
+// StmtFlushIfNeeded implements the sessionctx.Context interface.
+func (s *session) StmtFlushIfNeeded() error {
+	st := &s.txn
+	if !st.needFlushStmtBuf() {
+		return nil
+	}
+	// The flushed changes are checked before they're out of the staging buffer.
+	if err := st.checkReadOnly(); err != nil {
+		return err
+	}
+	if err := st.checkStmtTableRowLimit(s.sessionVars.StmtTableRowLimit); err != nil {
+		return err
+	}
+	st.flushStmtBufIfNeeded()
+	return nil
+}
+
+// SetBinlogMutationDisabled sets whether the binlog mutations of the statements are accumulated, it saves the cost
+// of merging the mutations for the sessions known not to write binlog, e.g. the internal sessions. If it's disabled,
+// StmtGetMutation returns a new empty mutation every time, and the mutations are not merged by StmtCommit.
+func (s *session) SetBinlogMutationDisabled(disabled bool) {
+	s.txn.binlogDisabled = disabled
+}
+
 // StmtGetMutation implements the sessionctx.Context interface.
 func (s *session) StmtGetMutation(tableID int64) *binlog.TableMutation {
 	st := &s.txn
+	if st.binlogDisabled {
+		return &binlog.TableMutation{TableId: tableID}
+	}
+	if st.mutations == nil {
+		st.mutations = make(map[int64]*binlog.TableMutation)
+	}
 	if _, ok := st.mutations[tableID]; !ok {
 		st.mutations[tableID] = &binlog.TableMutation{TableId: tableID}
 	}
@@ -0,0 +1,1308 @@
+// Copyright 2022 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pingcap/failpoint"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/metrics"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/session/txninfo"
+	"github.com/pingcap/tidb/sessionctx/variable"
+	"github.com/pingcap/tidb/sessiontxn"
+	"github.com/pingcap/tidb/store/mockstore"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/resourcegrouptag"
+	"github.com/pingcap/tidb/util/timeutil"
+	"github.com/pingcap/tipb/go-binlog"
+	"github.com/pingcap/tipb/go-tipb"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	"github.com/tikv/client-go/v2/tikvrpc/interceptor"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestTxnFutureStaleness(t *testing.T) {
+	store, err := mockstore.NewMockStore()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, store.Close())
+	}()
+
+	freshTS, err := store.GetOracle().GetTimestamp(context.Background(), &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+	require.NoError(t, err)
+
+	// Without staleness, the start TS comes from the future.
+	tf := newTxnFuture(constantFuture(freshTS), store, oracle.GlobalTxnScope)
+	txn, err := tf.wait()
+	require.NoError(t, err)
+	require.Equal(t, freshTS, txn.StartTS())
+	require.NoError(t, txn.Rollback())
+
+	// With staleness, the start TS is at least staleness behind the fresh one.
+	staleness := 10 * time.Second
+	tf = newTxnFuture(constantFuture(freshTS), store, oracle.GlobalTxnScope, withStaleness(staleness))
+	txn, err = tf.wait()
+	require.NoError(t, err)
+	require.Less(t, txn.StartTS(), freshTS)
+	require.LessOrEqual(t, oracle.GetTimeFromTS(txn.StartTS()), oracle.GetTimeFromTS(freshTS).Add(-staleness+time.Second))
+	require.NoError(t, txn.Rollback())
+
+	// A staleness that is too large falls back to the fresh TSO.
+	tf = newTxnFuture(constantFuture(freshTS), store, oracle.GlobalTxnScope, withStaleness(time.Duration(1<<62)))
+	txn, err = tf.wait()
+	require.NoError(t, err)
+	require.Equal(t, freshTS, txn.StartTS())
+	require.NoError(t, txn.Rollback())
+}
+
+func TestSessionTxnStaleness(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "use test")
+	mustExec(t, se, "create table t (a int)")
+	require.NoError(t, failpoint.Enable("github.com/pingcap/tidb/sessiontxn/isolation/requestTsoFromPD", "return"))
+	defer func() {
+		require.NoError(t, failpoint.Disable("github.com/pingcap/tidb/sessiontxn/isolation/requestTsoFromPD"))
+	}()
+
+	staleness := 10 * time.Second
+	se.SetTxnStaleness(staleness)
+	freshTS, err := store.GetOracle().GetTimestamp(context.Background(), &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+	require.NoError(t, err)
+	se.SetValue(sessiontxn.TsoRequestCount, uint64(0))
+	mustExec(t, se, "begin")
+	txn, err := se.Txn(true)
+	require.NoError(t, err)
+	require.Less(t, txn.StartTS(), freshTS)
+	require.LessOrEqual(t, oracle.GetTimeFromTS(txn.StartTS()), oracle.GetTimeFromTS(freshTS).Add(-staleness+time.Second))
+	// The fresh TSO isn't requested.
+	require.Equal(t, uint64(0), se.Value(sessiontxn.TsoRequestCount))
+	// The write statements are refused.
+	_, err = exec(se, "insert into t values (1)")
+	require.ErrorContains(t, err, "can not execute write statement when the staleness of the start TS is set")
+	mustExec(t, se, "rollback")
+	_, err = exec(se, "insert into t values (1)")
+	require.ErrorContains(t, err, "can not execute write statement when the staleness of the start TS is set")
+
+	se.SetTxnStaleness(0)
+	mustExec(t, se, "begin")
+	txn, err = se.Txn(true)
+	require.NoError(t, err)
+	require.Greater(t, txn.StartTS(), freshTS)
+	require.Equal(t, uint64(1), se.Value(sessiontxn.TsoRequestCount))
+	mustExec(t, se, "insert into t values (1)")
+	mustExec(t, se, "rollback")
+}
+
+type constantFuture uint64
+
+func (f constantFuture) Wait() (uint64, error) {
+	return uint64(f), nil
+}
+
+func TestStmtMutationStats(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+
+	require.Empty(t, se.StmtMutationStats())
+	m := se.StmtGetMutation(1)
+	m.InsertedRows = append(m.InsertedRows, []byte("a"), []byte("b"))
+	m.UpdatedRows = append(m.UpdatedRows, []byte("c"))
+	m = se.StmtGetMutation(2)
+	m.DeletedIds = append(m.DeletedIds, 1)
+	m.DeletedRows = append(m.DeletedRows, []byte("d"))
+	require.Equal(t, map[int64]TableMutationStats{
+		1: {Inserted: 2, Updated: 1},
+		2: {Deleted: 2},
+	}, se.StmtMutationStats())
+
+	// The stats only reflect the current statement.
+	se.StmtRollback()
+	require.Empty(t, se.StmtMutationStats())
+}
+
+func newValidLazyTxn(t *testing.T, store kv.Storage) *LazyTxn {
+	txn := &LazyTxn{}
+	txn.init()
+	inner, err := store.Begin()
+	require.NoError(t, err)
+	txn.Transaction = inner
+	txn.initStmtBuf()
+	return txn
+}
+
+func TestLazyTxnCommitOrRollbackTwice(t *testing.T) {
+	store, err := mockstore.NewMockStore()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, store.Close())
+	}()
+
+	txn := newValidLazyTxn(t, store)
+	require.NoError(t, txn.Commit(context.Background()))
+	require.True(t, kv.ErrInvalidTxn.Equal(txn.Commit(context.Background())))
+	require.True(t, kv.ErrInvalidTxn.Equal(txn.Rollback()))
+
+	txn = newValidLazyTxn(t, store)
+	require.NoError(t, txn.Rollback())
+	require.True(t, kv.ErrInvalidTxn.Equal(txn.Rollback()))
+	require.True(t, kv.ErrInvalidTxn.Equal(txn.Commit(context.Background())))
+}
+
+func TestRecentTransactions(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "use test")
+	mustExec(t, se, "create table t (a int)")
+	// Clear the transactions run by the statements above.
+	se.SetRecentTransactionsCapacity(0)
+	require.Empty(t, se.RecentTransactions())
+	se.SetRecentTransactionsCapacity(2)
+
+	for i := 0; i < 3; i++ {
+		mustExec(t, se, "begin")
+		mustExec(t, se, "insert into t values (?)", i)
+		mustExec(t, se, "insert into t values (?)", i+10)
+		mustExec(t, se, "commit")
+	}
+	// Only the latest 2 transactions are kept.
+	txns := se.RecentTransactions()
+	require.Len(t, txns, 2)
+	require.Less(t, txns[0].StartTS, txns[1].StartTS)
+	for _, txn := range txns {
+		// The digests of begin and commit are also recorded.
+		require.Len(t, txn.AllSQLDigests, 4)
+		require.Greater(t, txn.Duration, time.Duration(0))
+	}
+
+	se.SetRecentTransactionsCapacity(3)
+	mustExec(t, se, "begin")
+	mustExec(t, se, "insert into t values (3)")
+	mustExec(t, se, "commit")
+	txns2 := se.RecentTransactions()
+	require.Len(t, txns2, 3)
+	require.Equal(t, txns, txns2[:2])
+	require.Len(t, txns2[2].AllSQLDigests, 3)
+
+	// The duration is measured by the clock of the transaction.
+	clock := timeutil.NewFakeClock(time.Now())
+	se.txn.SetClock(clock)
+	mustExec(t, se, "begin")
+	mustExec(t, se, "insert into t values (4)")
+	clock.Advance(time.Minute)
+	mustExec(t, se, "commit")
+	txns3 := se.RecentTransactions()
+	require.Len(t, txns3, 3)
+	require.Equal(t, time.Minute, txns3[2].Duration)
+}
+
+func TestSQLDigestsMemoryLimit(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "use test")
+	mustExec(t, se, "create table t (a int)")
+	se.SetRecentTransactionsCapacity(0)
+	require.Zero(t, se.SQLDigestsMemoryUsage())
+	se.SetRecentTransactionsCapacity(4)
+
+	for i := 0; i < 3; i++ {
+		mustExec(t, se, "begin")
+		mustExec(t, se, "insert into t values (?)", i)
+		mustExec(t, se, "commit")
+	}
+	txns := se.RecentTransactions()
+	require.Len(t, txns, 3)
+	txnSize := sqlDigestsSize(txns[0].AllSQLDigests)
+	require.Greater(t, txnSize, int64(0))
+	require.Equal(t, 3*txnSize, se.SQLDigestsMemoryUsage())
+
+	// The oldest transactions are removed once the limit is exceeded.
+	se.SetSQLDigestsMemoryLimit(2 * txnSize)
+	require.Equal(t, txns[1:], se.RecentTransactions())
+	require.Equal(t, 2*txnSize, se.SQLDigestsMemoryUsage())
+	mustExec(t, se, "begin")
+	mustExec(t, se, "insert into t values (3)")
+	// The digests of the current transaction count, but they're kept.
+	require.Equal(t, txns[2:], se.RecentTransactions())
+	require.Equal(t, txnSize+txnSize/3*2, se.SQLDigestsMemoryUsage())
+	mustExec(t, se, "commit")
+	txns2 := se.RecentTransactions()
+	require.Len(t, txns2, 2)
+	require.Equal(t, txns[2], txns2[0])
+	require.Equal(t, 2*txnSize, se.SQLDigestsMemoryUsage())
+
+	se.SetSQLDigestsMemoryLimit(0)
+	mustExec(t, se, "begin")
+	mustExec(t, se, "commit")
+	require.Len(t, se.RecentTransactions(), 3)
+}
+
+func TestSnapshotStmtMutations(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+
+	m := se.StmtGetMutation(1)
+	m.InsertedRows = append(m.InsertedRows, []byte("a"))
+	m.DeletedIds = append(m.DeletedIds, 1)
+	m.Sequence = append(m.Sequence, binlog.MutationType_Insert, binlog.MutationType_DeleteID)
+	snapshot := se.SnapshotStmtMutations()
+
+	// Mutate the current mutations, the snapshot isn't affected.
+	m.InsertedRows[0][0] = 'b'
+	m.InsertedRows = append(m.InsertedRows, []byte("c"))
+	m.DeletedIds[0] = 2
+	se.StmtGetMutation(2).UpdatedRows = [][]byte{[]byte("d")}
+	require.Len(t, snapshot, 1)
+	require.Equal(t, [][]byte{[]byte("a")}, snapshot[1].InsertedRows)
+	require.Equal(t, []int64{1}, snapshot[1].DeletedIds)
+
+	se.RestoreStmtMutations(snapshot)
+	require.Equal(t, map[int64]TableMutationStats{1: {Inserted: 1, Deleted: 1}}, se.StmtMutationStats())
+	restored := se.StmtGetMutation(1)
+	require.Equal(t, [][]byte{[]byte("a")}, restored.InsertedRows)
+	require.Equal(t, []binlog.MutationType{binlog.MutationType_Insert, binlog.MutationType_DeleteID}, restored.Sequence)
+
+	// The restored mutations don't alias the snapshot.
+	restored.InsertedRows[0][0] = 'e'
+	require.Equal(t, [][]byte{[]byte("a")}, snapshot[1].InsertedRows)
+}
+
+func TestTxnLargeWriteSetWarning(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "use test")
+	mustExec(t, se, "create table t (a varchar(255))")
+
+	warningCount := func() float64 {
+		pb := &dto.Metric{}
+		require.NoError(t, metrics.TxnLargeWriteSetWarningCounter.Write(pb))
+		return pb.GetCounter().GetValue()
+	}
+	variable.TxnLargeWriteSetWarningThreshold.Store(1024)
+	defer variable.TxnLargeWriteSetWarningThreshold.Store(variable.DefTiDBTxnLargeWriteSetWarningThreshold)
+
+	for i := 0; i < 2; i++ {
+		before := warningCount()
+		mustExec(t, se, "begin")
+		mustExec(t, se, "insert into t values ('a')")
+		require.Equal(t, before, warningCount())
+		for j := 0; j < 20; j++ {
+			mustExec(t, se, "insert into t values (repeat('a', 255))")
+		}
+		require.Greater(t, se.txn.Size(), 1024)
+		mustExec(t, se, "commit")
+		// The warning is emitted once per transaction.
+		require.Equal(t, before+1, warningCount())
+	}
+}
+
+func TestLongLockHolders(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "use test")
+	mustExec(t, se, "create table t (a int primary key)")
+	mustExec(t, se, "insert into t values (1)")
+	// Advance the fake clock after the keys are locked, so there is no need to sleep.
+	clock := timeutil.NewFakeClock(time.Now())
+	se.txn.SetClock(clock)
+
+	mustExec(t, se, "begin pessimistic")
+	info := se.TxnInfo()
+	require.NotNil(t, info)
+	require.False(t, info.HasLocks())
+
+	mustExec(t, se, "update t set a = 3 where a = 1")
+	info = se.TxnInfo()
+	require.True(t, info.HasLocks())
+	clock.Advance(time.Hour)
+	require.Empty(t, txninfo.LongLockHolders([]*txninfo.TxnInfo{info}, 2*time.Hour, clock.Now()))
+	holders := txninfo.LongLockHolders([]*txninfo.TxnInfo{info, nil}, 30*time.Minute, clock.Now())
+	require.Len(t, holders, 1)
+	require.Equal(t, info.StartTS, holders[0].StartTS)
+	mustExec(t, se, "rollback")
+	require.Nil(t, se.TxnInfo())
+}
+
+func TestKeepLatestSQLDigests(t *testing.T) {
+	digests := make([]string, maxTransactionStmtHistory+10)
+	for i := range digests {
+		digests[i] = fmt.Sprintf("digest%d", i)
+	}
+
+	txn := &LazyTxn{}
+	txn.init()
+	for _, digest := range digests {
+		txn.onStmtStart(digest)
+		txn.onStmtEnd()
+	}
+	// The first digests are kept by default.
+	require.Equal(t, digests[:maxTransactionStmtHistory], txn.mu.TxnInfo.AllSQLDigests)
+
+	txn = &LazyTxn{}
+	txn.init()
+	txn.SetKeepLatestSQLDigests(true)
+	var snapshot []string
+	for i, digest := range digests {
+		if i == maxTransactionStmtHistory {
+			snapshot = txn.mu.TxnInfo.AllSQLDigests
+		}
+		txn.onStmtStart(digest)
+		txn.onStmtEnd()
+	}
+	require.Equal(t, digests[10:], txn.mu.TxnInfo.AllSQLDigests)
+	// The evicting doesn't modify the digests held by the readers.
+	require.Equal(t, digests[:maxTransactionStmtHistory], snapshot)
+}
+
+func TestTxnWaitingForKey(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se1, err := createSession(store)
+	require.NoError(t, err)
+	se2, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se1, "use test")
+	mustExec(t, se1, "create table t (a int primary key)")
+	mustExec(t, se1, "insert into t values (1)")
+	mustExec(t, se2, "use test")
+	tbl, err := dom.InfoSchema().TableByName(model.NewCIStr("test"), model.NewCIStr("t"))
+	require.NoError(t, err)
+	key := tablecodec.EncodeRowKeyWithHandle(tbl.Meta().ID, kv.IntHandle(1))
+
+	mustExec(t, se1, "begin pessimistic")
+	mustExec(t, se1, "select * from t where a = 1 for update")
+	require.Nil(t, se1.TxnInfo().WaitingForKey)
+
+	mustExec(t, se2, "begin pessimistic")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mustExec(t, se2, "select * from t where a = 1 for update")
+	}()
+	require.Eventually(t, func() bool {
+		info := se2.TxnInfo()
+		return info.State == txninfo.TxnLockAcquiring && bytes.Equal(key, info.WaitingForKey)
+	}, 10*time.Second, 10*time.Millisecond)
+
+	mustExec(t, se1, "commit")
+	<-done
+	require.Nil(t, se2.TxnInfo().WaitingForKey)
+	mustExec(t, se2, "commit")
+}
+
+func TestStmtTableRowLimit(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "use test")
+	mustExec(t, se, "create table t (a int primary key)")
+	mustExec(t, se, "create table t1 (a int primary key)")
+	mustExec(t, se, "set @@tidb_stmt_table_row_limit = 2")
+	countRows := func(tbl string) string {
+		rs := mustExec(t, se, "select count(*) from "+tbl)
+		rows, err := ResultSetToStringSlice(context.Background(), se, rs)
+		require.NoError(t, err)
+		return rows[0][0]
+	}
+
+	_, err = exec(se, "insert into t values (1), (2), (3)")
+	require.True(t, ErrStmtTableRowLimitExceeded.Equal(err))
+	require.Equal(t, "0", countRows("t"))
+	mustExec(t, se, "insert into t values (1), (2)")
+	// The limit is checked per table.
+	mustExec(t, se, "insert into t1 values (1), (2)")
+
+	// Only the statement exceeding the limit is rolled back in the transaction.
+	mustExec(t, se, "begin")
+	mustExec(t, se, "insert into t values (3), (4)")
+	_, err = exec(se, "insert into t values (5), (6), (7)")
+	require.True(t, ErrStmtTableRowLimitExceeded.Equal(err))
+	mustExec(t, se, "commit")
+	require.Equal(t, "4", countRows("t"))
+
+	_, err = exec(se, "delete from t")
+	require.True(t, ErrStmtTableRowLimitExceeded.Equal(err))
+	require.Equal(t, "4", countRows("t"))
+	mustExec(t, se, "delete from t where a <= 2")
+	require.Equal(t, "2", countRows("t"))
+
+	// 0 means unlimited.
+	mustExec(t, se, "set @@tidb_stmt_table_row_limit = 0")
+	mustExec(t, se, "delete from t")
+	require.Equal(t, "0", countRows("t"))
+}
+
+func TestHasAnyDirtyContent(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "use test")
+	mustExec(t, se, "create table t (a int primary key)")
+	mustExec(t, se, "insert into t values (1)")
+	require.False(t, se.HasAnyDirtyContent())
+
+	mustExec(t, se, "begin optimistic")
+	require.NoError(t, mustExec(t, se, "select * from t").Close())
+	require.False(t, se.HasAnyDirtyContent())
+	mustExec(t, se, "insert into t values (2)")
+	require.True(t, se.HasAnyDirtyContent())
+	mustExec(t, se, "rollback")
+	require.False(t, se.HasAnyDirtyContent())
+
+	// The keys only locked aren't dirty content.
+	mustExec(t, se, "begin pessimistic")
+	require.NoError(t, mustExec(t, se, "select * from t where a = 1 for update").Close())
+	txn, err := se.Txn(false)
+	require.NoError(t, err)
+	require.Positive(t, txn.Len())
+	require.False(t, se.HasAnyDirtyContent())
+	mustExec(t, se, "insert into t values (2)")
+	require.True(t, se.HasAnyDirtyContent())
+	mustExec(t, se, "commit")
+}
+
+func TestTSFutureHook(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+
+	ts, err := store.GetOracle().GetTimestamp(context.Background(), &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+	require.NoError(t, err)
+	script := []uint64{ts - 20, ts - 10}
+	se.SetTSFutureHook(func(oracle.Future, string) oracle.Future {
+		next := script[0]
+		script = script[1:]
+		return sessiontxn.ConstantFuture(next)
+	})
+	for _, expected := range []uint64{ts - 20, ts - 10} {
+		mustExec(t, se, "begin")
+		txn, err := se.Txn(true)
+		require.NoError(t, err)
+		require.Equal(t, expected, txn.StartTS())
+		mustExec(t, se, "rollback")
+	}
+
+	se.SetTSFutureHook(nil)
+	mustExec(t, se, "begin")
+	txn, err := se.Txn(true)
+	require.NoError(t, err)
+	require.Greater(t, txn.StartTS(), ts)
+	mustExec(t, se, "rollback")
+}
+
+func TestTimeInCurrentState(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	clock := timeutil.NewFakeClock(time.Now())
+	se.txn.SetClock(clock)
+	require.Zero(t, se.txn.TimeInCurrentState())
+
+	mustExec(t, se, "begin")
+	_, err = se.Txn(true)
+	require.NoError(t, err)
+	require.Zero(t, se.txn.TimeInCurrentState())
+	clock.Advance(time.Minute)
+	require.Equal(t, time.Minute, se.txn.TimeInCurrentState())
+	mustExec(t, se, "rollback")
+	require.Zero(t, se.txn.TimeInCurrentState())
+}
+
+func TestLazyTxnAge(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	clock := timeutil.NewFakeClock(time.Now())
+	se.txn.SetClock(clock)
+	require.Zero(t, se.txn.Age())
+
+	mustExec(t, se, "begin")
+	_, err = se.Txn(true)
+	require.NoError(t, err)
+	startTime := clock.Now()
+	require.Equal(t, startTime, se.TxnInfo().StartTime)
+	clock.Advance(time.Minute)
+	// The age doesn't restart when the state changes.
+	mustExec(t, se, "select 1")
+	clock.Advance(time.Minute)
+	require.Equal(t, 2*time.Minute, se.txn.Age())
+	require.Equal(t, startTime, se.TxnInfo().StartTime)
+	mustExec(t, se, "rollback")
+	require.Zero(t, se.txn.Age())
+}
+
+func TestListSavepoints(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "use test")
+	mustExec(t, se, "create table t (a int primary key)")
+	require.Empty(t, se.ListSavepoints())
+
+	mustExec(t, se, "begin")
+	mustExec(t, se, "savepoint s1")
+	mustExec(t, se, "insert into t values (1)")
+	mustExec(t, se, "savepoint S2")
+	mustExec(t, se, "savepoint s3")
+	require.Equal(t, []string{"s1", "s2", "s3"}, se.ListSavepoints())
+	// Redefining a savepoint moves it to the last.
+	mustExec(t, se, "savepoint s1")
+	require.Equal(t, []string{"s2", "s3", "s1"}, se.ListSavepoints())
+	mustExec(t, se, "rollback to savepoint s3")
+	require.Equal(t, []string{"s2", "s3"}, se.ListSavepoints())
+	mustExec(t, se, "release savepoint s3")
+	require.Equal(t, []string{"s2"}, se.ListSavepoints())
+	mustExec(t, se, "commit")
+	require.Empty(t, se.ListSavepoints())
+}
+
+func TestStmtBufferOutcomeCounter(t *testing.T) {
+	store, err := mockstore.NewMockStore()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, store.Close())
+	}()
+
+	counterValue := func(c prometheus.Counter) float64 {
+		pb := &dto.Metric{}
+		require.NoError(t, c.Write(pb))
+		return pb.GetCounter().GetValue()
+	}
+	flushed, cleaned := counterValue(stmtBufferFlushCounter), counterValue(stmtBufferCleanupCounter)
+
+	txn := newValidLazyTxn(t, store)
+	// The cleanup following the flush of a committed statement isn't counted.
+	txn.flushStmtBuf()
+	txn.cleanup()
+	require.Equal(t, flushed+1, counterValue(stmtBufferFlushCounter))
+	require.Equal(t, cleaned, counterValue(stmtBufferCleanupCounter))
+	txn.cleanup()
+	require.Equal(t, flushed+1, counterValue(stmtBufferFlushCounter))
+	require.Equal(t, cleaned+1, counterValue(stmtBufferCleanupCounter))
+
+	// The no-op calls are not counted.
+	txn.stagingHandle = kv.InvalidStagingHandle
+	txn.flushStmtBuf()
+	txn.cleanupStmtBuf()
+	require.Equal(t, flushed+1, counterValue(stmtBufferFlushCounter))
+	require.Equal(t, cleaned+1, counterValue(stmtBufferCleanupCounter))
+	require.NoError(t, txn.Rollback())
+}
+
+func TestReadOnlyAssertion(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "use test")
+	mustExec(t, se, "create table t (a int primary key)")
+	mustExec(t, se, "insert into t values (1)")
+
+	se.SetReadOnlyAssertion(true)
+	rs, err := exec(se, "select * from t")
+	require.NoError(t, err)
+	rows, err := ResultSetToStringSlice(context.Background(), se, rs)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"1"}}, rows)
+
+	_, err = exec(se, "insert into t values (2)")
+	require.True(t, ErrWriteInReadOnlySession.Equal(err))
+	mustExec(t, se, "begin pessimistic")
+	_, err = exec(se, "delete from t")
+	require.True(t, ErrWriteInReadOnlySession.Equal(err))
+	// Locking keys doesn't write data.
+	rs, err = exec(se, "select * from t where a = 1 for update")
+	require.NoError(t, err)
+	require.NoError(t, rs.Close())
+	mustExec(t, se, "commit")
+
+	se.SetReadOnlyAssertion(false)
+	rs, err = exec(se, "select count(*) from t")
+	require.NoError(t, err)
+	rows, err = ResultSetToStringSlice(context.Background(), se, rs)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"1"}}, rows)
+}
+
+func TestWriteThroughputSLI(t *testing.T) {
+	store, err := mockstore.NewMockStore()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, store.Close())
+	}()
+
+	txn := newValidLazyTxn(t, store)
+	txn.writeSLI.AddTxnWriteSize(1024, 2)
+	txn.writeSLI.FinishExecuteStmt(time.Second, 2, true)
+	require.NoError(t, txn.Commit(context.Background()))
+	snapshot := txn.WriteThroughputSLI()
+	require.Equal(t, "invalid: false, affectRow: 2, writeSize: 1024, readKeys: 0, writeKeys: 2, writeTime: 1s", snapshot.String())
+
+	// The snapshot is a copy.
+	txn.writeSLI.Reset()
+	require.Equal(t, "invalid: false, affectRow: 2, writeSize: 1024, readKeys: 0, writeKeys: 2, writeTime: 1s", snapshot.String())
+	snapshot = txn.WriteThroughputSLI()
+	require.Equal(t, "invalid: false, affectRow: 0, writeSize: 0, readKeys: 0, writeKeys: 0, writeTime: 0s", snapshot.String())
+}
+
+func TestLogLongTxn(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "use test")
+	mustExec(t, se, "create table t (a int primary key)")
+
+	_, props, err := log.InitLogger(&log.Config{})
+	require.NoError(t, err)
+	core, logs := observer.New(zap.WarnLevel)
+	restore := log.ReplaceGlobals(zap.New(core), props)
+	defer restore()
+	longTxnLogs := func() []observer.LoggedEntry {
+		return logs.FilterMessage("long transaction finished").AllUntimed()
+	}
+
+	clock := timeutil.NewFakeClock(time.Now())
+	se.txn.SetClock(clock)
+	// The log is disabled by default.
+	mustExec(t, se, "begin")
+	mustExec(t, se, "insert into t values (1)")
+	clock.Advance(time.Hour)
+	mustExec(t, se, "commit")
+	require.Empty(t, longTxnLogs())
+
+	mustExec(t, se, "set @@tidb_long_txn_log_threshold = 60000")
+	clock.Set(time.Now())
+	mustExec(t, se, "begin")
+	mustExec(t, se, "insert into t values (2)")
+	mustExec(t, se, "commit")
+	require.Empty(t, longTxnLogs())
+
+	mustExec(t, se, "begin")
+	mustExec(t, se, "insert into t values (3)")
+	clock.Advance(time.Hour)
+	mustExec(t, se, "insert into t values (4)")
+	mustExec(t, se, "commit")
+	entries := longTxnLogs()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	require.Len(t, fields["allSQLDigests"], 4)
+	require.Equal(t, time.Hour, fields["duration"])
+	require.Equal(t, time.Minute, fields["threshold"])
+	require.Equal(t, time.Hour, fields["idleDuration"])
+}
+
+func TestTxnStateDurationHistogram(t *testing.T) {
+	store, err := mockstore.NewMockStore()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, store.Close())
+	}()
+	clock := timeutil.NewFakeClock(time.Now())
+	defer func(orig func() time.Time) { nowFunc = orig }(nowFunc)
+	nowFunc = clock.Now
+
+	getHistogram := func() (uint64, float64) {
+		pb := &dto.Metric{}
+		require.NoError(t, txninfo.TxnDurationHistogram(txninfo.TxnRunning, false).(prometheus.Histogram).Write(pb))
+		return pb.GetHistogram().GetSampleCount(), pb.GetHistogram().GetSampleSum()
+	}
+	count, sum := getHistogram()
+
+	txn := newValidLazyTxn(t, store)
+	txn.mu.Lock()
+	txn.updateState(txninfo.TxnRunning)
+	txn.mu.Unlock()
+	clock.Advance(2 * time.Second)
+	txn.mu.Lock()
+	txn.updateState(txninfo.TxnIdle)
+	txn.mu.Unlock()
+	newCount, newSum := getHistogram()
+	require.Equal(t, count+1, newCount)
+	require.InDelta(t, sum+2, newSum, 1e-9)
+	require.NoError(t, txn.Rollback())
+}
+
+func TestTxnInfoMarshalJSON(t *testing.T) {
+	b, err := json.Marshal(&txninfo.TxnInfo{})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"start_ts":0,"all_sql_digests":[],"state":"Idle","lock_failure_count":0,"entries_count":0,"entries_size":0,"connection_id":0}`, string(b))
+
+	startTime := time.Date(2022, 7, 1, 10, 0, 0, 123000000, time.UTC)
+	info := &txninfo.TxnInfo{
+		StartTS:             oracle.GoTimeToTS(startTime),
+		CurrentSQLDigest:    "digest2",
+		AllSQLDigests:       []string{"digest1", "digest2"},
+		State:               txninfo.TxnLockAcquiring,
+		LastStateChangeTime: startTime.Add(time.Minute),
+		WaitingForKey:       []byte{0x74, 0x01},
+		FirstLockTime:       startTime.Add(time.Second),
+		EntriesCount:        2,
+		EntriesSize:         20,
+		ConnectionID:        1,
+		Username:            "root",
+		CurrentDB:           "test",
+	}
+	info.BlockStartTime.Valid = true
+	info.BlockStartTime.Time = startTime.Add(2 * time.Minute)
+	b, err = json.Marshal(info)
+	require.NoError(t, err)
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &m))
+	require.Equal(t, "LockWaiting", m["state"])
+	// The times keep the fractional seconds.
+	require.Equal(t, startTime.Local().Format(time.RFC3339Nano), m["start_time"])
+	require.Equal(t, "2022-07-01T10:01:00.123Z", m["last_state_change_time"])
+	require.Equal(t, startTime.Add(2*time.Minute).Format(time.RFC3339Nano), m["block_start_time"])
+	require.Equal(t, startTime.Add(time.Second).Format(time.RFC3339Nano), m["first_lock_time"])
+	require.Equal(t, []interface{}{"digest1", "digest2"}, m["all_sql_digests"])
+	require.Equal(t, "7401", m["waiting_for_key"])
+	require.Equal(t, "root", m["username"])
+}
+
+func TestBinlogTxnSizeLimit(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "begin")
+	require.Equal(t, int64(0), se.txn.BinlogSize())
+
+	m := se.StmtGetMutation(1)
+	m.InsertedRows = append(m.InsertedRows, []byte("a"), []byte("b"))
+	size := int64(m.Size())
+	mustExec(t, se, fmt.Sprintf("set @@tidb_binlog_txn_size_limit = %d", 2*size))
+	require.NoError(t, se.StmtCommit())
+	require.Equal(t, size, se.txn.BinlogSize())
+
+	// The statement exceeding the limit is discarded.
+	m = se.StmtGetMutation(2)
+	m.InsertedRows = append(m.InsertedRows, []byte("abcdefgh"))
+	require.True(t, ErrBinlogTxnSizeLimitExceeded.Equal(se.StmtCommit()))
+	require.Equal(t, size, se.txn.BinlogSize())
+	require.Empty(t, se.StmtMutationStats())
+
+	// 0 means unlimited.
+	mustExec(t, se, "set @@tidb_binlog_txn_size_limit = 0")
+	m = se.StmtGetMutation(2)
+	m.InsertedRows = append(m.InsertedRows, []byte("abcdefgh"))
+	require.NoError(t, se.StmtCommit())
+	require.Equal(t, size+int64(m.Size()), se.txn.BinlogSize())
+
+	// The size is reset when the transaction ends.
+	mustExec(t, se, "rollback")
+	require.Equal(t, int64(0), se.txn.BinlogSize())
+}
+
+func TestPendingBinlogMutations(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "begin")
+	require.Empty(t, se.PendingBinlogMutations())
+
+	m := se.StmtGetMutation(1)
+	m.InsertedRows = append(m.InsertedRows, []byte("a"))
+	m.Sequence = append(m.Sequence, binlog.MutationType_Insert)
+	// The mutations of the current statement are pending until the statement is committed.
+	require.Empty(t, se.PendingBinlogMutations())
+	require.NoError(t, se.StmtCommit())
+	se.StmtGetMutation(1).DeletedIds = []int64{1}
+	se.StmtGetMutation(2).UpdatedRows = [][]byte{[]byte("b")}
+	require.NoError(t, se.StmtCommit())
+
+	mutations := se.PendingBinlogMutations()
+	require.Len(t, mutations, 2)
+	require.Equal(t, int64(1), mutations[0].TableId)
+	require.Equal(t, [][]byte{[]byte("a")}, mutations[0].InsertedRows)
+	require.Equal(t, []int64{1}, mutations[0].DeletedIds)
+	require.Equal(t, int64(2), mutations[1].TableId)
+	require.Equal(t, [][]byte{[]byte("b")}, mutations[1].UpdatedRows)
+
+	// The returned mutations are a snapshot.
+	mutations[0].InsertedRows[0][0] = 'c'
+	require.Equal(t, [][]byte{[]byte("a")}, se.PendingBinlogMutations()[0].InsertedRows)
+	mustExec(t, se, "rollback")
+	require.Empty(t, se.PendingBinlogMutations())
+}
+
+func TestBinlogMutationDisabled(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "begin")
+
+	se.SetBinlogMutationDisabled(true)
+	m := se.StmtGetMutation(1)
+	require.Equal(t, int64(1), m.TableId)
+	require.Empty(t, m.InsertedRows)
+	m.InsertedRows = append(m.InsertedRows, []byte("a"))
+	// The mutation isn't kept.
+	require.Empty(t, se.StmtGetMutation(1).InsertedRows)
+	require.Empty(t, se.StmtMutationStats())
+	require.NoError(t, se.StmtCommit())
+	require.Empty(t, se.PendingBinlogMutations())
+
+	se.SetBinlogMutationDisabled(false)
+	se.StmtGetMutation(1).InsertedRows = [][]byte{[]byte("b")}
+	require.NoError(t, se.StmtCommit())
+	mutations := se.PendingBinlogMutations()
+	require.Len(t, mutations, 1)
+	require.Equal(t, [][]byte{[]byte("b")}, mutations[0].InsertedRows)
+	mustExec(t, se, "rollback")
+}
+
+func TestDirtyTableIDs(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	require.Empty(t, se.DirtyTableIDs())
+	mustExec(t, se, "use test")
+	mustExec(t, se, "create table t1 (a int primary key, b int, index idx(b))")
+	mustExec(t, se, "create table t2 (a int primary key)")
+	mustExec(t, se, "create table t3 (a int primary key)")
+	is := dom.InfoSchema()
+	tableID := func(name string) int64 {
+		tbl, err := is.TableByName(model.NewCIStr("test"), model.NewCIStr(name))
+		require.NoError(t, err)
+		return tbl.Meta().ID
+	}
+
+	mustExec(t, se, "begin")
+	require.Empty(t, se.DirtyTableIDs())
+	mustExec(t, se, "insert into t2 values (1), (2)")
+	mustExec(t, se, "insert into t1 values (1, 1), (2, 2)")
+	require.Equal(t, []int64{tableID("t1"), tableID("t2")}, se.DirtyTableIDs())
+	// The rows and the index entries of a table are skipped once the table is found.
+	mustExec(t, se, "insert into t1 values (3, 3), (4, 4), (5, 5)")
+	mustExec(t, se, "insert into t3 values (1)")
+	require.Equal(t, []int64{tableID("t1"), tableID("t2"), tableID("t3")}, se.DirtyTableIDs())
+	mustExec(t, se, "rollback")
+	require.Empty(t, se.DirtyTableIDs())
+}
+
+func TestTxnMemBufferThreshold(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "use test")
+	mustExec(t, se, "create table t (a varchar(255))")
+	var crossed []*txninfo.TxnInfo
+	se.txn.SetMemBufferThresholdHook(func(info *txninfo.TxnInfo) {
+		crossed = append(crossed, info)
+	})
+	mustExec(t, se, "set @@tidb_txn_mem_buffer_threshold = 1024")
+
+	mustExec(t, se, "begin")
+	mustExec(t, se, "insert into t values ('a')")
+	require.Empty(t, crossed)
+	for i := 0; i < 20; i++ {
+		mustExec(t, se, "insert into t values (repeat('a', 255))")
+	}
+	// The hook is called once per crossing, not on every statement.
+	require.Len(t, crossed, 1)
+	require.Equal(t, se.txn.mu.TxnInfo.StartTS, crossed[0].StartTS)
+	require.GreaterOrEqual(t, crossed[0].EntriesSize, uint64(1024))
+	require.NotEmpty(t, crossed[0].CurrentSQLDigest)
+	mustExec(t, se, "commit")
+
+	// It's called again in the next transaction.
+	mustExec(t, se, "begin")
+	for i := 0; i < 20; i++ {
+		mustExec(t, se, "insert into t values (repeat('a', 255))")
+	}
+	require.Len(t, crossed, 2)
+	mustExec(t, se, "rollback")
+
+	// 0 disables it.
+	mustExec(t, se, "set @@tidb_txn_mem_buffer_threshold = 0")
+	mustExec(t, se, "begin")
+	for i := 0; i < 20; i++ {
+		mustExec(t, se, "insert into t values (repeat('a', 255))")
+	}
+	require.Len(t, crossed, 2)
+	mustExec(t, se, "rollback")
+}
+
+func TestTxnInfoLockFailure(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se1, err := createSession(store)
+	require.NoError(t, err)
+	se2, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se1, "use test")
+	mustExec(t, se2, "use test")
+	mustExec(t, se1, "create table t (a int primary key)")
+	mustExec(t, se1, "insert into t values (1), (2)")
+
+	mustExec(t, se1, "begin pessimistic")
+	mustExec(t, se1, "select * from t where a = 1 for update")
+	mustExec(t, se2, "begin pessimistic")
+	_, err = exec(se2, "select * from t where a = 1 for update nowait")
+	require.Error(t, err)
+	info := se2.TxnInfo()
+	require.Equal(t, uint64(1), info.LockFailureCount)
+	require.False(t, info.LastLockFailureTime.IsZero())
+	// The original state is restored.
+	require.Equal(t, txninfo.TxnIdle, info.State)
+
+	// The time is cleared by the successful lock acquisition, but the count is kept.
+	mustExec(t, se2, "select * from t where a = 2 for update")
+	info = se2.TxnInfo()
+	require.Equal(t, uint64(1), info.LockFailureCount)
+	require.True(t, info.LastLockFailureTime.IsZero())
+	mustExec(t, se1, "rollback")
+	mustExec(t, se2, "rollback")
+
+	// It's reset in the next transaction.
+	mustExec(t, se2, "begin pessimistic")
+	require.Zero(t, se2.TxnInfo().LockFailureCount)
+	mustExec(t, se2, "rollback")
+}
+
+func TestTxnInfoLockedKeysByDigest(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "use test")
+	mustExec(t, se, "create table t (a int primary key)")
+	mustExec(t, se, "insert into t values (1), (2), (3)")
+
+	// It's disabled by default.
+	mustExec(t, se, "begin pessimistic")
+	mustExec(t, se, "select * from t where a = 1 for update")
+	require.Nil(t, se.TxnInfo().LockedKeysByDigest)
+	mustExec(t, se, "rollback")
+
+	se.txn.SetTrackLockDigests(true)
+	mustExec(t, se, "begin pessimistic")
+	mustExec(t, se, "select * from t where a = 1 for update")
+	mustExec(t, se, "select * from t where a = 2 for update")
+	mustExec(t, se, "select * from t where a in (1, 3) for update")
+	info := se.TxnInfo()
+	_, pointDigest := parser.NormalizeDigest("select * from t where a = 1 for update")
+	_, batchDigest := parser.NormalizeDigest("select * from t where a in (1, 3) for update")
+	require.Equal(t, map[string]uint64{pointDigest.String(): 2, batchDigest.String(): 2}, info.LockedKeysByDigest)
+	b, err := json.Marshal(info)
+	require.NoError(t, err)
+	require.Contains(t, string(b), fmt.Sprintf(`"locked_keys_by_digest":{"%s":2,"%s":2}`, pointDigest.String(), batchDigest.String()))
+	mustExec(t, se, "rollback")
+
+	// It's reset in the next transaction.
+	mustExec(t, se, "begin pessimistic")
+	require.Nil(t, se.TxnInfo().LockedKeysByDigest)
+	mustExec(t, se, "rollback")
+}
+
+func TestRecordLockDigestLimit(t *testing.T) {
+	txn := &LazyTxn{}
+	for i := 0; i < maxLockDigests+10; i++ {
+		txn.mu.TxnInfo.CurrentSQLDigest = fmt.Sprintf("digest%d", i)
+		txn.recordLockDigest(1)
+	}
+	require.Len(t, txn.mu.TxnInfo.LockedKeysByDigest, maxLockDigests)
+	// The tracked digests are still counted, but the new ones are dropped.
+	snapshot := txn.mu.TxnInfo.LockedKeysByDigest
+	txn.mu.TxnInfo.CurrentSQLDigest = "digest0"
+	txn.recordLockDigest(2)
+	require.Equal(t, uint64(3), txn.mu.TxnInfo.LockedKeysByDigest["digest0"])
+	require.NotContains(t, txn.mu.TxnInfo.LockedKeysByDigest, fmt.Sprintf("digest%d", maxLockDigests))
+	// The map isn't modified in place.
+	require.Equal(t, uint64(1), snapshot["digest0"])
+}
+
+func TestLazyTxnValidStartTS(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+
+	txn := &LazyTxn{}
+	startTS, ok := txn.ValidStartTS()
+	require.False(t, ok)
+	require.Zero(t, startTS)
+
+	// The pending transaction isn't activated.
+	future := store.GetOracle().GetTimestampAsync(context.Background(), &oracle.Option{TxnScope: kv.GlobalTxnScope})
+	txn.changeToPending(&txnFuture{future: future, store: store, txnScope: kv.GlobalTxnScope})
+	_, ok = txn.ValidStartTS()
+	require.False(t, ok)
+	require.True(t, txn.pending())
+
+	require.NoError(t, txn.changePendingToValid(context.Background()))
+	startTS, ok = txn.ValidStartTS()
+	require.True(t, ok)
+	require.Equal(t, txn.Transaction.StartTS(), startTS)
+	require.NoError(t, txn.Rollback())
+	txn.changeToInvalid()
+	_, ok = txn.ValidStartTS()
+	require.False(t, ok)
+}
+
+func TestLazyTxnSetRequestTag(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+
+	// The request tag is appended to the resource group tag set for Top SQL.
+	sqlDigest := parser.NewDigest([]byte("sql"))
+	topSQLTagger := func(req *tikvrpc.Request) {
+		req.ResourceGroupTag = resourcegrouptag.EncodeResourceGroupTag(sqlDigest, nil, tipb.ResourceGroupTagLabel_ResourceGroupTagLabelUnknown)
+	}
+	var sentTag []byte
+	recordTag := func(next interceptor.RPCInterceptorFunc) interceptor.RPCInterceptorFunc {
+		return func(target string, req *tikvrpc.Request) (*tikvrpc.Response, error) {
+			if req.Type == tikvrpc.CmdGet {
+				sentTag = req.ResourceGroupTag
+			}
+			return next(target, req)
+		}
+	}
+	checkSentTag := func(txn *LazyTxn, requestTag []byte) {
+		sentTag = nil
+		_, err := txn.Get(context.Background(), kv.Key("request_tag"))
+		require.True(t, kv.ErrNotExist.Equal(err))
+		decodedSQLDigest, err := resourcegrouptag.DecodeResourceGroupTag(sentTag)
+		require.NoError(t, err)
+		require.Equal(t, sqlDigest.Bytes(), decodedSQLDigest)
+		decodedRequestTag, err := resourcegrouptag.DecodeRequestTag(sentTag)
+		require.NoError(t, err)
+		require.Equal(t, requestTag, decodedRequestTag)
+	}
+
+	// The tag set while pending is applied once the transaction becomes valid.
+	txn := &LazyTxn{}
+	future := store.GetOracle().GetTimestampAsync(context.Background(), &oracle.Option{TxnScope: kv.GlobalTxnScope})
+	txn.changeToPending(&txnFuture{future: future, store: store, txnScope: kv.GlobalTxnScope})
+	tag := []byte("req-1")
+	txn.SetRequestTag(tag)
+	require.NoError(t, txn.changePendingToValid(context.Background()))
+	require.Equal(t, []byte("req-1"), txn.mu.TxnInfo.RequestTag)
+	b, err := json.Marshal(&txn.mu.TxnInfo)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"request_tag":"req-1"`)
+	txn.SetOption(kv.RPCInterceptor, interceptor.RPCInterceptor(recordTag))
+	txn.SetOption(kv.ResourceGroupTagger, tikvrpc.ResourceGroupTagger(topSQLTagger))
+	checkSentTag(txn, []byte("req-1"))
+
+	// The tag is copied.
+	tag[0] = 'x'
+	require.Equal(t, []byte("req-1"), txn.mu.TxnInfo.RequestTag)
+	checkSentTag(txn, []byte("req-1"))
+
+	// The tag set while valid is applied at once.
+	txn.SetRequestTag([]byte("req-2"))
+	require.Equal(t, []byte("req-2"), txn.mu.TxnInfo.RequestTag)
+	checkSentTag(txn, []byte("req-2"))
+
+	// The tag is cleared when the transaction ends.
+	require.NoError(t, txn.Rollback())
+	txn.changeToInvalid()
+	future = store.GetOracle().GetTimestampAsync(context.Background(), &oracle.Option{TxnScope: kv.GlobalTxnScope})
+	txn.changeToPending(&txnFuture{future: future, store: store, txnScope: kv.GlobalTxnScope})
+	require.NoError(t, txn.changePendingToValid(context.Background()))
+	require.Nil(t, txn.mu.TxnInfo.RequestTag)
+	txn.SetOption(kv.RPCInterceptor, interceptor.RPCInterceptor(recordTag))
+	txn.SetOption(kv.ResourceGroupTagger, tikvrpc.ResourceGroupTagger(topSQLTagger))
+	checkSentTag(txn, nil)
+	require.NoError(t, txn.Rollback())
+	txn.changeToInvalid()
+}
+
+func TestLazyTxnMutations(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	require.Empty(t, se.txn.Mutations())
+
+	m1 := se.StmtGetMutation(1)
+	m1.InsertedRows = append(m1.InsertedRows, []byte("a"))
+	se.StmtGetMutation(2)
+	mutations := se.txn.Mutations()
+	require.Len(t, mutations, 2)
+	require.Equal(t, [][]byte{[]byte("a")}, mutations[1].InsertedRows)
+	// The returned mutations are copies, modifying them doesn't affect the transaction.
+	require.NotSame(t, m1, mutations[1])
+	mutations[1].InsertedRows[0][0] = 'b'
+	mutations[1].InsertedRows = append(mutations[1].InsertedRows, []byte("c"))
+	require.Equal(t, [][]byte{[]byte("a")}, m1.InsertedRows)
+	delete(mutations, 1)
+	se.StmtGetMutation(3)
+	require.Len(t, mutations, 1)
+	require.Len(t, se.txn.Mutations(), 3)
+}
+
+func TestStmtFlushIfNeeded(t *testing.T) {
+	store, dom := createStoreAndBootstrap(t)
+	defer func() { require.NoError(t, store.Close()) }()
+	defer dom.Close()
+	se, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se, "use test")
+	mustExec(t, se, "create table s (a int)")
+	mustExec(t, se, "create table t (a int, unique key(a))")
+	for i := 0; i < 100; i++ {
+		mustExec(t, se, fmt.Sprintf("insert into s values (%d)", i))
+	}
+	countRows := func(tbl string) string {
+		rs := mustExec(t, se, "select count(*), ifnull(sum(a), 0) from "+tbl)
+		rows, err := ResultSetToStringSlice(context.Background(), se, rs)
+		require.NoError(t, err)
+		return rows[0][0] + " " + rows[0][1]
+	}
+
+	// It's disabled by default.
+	mustExec(t, se, "begin")
+	txn, err := se.Txn(true)
+	require.NoError(t, err)
+	buf := txn.GetMemBuffer()
+	require.NoError(t, buf.Set(kv.Key("k1"), []byte("v1")))
+	require.NoError(t, se.StmtFlushIfNeeded())
+	require.False(t, se.txn.stmtBuf.flushed)
+	// The changes staged before the flush is enabled aren't flushed.
+	se.txn.SetStmtBufferFlushThreshold(2)
+	require.NoError(t, buf.Set(kv.Key("k2"), []byte("v2")))
+	require.NoError(t, buf.Set(kv.Key("k3"), []byte("v3")))
+	require.NoError(t, se.StmtFlushIfNeeded())
+	require.False(t, se.txn.stmtBuf.flushed)
+	se.StmtRollback()
+
+	// The flushed changes are out of the staging buffer, but the statement is still rolled back to its start.
+	require.NoError(t, buf.Set(kv.Key("k1"), []byte("v1")))
+	require.NoError(t, buf.Set(kv.Key("k2"), []byte("v2")))
+	require.NoError(t, buf.Set(kv.Key("k3"), []byte("v3")))
+	require.NoError(t, se.StmtFlushIfNeeded())
+	require.True(t, se.txn.stmtBuf.flushed)
+	require.Equal(t, 3, buf.Len())
+	require.NoError(t, buf.Set(kv.Key("k4"), []byte("v4")))
+	require.NoError(t, se.StmtFlushIfNeeded())
+	require.Equal(t, 4, se.txn.countHint())
+	se.StmtRollback()
+	require.False(t, se.txn.stmtBuf.flushed)
+	require.Zero(t, buf.Len())
+	for _, k := range []string{"k1", "k2", "k3", "k4"} {
+		_, err = buf.Get(context.Background(), kv.Key(k))
+		require.True(t, kv.IsErrNotFound(err))
+	}
+
+	// All the changes are committed by StmtCommit.
+	for _, k := range []string{"k1", "k2", "k3", "k4"} {
+		require.NoError(t, buf.Set(kv.Key(k), []byte(k)))
+		require.NoError(t, se.StmtFlushIfNeeded())
+	}
+	require.NoError(t, se.StmtCommit())
+	for _, k := range []string{"k1", "k2", "k3", "k4"} {
+		v, err := buf.Get(context.Background(), kv.Key(k))
+		require.NoError(t, err)
+		require.Equal(t, []byte(k), v)
+	}
+	mustExec(t, se, "rollback")
+
+	mustExec(t, se, "set @@tidb_stmt_buffer_flush_threshold = 10")
+	mustExec(t, se, "set @@tidb_max_chunk_size = 32")
+	mustExec(t, se, "begin")
+	mustExec(t, se, "insert into t select a from s")
+	// The failed statement is rolled back as a whole.
+	_, err = exec(se, "insert into t select a + 50 from s")
+	require.True(t, kv.ErrKeyExists.Equal(err))
+	mustExec(t, se, "commit")
+	require.Equal(t, "100 4950", countRows("t"))
+	mustExec(t, se, "delete from t")
+
+	// The rows of the flushed changes are counted by the row limit of the statement.
+	mustExec(t, se, "set @@tidb_stmt_table_row_limit = 80")
+	_, err = exec(se, "insert into t select a from s")
+	require.True(t, ErrStmtTableRowLimitExceeded.Equal(err))
+	require.Equal(t, "0 0", countRows("t"))
+	mustExec(t, se, "set @@tidb_stmt_table_row_limit = 0")
+
+	// The keys of the flushed changes are locked in the pessimistic transaction.
+	mustExec(t, se, "begin pessimistic")
+	mustExec(t, se, "insert into t select a from s")
+	se2, err := createSession(store)
+	require.NoError(t, err)
+	mustExec(t, se2, "use test")
+	mustExec(t, se2, "set @@innodb_lock_wait_timeout = 1")
+	mustExec(t, se2, "begin pessimistic")
+	_, err = exec(se2, "insert into t values (1)")
+	require.Error(t, err)
+	mustExec(t, se2, "rollback")
+	mustExec(t, se, "commit")
+	require.Equal(t, "100 4950", countRows("t"))
+}
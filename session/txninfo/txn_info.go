@@ -15,6 +15,7 @@
 package txninfo
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"time"
 
@@ -137,6 +138,8 @@ type TxnInfo struct {
 	// The following fields are immutable and can be safely read across threads.
 
 	StartTS uint64
+	// When the transaction became valid by the wall clock, unlike StartTS, which is a TSO.
+	StartTime time.Time
 	// Digest of SQL currently running
 	CurrentSQLDigest string
 	// Digests of all SQLs executed in the transaction.
@@ -155,10 +158,23 @@ type TxnInfo struct {
 		Valid bool
 		time.Time
 	}
+	// The first key of the locking request the transaction is blocked on. Nil if State is not TxnLockAcquiring.
+	WaitingForKey []byte
+	// When the transaction acquired its first lock, it's zero if the transaction doesn't hold any lock.
+	FirstLockTime time.Time
+	// How many times the transaction failed to acquire the locks.
+	LockFailureCount uint64
+	// When the most recent lock acquisition failed, it's zero if the most recent one succeeded.
+	LastLockFailureTime time.Time
+	// The count of the keys locked by each statement digest, see LazyTxn.SetTrackLockDigests. It's replaced rather
+	// than modified when it's updated, so it can be read from a copy of TxnInfo.
+	LockedKeysByDigest map[string]uint64
 	// How many entries are in MemDB
 	EntriesCount uint64
 	// MemDB used memory
 	EntriesSize uint64
+	// The tag attached to the transaction by the application, see LazyTxn.SetRequestTag.
+	RequestTag []byte
 
 	// The following fields will be filled in `session` instead of `LazyTxn`
 
@@ -170,6 +186,95 @@ type TxnInfo struct {
 	CurrentDB string
 }
 
+// HasLocks returns whether the transaction has acquired any lock.
+func (info *TxnInfo) HasLocks() bool {
+	return !info.FirstLockTime.IsZero()
+}
+
+// LongLockHolders returns the transactions which have held locks longer than the threshold until now.
+// The infos should be the snapshots of the active transactions, like the ones returned by
+// util.SessionManager.ShowTxnList.
+func LongLockHolders(infos []*TxnInfo, threshold time.Duration, now time.Time) []*TxnInfo {
+	var holders []*TxnInfo
+	for _, info := range infos {
+		if info != nil && info.HasLocks() && now.Sub(info.FirstLockTime) > threshold {
+			holders = append(holders, info)
+		}
+	}
+	return holders
+}
+
+// txnInfoJSON is the JSON representation of TxnInfo for the diagnostics.
+type txnInfoJSON struct {
+	StartTS             uint64            `json:"start_ts"`
+	StartTime           string            `json:"start_time,omitempty"`
+	CurrentSQLDigest    string            `json:"current_sql_digest,omitempty"`
+	AllSQLDigests       []string          `json:"all_sql_digests"`
+	State               string            `json:"state"`
+	LastStateChangeTime string            `json:"last_state_change_time,omitempty"`
+	BlockStartTime      string            `json:"block_start_time,omitempty"`
+	WaitingForKey       string            `json:"waiting_for_key,omitempty"`
+	FirstLockTime       string            `json:"first_lock_time,omitempty"`
+	LockFailureCount    uint64            `json:"lock_failure_count"`
+	LastLockFailureTime string            `json:"last_lock_failure_time,omitempty"`
+	LockedKeysByDigest  map[string]uint64 `json:"locked_keys_by_digest,omitempty"`
+	EntriesCount        uint64            `json:"entries_count"`
+	EntriesSize         uint64            `json:"entries_size"`
+	RequestTag          string            `json:"request_tag,omitempty"`
+	ConnectionID        uint64            `json:"connection_id"`
+	Username            string            `json:"username,omitempty"`
+	CurrentDB           string            `json:"current_db,omitempty"`
+}
+
+func formatJSONTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// MarshalJSON implements the json.Marshaler interface, so the diagnostics share the same format of the transactions.
+// The state is rendered by TxnRunningStateStrs, and the times are formatted as RFC3339Nano and omitted if they are unset.
+func (info *TxnInfo) MarshalJSON() ([]byte, error) {
+	if info == nil {
+		return []byte("null"), nil
+	}
+	v := txnInfoJSON{
+		StartTS:             info.StartTS,
+		CurrentSQLDigest:    info.CurrentSQLDigest,
+		AllSQLDigests:       info.AllSQLDigests,
+		State:               "Unknown",
+		LastStateChangeTime: formatJSONTime(info.LastStateChangeTime),
+		FirstLockTime:       formatJSONTime(info.FirstLockTime),
+		LockFailureCount:    info.LockFailureCount,
+		LastLockFailureTime: formatJSONTime(info.LastLockFailureTime),
+		LockedKeysByDigest:  info.LockedKeysByDigest,
+		EntriesCount:        info.EntriesCount,
+		EntriesSize:         info.EntriesSize,
+		RequestTag:          string(info.RequestTag),
+		ConnectionID:        info.ConnectionID,
+		Username:            info.Username,
+		CurrentDB:           info.CurrentDB,
+	}
+	if info.StartTS != 0 {
+		v.StartTime = formatJSONTime(time.UnixMilli(oracle.ExtractPhysical(info.StartTS)))
+	}
+	// Replace nil with empty array, the same as the ALL_SQL_DIGESTS column.
+	if v.AllSQLDigests == nil {
+		v.AllSQLDigests = []string{}
+	}
+	if info.State >= 0 && int(info.State) < len(TxnRunningStateStrs) {
+		v.State = TxnRunningStateStrs[info.State]
+	}
+	if info.BlockStartTime.Valid {
+		v.BlockStartTime = formatJSONTime(info.BlockStartTime.Time)
+	}
+	if len(info.WaitingForKey) > 0 {
+		v.WaitingForKey = hex.EncodeToString(info.WaitingForKey)
+	}
+	return json.Marshal(v)
+}
+
 var columnValueGetterMap = map[string]func(*TxnInfo) types.Datum{
 	IDStr: func(info *TxnInfo) types.Datum {
 		return types.NewDatum(info.StartTS)
@@ -121,9 +121,14 @@ type Context interface {
 	HasDirtyContent(tid int64) bool
 
 	// StmtCommit flush all changes by the statement to the underlying transaction.
-	StmtCommit()
+	// It returns an error and discards the changes if the statement exceeds the limits, e.g. tidb_stmt_table_row_limit.
+	StmtCommit() error
 	// StmtRollback provides statement level rollback.
 	StmtRollback()
+	// StmtFlushIfNeeded flushes the changes staged by the statement to the transaction in the middle of the statement
+	// if there are more than tidb_stmt_buffer_flush_threshold changes since the last flush. The statement can still be
+	// rolled back to its start.
+	StmtFlushIfNeeded() error
 	// StmtGetMutation gets the binlog mutation for current statement.
 	StmtGetMutation(int64) *binlog.TableMutation
 	// IsDDLOwner checks whether this session is DDL owner.
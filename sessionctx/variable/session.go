@@ -937,6 +937,10 @@ type SessionVars struct {
 	// LowResolutionTSO is used for reading data with low resolution TSO which is updated once every two seconds.
 	LowResolutionTSO bool
 
+	// TxnStaleness is the bounded staleness of the start TS of the new transactions. If it's non-zero, the start TS is
+	// computed from the low resolution timestamp without requesting a fresh TSO, and the write statements are refused.
+	TxnStaleness time.Duration
+
 	// MaxExecutionTime is the timeout for select statement, in milliseconds.
 	// If the value is 0, timeouts are not enabled.
 	// See https://dev.mysql.com/doc/refman/5.7/en/server-system-variables.html#sysvar_max_execution_time
@@ -1227,6 +1231,23 @@ type SessionVars struct {
 
 	// GeneralPlanCacheSize controls the size of general plan cache.
 	GeneralPlanCacheSize uint64
+
+	// StmtTableRowLimit is the max count of the rows a statement can mutate in a single table, 0 means unlimited.
+	StmtTableRowLimit int64
+
+	// LongTxnLogThreshold is the duration of a transaction to log its statement digests when it ends, 0 means disabled.
+	LongTxnLogThreshold time.Duration
+
+	// BinlogTxnSizeLimit is the max estimated size in bytes of the binlog of a transaction, 0 means unlimited.
+	BinlogTxnSizeLimit int64
+
+	// TxnMemBufferThreshold is the size in bytes of the mem buffer of a transaction to emit a warning when it's
+	// crossed, 0 means disabled.
+	TxnMemBufferThreshold int64
+
+	// StmtBufferFlushThreshold is the number of the entries staged by a statement since the last flush to flush the
+	// statement buffer to the transaction in the middle of the statement, 0 means disabled.
+	StmtBufferFlushThreshold int64
 }
 
 // GetPreparedStmtByName returns the prepared statement specified by stmtName.
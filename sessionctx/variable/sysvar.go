@@ -1780,6 +1780,44 @@ var defaultSysVars = []*SysVar{
 		DDLDiskQuota.Store(TidbOptInt64(val, DefTiDBDDLDiskQuota))
 		return nil
 	}},
+	{Scope: ScopeGlobal, Name: TiDBDDLOrphanedJobPolicy, Value: DefTiDBDDLOrphanedJobPolicy, Type: TypeEnum, PossibleValues: []string{OrphanedJobPolicyReport, OrphanedJobPolicyCancel}, GetGlobal: func(sv *SessionVars) (string, error) {
+		return DDLOrphanedJobPolicy.Load(), nil
+	}, SetGlobal: func(s *SessionVars, val string) error {
+		DDLOrphanedJobPolicy.Store(val)
+		return nil
+	}},
+	{Scope: ScopeGlobal, Name: TiDBTxnLargeWriteSetWarningThreshold, Value: strconv.Itoa(DefTiDBTxnLargeWriteSetWarningThreshold), Type: TypeInt, MinValue: 0, MaxValue: math.MaxInt64, GetGlobal: func(sv *SessionVars) (string, error) {
+		return strconv.FormatInt(TxnLargeWriteSetWarningThreshold.Load(), 10), nil
+	}, SetGlobal: func(s *SessionVars, val string) error {
+		TxnLargeWriteSetWarningThreshold.Store(TidbOptInt64(val, DefTiDBTxnLargeWriteSetWarningThreshold))
+		return nil
+	}},
+	{Scope: ScopeGlobal, Name: TiDBDDLReorgFollowerRead, Value: BoolToOnOff(DefTiDBDDLReorgFollowerRead), Type: TypeBool, GetGlobal: func(sv *SessionVars) (string, error) {
+		return BoolToOnOff(DDLReorgFollowerRead.Load()), nil
+	}, SetGlobal: func(s *SessionVars, val string) error {
+		DDLReorgFollowerRead.Store(TiDBOptOn(val))
+		return nil
+	}},
+	{Scope: ScopeGlobal | ScopeSession, Name: TiDBStmtTableRowLimit, Value: strconv.Itoa(DefTiDBStmtTableRowLimit), Type: TypeInt, MinValue: 0, MaxValue: math.MaxInt64, SetSession: func(s *SessionVars, val string) error {
+		s.StmtTableRowLimit = TidbOptInt64(val, DefTiDBStmtTableRowLimit)
+		return nil
+	}},
+	{Scope: ScopeGlobal | ScopeSession, Name: TiDBLongTxnLogThreshold, Value: strconv.Itoa(DefTiDBLongTxnLogThreshold), Type: TypeInt, MinValue: 0, MaxValue: math.MaxInt64, SetSession: func(s *SessionVars, val string) error {
+		s.LongTxnLogThreshold = time.Duration(TidbOptInt64(val, DefTiDBLongTxnLogThreshold)) * time.Millisecond
+		return nil
+	}},
+	{Scope: ScopeGlobal | ScopeSession, Name: TiDBBinlogTxnSizeLimit, Value: strconv.Itoa(DefTiDBBinlogTxnSizeLimit), Type: TypeInt, MinValue: 0, MaxValue: math.MaxInt64, SetSession: func(s *SessionVars, val string) error {
+		s.BinlogTxnSizeLimit = TidbOptInt64(val, DefTiDBBinlogTxnSizeLimit)
+		return nil
+	}},
+	{Scope: ScopeGlobal | ScopeSession, Name: TiDBTxnMemBufferThreshold, Value: strconv.Itoa(DefTiDBTxnMemBufferThreshold), Type: TypeInt, MinValue: 0, MaxValue: math.MaxInt64, SetSession: func(s *SessionVars, val string) error {
+		s.TxnMemBufferThreshold = TidbOptInt64(val, DefTiDBTxnMemBufferThreshold)
+		return nil
+	}},
+	{Scope: ScopeGlobal | ScopeSession, Name: TiDBStmtBufferFlushThreshold, Value: strconv.Itoa(DefTiDBStmtBufferFlushThreshold), Type: TypeInt, MinValue: 0, MaxValue: math.MaxInt64, SetSession: func(s *SessionVars, val string) error {
+		s.StmtBufferFlushThreshold = TidbOptInt64(val, DefTiDBStmtBufferFlushThreshold)
+		return nil
+	}},
 }
 
 // FeedbackProbability points to the FeedbackProbability in statistics package.
@@ -797,6 +797,27 @@ const (
 	TiDBDDLEnableFastReorg = "tidb_ddl_enable_fast_reorg"
 	// TiDBDDLDiskQuota used to set disk quota for lightning add index.
 	TiDBDDLDiskQuota = "tidb_ddl_disk_quota"
+	// TiDBDDLOrphanedJobPolicy is used to control how to handle the pending DDL jobs whose schemas have been dropped.
+	// "REPORT" only reports them, "CANCEL" cancels them automatically.
+	TiDBDDLOrphanedJobPolicy = "tidb_ddl_orphaned_job_policy"
+	// TiDBTxnLargeWriteSetWarningThreshold is the size in bytes of the write set of a transaction to emit a warning,
+	// it should be smaller than the txn-total-size-limit. 0 means the warning is disabled.
+	TiDBTxnLargeWriteSetWarningThreshold = "tidb_txn_large_write_set_warning_threshold"
+	// TiDBDDLReorgFollowerRead indicates whether to read the rows from the follower replicas when backfilling.
+	TiDBDDLReorgFollowerRead = "tidb_ddl_reorg_follower_read"
+	// TiDBStmtTableRowLimit is the max count of the rows a statement can mutate in a single table, 0 means unlimited.
+	TiDBStmtTableRowLimit = "tidb_stmt_table_row_limit"
+	// TiDBLongTxnLogThreshold is the duration in milliseconds of a transaction to log its statement digests and
+	// the durations of its states when it ends. 0 means the log is disabled.
+	TiDBLongTxnLogThreshold = "tidb_long_txn_log_threshold"
+	// TiDBBinlogTxnSizeLimit is the max estimated size in bytes of the binlog of a transaction, 0 means unlimited.
+	TiDBBinlogTxnSizeLimit = "tidb_binlog_txn_size_limit"
+	// TiDBTxnMemBufferThreshold is the size in bytes of the mem buffer of a transaction to emit a warning when it's
+	// crossed, 0 means disabled.
+	TiDBTxnMemBufferThreshold = "tidb_txn_mem_buffer_threshold"
+	// TiDBStmtBufferFlushThreshold is the number of the entries staged by a statement since the last flush to flush
+	// the statement buffer to the transaction in the middle of the statement, 0 means disabled.
+	TiDBStmtBufferFlushThreshold = "tidb_stmt_buffer_flush_threshold"
 )
 
 // TiDB intentional limits
@@ -1013,6 +1034,14 @@ const (
 	DefTiDBEnableTmpStorageOnOOM                   = true
 	DefTiDBEnableFastReorg                         = false
 	DefTiDBDDLDiskQuota                            = 100 * 1024 * 1024 * 1024 // 100GB
+	DefTiDBDDLOrphanedJobPolicy                    = OrphanedJobPolicyReport
+	DefTiDBTxnLargeWriteSetWarningThreshold        = 0
+	DefTiDBDDLReorgFollowerRead                    = false
+	DefTiDBStmtTableRowLimit                       = 0
+	DefTiDBLongTxnLogThreshold                     = 0
+	DefTiDBBinlogTxnSizeLimit                      = 0
+	DefTiDBTxnMemBufferThreshold                   = 1 << 30 // 1GB
+	DefTiDBStmtBufferFlushThreshold                = 0
 	DefExecutorConcurrency                         = 5
 	DefTiDBEnableGeneralPlanCache                  = false
 	DefTiDBGeneralPlanCacheSize                    = 100
@@ -1066,6 +1095,19 @@ var (
 	EnableFastReorg = atomic.NewBool(DefTiDBEnableFastReorg)
 	// DDLDiskQuota is the temporary variable for set disk quota for lightning
 	DDLDiskQuota = atomic.NewInt64(DefTiDBDDLDiskQuota)
+	// DDLOrphanedJobPolicy is the policy to handle the pending DDL jobs whose schemas have been dropped.
+	DDLOrphanedJobPolicy = atomic.NewString(DefTiDBDDLOrphanedJobPolicy)
+	// TxnLargeWriteSetWarningThreshold is the size in bytes of the write set of a transaction to emit a warning.
+	TxnLargeWriteSetWarningThreshold = atomic.NewInt64(DefTiDBTxnLargeWriteSetWarningThreshold)
+	// DDLReorgFollowerRead indicates whether to read the rows from the follower replicas when backfilling.
+	DDLReorgFollowerRead = atomic.NewBool(DefTiDBDDLReorgFollowerRead)
+)
+
+const (
+	// OrphanedJobPolicyReport only reports the orphaned DDL jobs.
+	OrphanedJobPolicyReport = "REPORT"
+	// OrphanedJobPolicyCancel cancels the orphaned DDL jobs automatically.
+	OrphanedJobPolicyCancel = "CANCEL"
 )
 
 var (
@@ -443,13 +443,21 @@ func newOracleFuture(ctx context.Context, sctx sessionctx.Context, scope string)
 		ctx = opentracing.ContextWithSpan(ctx, span1)
 	}
 
+	oracleStore := sctx.GetStore().GetOracle()
+	option := &oracle.Option{TxnScope: scope}
+
+	// The start TS is computed from the low resolution timestamp with the staleness, so the fresh TSO is only requested
+	// when it's waited for as the fallback.
+	if sctx.GetSessionVars().TxnStaleness > 0 {
+		return funcFuture(func() (uint64, error) {
+			return oracleStore.GetTimestamp(ctx, option)
+		})
+	}
+
 	failpoint.Inject("requestTsoFromPD", func() {
 		sessiontxn.TsoRequestCountInc(sctx)
 	})
 
-	oracleStore := sctx.GetStore().GetOracle()
-	option := &oracle.Option{TxnScope: scope}
-
 	if sctx.GetSessionVars().LowResolutionTSO {
 		return oracleStore.GetLowResolutionTimestampAsync(ctx, option)
 	}
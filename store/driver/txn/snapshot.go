@@ -22,6 +22,7 @@ import (
 	"github.com/pingcap/tidb/kv"
 	derr "github.com/pingcap/tidb/store/driver/error"
 	"github.com/pingcap/tidb/store/driver/options"
+	"github.com/pingcap/tidb/util/resourcegrouptag"
 	"github.com/tikv/client-go/v2/tikvrpc"
 	"github.com/tikv/client-go/v2/tikvrpc/interceptor"
 	"github.com/tikv/client-go/v2/txnkv"
@@ -33,11 +34,13 @@ type tikvSnapshot struct {
 	*txnsnapshot.KVSnapshot
 	// customRetrievers stores all custom retrievers, it is sorted
 	interceptor kv.SnapshotInterceptor
+	// requestTag is the kv.RequestTag of the transaction of the snapshot.
+	requestTag []byte
 }
 
 // NewSnapshot creates a kv.Snapshot with txnsnapshot.KVSnapshot.
 func NewSnapshot(snapshot *txnsnapshot.KVSnapshot) kv.Snapshot {
-	return &tikvSnapshot{snapshot, nil}
+	return &tikvSnapshot{snapshot, nil, nil}
 }
 
 // BatchGet gets all the keys' value from kv-server and returns a map contains key/value pairs.
@@ -115,9 +118,9 @@ func (s *tikvSnapshot) SetOption(opt int, val interface{}) {
 	case kv.MatchStoreLabels:
 		s.KVSnapshot.SetMatchStoreLabels(val.([]*metapb.StoreLabel))
 	case kv.ResourceGroupTag:
-		s.KVSnapshot.SetResourceGroupTag(val.([]byte))
+		s.KVSnapshot.SetResourceGroupTag(appendRequestTag(val.([]byte), s.requestTag))
 	case kv.ResourceGroupTagger:
-		s.KVSnapshot.SetResourceGroupTagger(val.(tikvrpc.ResourceGroupTagger))
+		s.KVSnapshot.SetResourceGroupTagger(appendRequestTagger(val.(tikvrpc.ResourceGroupTagger), s.requestTag))
 	case kv.ReadReplicaScope:
 		s.KVSnapshot.SetReadReplicaScope(val.(string))
 	case kv.SnapInterceptor:
@@ -161,3 +164,31 @@ func getTiKVPriority(pri int) txnutil.Priority {
 		return txnutil.PriorityNormal
 	}
 }
+
+// appendRequestTag returns the resource group tag with the request tag appended, a nil tag is kept nil so the tagger
+// is used instead.
+func appendRequestTag(tag, requestTag []byte) []byte {
+	if tag == nil {
+		return nil
+	}
+	return resourcegrouptag.AppendRequestTag(tag, requestTag)
+}
+
+// appendRequestTagger returns the tagger appending the request tag to the resource group tag set by the tagger.
+func appendRequestTagger(tagger tikvrpc.ResourceGroupTagger, requestTag []byte) tikvrpc.ResourceGroupTagger {
+	if len(requestTag) == 0 {
+		return tagger
+	}
+	return func(req *tikvrpc.Request) {
+		if req == nil {
+			return
+		}
+		// The tagger may be called again on the retried request, the previous tag is cleared so the request tag is
+		// appended once.
+		req.ResourceGroupTag = nil
+		if tagger != nil {
+			tagger(req)
+		}
+		req.ResourceGroupTag = resourcegrouptag.AppendRequestTag(req.ResourceGroupTag, requestTag)
+	}
+}
@@ -45,6 +45,10 @@ type tikvTxn struct {
 	snapshotInterceptor kv.SnapshotInterceptor
 	// columnMapsCache is a cache used for the mutation checker
 	columnMapsCache interface{}
+	// requestTag is appended to the resource group tags of the requests, see kv.RequestTag.
+	requestTag          []byte
+	resourceGroupTag    []byte
+	resourceGroupTagger tikvrpc.ResourceGroupTagger
 }
 
 // NewTiKVTxn returns a new Transaction.
@@ -55,7 +59,7 @@ func NewTiKVTxn(txn *tikv.KVTxn) kv.Transaction {
 	totalLimit := atomic.LoadUint64(&kv.TxnTotalSizeLimit)
 	txn.GetUnionStore().SetEntrySizeLimit(entryLimit, totalLimit)
 
-	return &tikvTxn{txn, make(map[int64]*model.TableInfo), nil, nil}
+	return &tikvTxn{txn, make(map[int64]*model.TableInfo), nil, nil, nil, nil, nil}
 }
 
 func (txn *tikvTxn) GetTableInfo(id int64) *model.TableInfo {
@@ -93,7 +97,7 @@ func (txn *tikvTxn) RollbackMemDBToCheckpoint(savepoint *tikv.MemDBCheckpoint) {
 
 // GetSnapshot returns the Snapshot binding to this transaction.
 func (txn *tikvTxn) GetSnapshot() kv.Snapshot {
-	return &tikvSnapshot{txn.KVTxn.GetSnapshot(), txn.snapshotInterceptor}
+	return &tikvSnapshot{txn.KVTxn.GetSnapshot(), txn.snapshotInterceptor, txn.requestTag}
 }
 
 // Iter creates an Iterator positioned on the first entry that k <= entry's key.
@@ -237,9 +241,11 @@ func (txn *tikvTxn) SetOption(opt int, val interface{}) {
 	case kv.MatchStoreLabels:
 		txn.KVTxn.GetSnapshot().SetMatchStoreLabels(val.([]*metapb.StoreLabel))
 	case kv.ResourceGroupTag:
-		txn.KVTxn.SetResourceGroupTag(val.([]byte))
+		txn.resourceGroupTag = val.([]byte)
+		txn.KVTxn.SetResourceGroupTag(appendRequestTag(txn.resourceGroupTag, txn.requestTag))
 	case kv.ResourceGroupTagger:
-		txn.KVTxn.SetResourceGroupTagger(val.(tikvrpc.ResourceGroupTagger))
+		txn.resourceGroupTagger = val.(tikvrpc.ResourceGroupTagger)
+		txn.KVTxn.SetResourceGroupTagger(appendRequestTagger(txn.resourceGroupTagger, txn.requestTag))
 	case kv.KVFilter:
 		txn.KVTxn.SetKVFilter(val.(tikv.KVFilter))
 	case kv.SnapInterceptor:
@@ -258,6 +264,10 @@ func (txn *tikvTxn) SetOption(opt int, val interface{}) {
 		txn.KVTxn.SetRequestSourceType(val.(string))
 	case kv.ReplicaReadAdjuster:
 		txn.KVTxn.GetSnapshot().SetReplicaReadAdjuster(val.(txnkv.ReplicaReadAdjuster))
+	case kv.RequestTag:
+		txn.requestTag = val.([]byte)
+		txn.KVTxn.SetResourceGroupTag(appendRequestTag(txn.resourceGroupTag, txn.requestTag))
+		txn.KVTxn.SetResourceGroupTagger(appendRequestTagger(txn.resourceGroupTagger, txn.requestTag))
 	}
 }
 
@@ -271,6 +281,8 @@ func (txn *tikvTxn) GetOption(opt int) interface{} {
 		return txn.columnMapsCache
 	case kv.RequestSourceType:
 		return txn.RequestSourceType
+	case kv.RequestTag:
+		return txn.requestTag
 	default:
 		return nil
 	}
@@ -389,6 +389,10 @@ var (
 	ErrCannotCancelDDLJob = ClassDDL.NewStd(mysql.ErrCannotCancelDDLJob)
 	// ErrDDLSetting returns when failing to enable/disable DDL
 	ErrDDLSetting = ClassDDL.NewStd(mysql.ErrDDLSetting)
+	// ErrCorruptJobMeta returns when the meta of a DDL job in the job table can't be decoded.
+	ErrCorruptJobMeta = ClassDDL.NewStd(mysql.ErrCorruptJobMeta)
+	// ErrMoveProcessingJob returns when the DDL jobs are moved from the job table to the queue while any job is processing.
+	ErrMoveProcessingJob = ClassDDL.NewStd(mysql.ErrMoveProcessingJob)
 
 	// ErrColumnInChange indicates there is modification on the column in parallel.
 	ErrColumnInChange = ClassDDL.NewStd(mysql.ErrColumnInChange)
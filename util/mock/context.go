@@ -345,12 +345,19 @@ func (*Context) GetTxnWriteThroughputSLI() *sli.TxnWriteThroughputSLI {
 }
 
 // StmtCommit implements the sessionctx.Context interface.
-func (*Context) StmtCommit() {}
+func (*Context) StmtCommit() error {
+	return nil
+}
 
 // StmtRollback implements the sessionctx.Context interface.
 func (*Context) StmtRollback() {
 }
 
+// StmtFlushIfNeeded implements the sessionctx.Context interface.
+func (*Context) StmtFlushIfNeeded() error {
+	return nil
+}
+
 // StmtGetMutation implements the sessionctx.Context interface.
 func (*Context) StmtGetMutation(_ int64) *binlog.TableMutation {
 	return nil
@@ -15,6 +15,8 @@
 package resourcegrouptag
 
 import (
+	"encoding/binary"
+
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/coprocessor"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
@@ -57,6 +59,52 @@ func DecodeResourceGroupTag(data []byte) (sqlDigest []byte, err error) {
 	return tag.SqlDigest, nil
 }
 
+// requestTagField is the field number of the request tag appended to the resource group tag. It isn't a field of
+// tipb.ResourceGroupTag, so the decoders of the resource group tag, e.g. Top SQL of TiKV, skip it as an unknown field.
+const requestTagField = 100
+
+// AppendRequestTag appends the request tag of the application to the resource group tag.
+func AppendRequestTag(tag, requestTag []byte) []byte {
+	if len(requestTag) == 0 {
+		return tag
+	}
+	var buf [binary.MaxVarintLen64]byte
+	b := make([]byte, 0, len(tag)+len(requestTag)+2*binary.MaxVarintLen64)
+	b = append(b, tag...)
+	b = append(b, buf[:binary.PutUvarint(buf[:], requestTagField<<3|2)]...)
+	b = append(b, buf[:binary.PutUvarint(buf[:], uint64(len(requestTag)))]...)
+	return append(b, requestTag...)
+}
+
+// DecodeRequestTag decodes a resource group tag and return the request tag appended by AppendRequestTag.
+func DecodeRequestTag(data []byte) (requestTag []byte, err error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	tag := &tipb.ResourceGroupTag{}
+	if err = tag.Unmarshal(data); err != nil {
+		return nil, errors.Errorf("invalid resource group tag data %x", data)
+	}
+	// The unknown fields are kept in XXX_unrecognized, the request tag is the only one appended as bytes.
+	b := tag.XXX_unrecognized
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 || key&7 != 2 {
+			return nil, errors.Errorf("invalid resource group tag data %x", data)
+		}
+		b = b[n:]
+		l, n := binary.Uvarint(b)
+		if n <= 0 || l > uint64(len(b)-n) {
+			return nil, errors.Errorf("invalid resource group tag data %x", data)
+		}
+		if key>>3 == requestTagField {
+			return b[n : n+int(l)], nil
+		}
+		b = b[n+int(l):]
+	}
+	return nil, nil
+}
+
 // GetResourceGroupLabelByKey determines the tipb.ResourceGroupTagLabel of key.
 func GetResourceGroupLabelByKey(key []byte) tipb.ResourceGroupTagLabel {
 	switch rowindexcodec.GetKeyKind(key) {
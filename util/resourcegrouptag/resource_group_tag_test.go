@@ -59,6 +59,39 @@ func TestResourceGroupTagEncoding(t *testing.T) {
 	require.Equal(t, sqlDigest.Bytes(), decodedSQLDigest)
 }
 
+func TestRequestTagEncoding(t *testing.T) {
+	sqlDigest := parser.NewDigest(genRandHex(64))
+	planDigest := parser.NewDigest(genRandHex(64))
+	tag := EncodeResourceGroupTag(sqlDigest, planDigest, tipb.ResourceGroupTagLabel_ResourceGroupTagLabelRow)
+	require.Equal(t, tag, AppendRequestTag(tag, nil))
+
+	requestTag, err := DecodeRequestTag(tag)
+	require.NoError(t, err)
+	require.Nil(t, requestTag)
+
+	// The digests and the label are still decoded after the request tag is appended.
+	composed := AppendRequestTag(tag, []byte("request-1"))
+	requestTag, err = DecodeRequestTag(composed)
+	require.NoError(t, err)
+	require.Equal(t, []byte("request-1"), requestTag)
+	decoded := &tipb.ResourceGroupTag{}
+	require.NoError(t, decoded.Unmarshal(composed))
+	require.Equal(t, sqlDigest.Bytes(), decoded.SqlDigest)
+	require.Equal(t, planDigest.Bytes(), decoded.PlanDigest)
+	require.Equal(t, tipb.ResourceGroupTagLabel_ResourceGroupTagLabelRow, decoded.GetLabel())
+
+	// The request tag is appended even if there is no resource group tag.
+	requestTag, err = DecodeRequestTag(AppendRequestTag(nil, []byte("request-2")))
+	require.NoError(t, err)
+	require.Equal(t, []byte("request-2"), requestTag)
+	decodedSQLDigest, err := DecodeResourceGroupTag(AppendRequestTag(nil, []byte("request-2")))
+	require.NoError(t, err)
+	require.Len(t, decodedSQLDigest, 0)
+
+	_, err = DecodeRequestTag([]byte{0xff})
+	require.Error(t, err)
+}
+
 func TestResourceGroupTagEncodingPB(t *testing.T) {
 	digest1 := genDigest("abc")
 	digest2 := genDigest("abcdefg")